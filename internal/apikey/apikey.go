@@ -1,26 +1,46 @@
 package apikey
 
 import (
-	"log"
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
 
-// Get obtains the API key from a flag or a default env var, and
-// returns it. It fails with log.Fatal if neither method produces a non-empty
-// key.
-func Get(cmd *cobra.Command) string {
+// envVars lists the environment variables the API key is looked up in, in
+// order of precedence.
+var envVars = []string{"GEMINI_API_KEY", "API_KEY"}
+
+// Get obtains the API key and returns it. The key is taken from the first
+// of these that produces a non-empty value:
+//
+//  1. The --key flag
+//  2. The GEMINI_API_KEY or API_KEY env vars
+//  3. The ~/.config/gemini-cli-key file
+//
+// It returns an error if neither method produces a key.
+func Get(cmd *cobra.Command) (string, error) {
 	token, _ := cmd.Flags().GetString("key")
 	if len(token) > 0 {
-		return token
+		return token, nil
+	}
+
+	for _, v := range envVars {
+		if key := os.Getenv(v); len(key) > 0 {
+			return key, nil
+		}
 	}
 
-	key := os.Getenv("GEMINI_API_KEY")
-	if len(key) > 0 {
-		return key
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		b, err := os.ReadFile(filepath.Join(homeDir, ".config", "gemini-cli-key"))
+		if err == nil {
+			if key := strings.TrimSpace(string(b)); len(key) > 0 {
+				return key, nil
+			}
+		}
 	}
 
-	log.Fatal("Unable to obtain API key for Google AI; use --key or GEMINI_API_KEY env var")
-	return ""
+	return "", errors.New("Unable to obtain API key for Google AI; use --key, the GEMINI_API_KEY env var or ~/.config/gemini-cli-key")
 }
@@ -0,0 +1,67 @@
+package apikey
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func newCmd(t *testing.T, args ...string) *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Flags().String("key", "", "")
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func TestGetPrecedence(t *testing.T) {
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+	t.Setenv("GEMINI_API_KEY", "")
+	t.Setenv("API_KEY", "")
+
+	if _, err := Get(newCmd(t)); err == nil {
+		t.Errorf("got no error, want error when no key is available")
+	}
+
+	configDir := filepath.Join(homeDir, ".config")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "gemini-cli-key"), []byte("filekey\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		flagKey   string
+		geminiEnv string
+		apiEnv    string
+		want      string
+	}{
+		{"", "", "", "filekey"},
+		{"", "", "apienv", "apienv"},
+		{"", "geminienv", "apienv", "geminienv"},
+		{"flagkey", "geminienv", "apienv", "flagkey"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("GEMINI_API_KEY", tt.geminiEnv)
+		t.Setenv("API_KEY", tt.apiEnv)
+
+		var args []string
+		if tt.flagKey != "" {
+			args = append(args, "--key", tt.flagKey)
+		}
+
+		got, err := Get(newCmd(t, args...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("got key %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/spf13/cobra"
+	"google.golang.org/api/iterator"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage cached content shared by prompts",
+	Long:  strings.TrimSpace(cacheUsage),
+	Args:  cobra.ExactArgs(0),
+
+	// 'cache' is a parent of subcommands, and doesn't do anything on its own.
+	// Therefore we don't define a Run: function for it.
+}
+
+var cacheUsage = `
+Manage the content cached by the API (see
+https://ai.google.dev/gemini-api/docs/caching). A large context that several
+prompts share, like a long document, can be cached once with 'cache create';
+prompts then refer to it with --cached-content <name> instead of sending it
+again, which costs less for the cached tokens.
+
+Cached content belongs to the model it was created for, which has to be a
+specific version like gemini-1.5-flash-001; prompts using it have to pass the
+same --model. The API also requires a minimal size for cached content (e.g.
+32768 tokens).
+
+'cache clear' is about a different, local cache: it deletes the responses
+stored by prompt --cache-responses.
+`
+
+var cacheCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Cache the content of files for use by later prompts",
+	Long:  strings.TrimSpace(cacheCreateUsage),
+	Args:  cobra.ExactArgs(0),
+	RunE:  runCacheCreateCmd,
+}
+
+var cacheCreateUsage = `
+Cache the content of the files given with --file (which can be repeated) for
+the model set with --model, and print the name of the cached content, to pass
+to the --cached-content flag of prompt. Files are sent as in prompts: text
+files as text, and images, PDFs and other supported types as they are.
+
+The cached content expires after --ttl; --system caches a system instruction
+along with it, since prompts using cached content can't set their own.
+`
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the cached content",
+	Args:  cobra.ExactArgs(0),
+	RunE:  runCacheListCmd,
+}
+
+var cacheDeleteCmd = &cobra.Command{
+	Use:   "delete <name>...",
+	Short: "Delete cached content before it expires",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runCacheDeleteCmd,
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Delete the responses stored by prompt --cache-responses",
+	Args:  cobra.ExactArgs(0),
+	RunE:  runCacheClearCmd,
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheCreateCmd)
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cacheDeleteCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+
+	cacheCreateCmd.Flags().StringArray("file", nil, "file with content to cache; can be repeated")
+	cacheCreateCmd.Flags().Duration("ttl", time.Hour, "how long the cached content is kept")
+	cacheCreateCmd.Flags().String("system", "", "system instruction to cache with the content")
+	cacheCreateCmd.Flags().String("display-name", "", "display name of the cached content, shown by 'cache list'")
+	cacheCreateCmd.MarkFlagRequired("file")
+}
+
+// cachedContentName returns the resource name of the cached content named
+// name, which may omit the "cachedContents/" prefix.
+func cachedContentName(name string) string {
+	if strings.HasPrefix(name, "cachedContents/") {
+		return name
+	}
+	return "cachedContents/" + name
+}
+
+// applyCachedContent makes model use the cached content set with the
+// --cached-content flag of cmd, if any.
+func applyCachedContent(cmd *cobra.Command, model *genai.GenerativeModel) {
+	if name := mustGetStringFlag(cmd, "cached-content"); name != "" {
+		model.CachedContentName = cachedContentName(name)
+	}
+}
+
+func runCacheCreateCmd(cmd *cobra.Command, args []string) error {
+	ttl := mustGetDurationFlag(cmd, "ttl")
+	if ttl <= 0 {
+		return &usageError{fmt.Errorf("--ttl must be positive, got %v", ttl)}
+	}
+
+	content := &genai.Content{Role: "user"}
+	for _, path := range mustGetStringArrayFlag(cmd, "file") {
+		part, err := getPartFromFile(path)
+		if err != nil {
+			return err
+		}
+		content.Parts = append(content.Parts, part)
+	}
+	cc := &genai.CachedContent{
+		Model:       mustGetStringFlag(cmd, "model"),
+		DisplayName: mustGetStringFlag(cmd, "display-name"),
+		Contents:    []*genai.Content{content},
+		Expiration:  genai.ExpireTimeOrTTL{TTL: ttl},
+	}
+	if system := mustGetStringFlag(cmd, "system"); system != "" {
+		cc.SystemInstruction = genai.NewUserContent(genai.Text(system))
+	}
+
+	ctx, stop := newCommandContext()
+	defer stop()
+	ctx, cancel := withRequestTimeout(ctx, cmd)
+	defer cancel()
+	client, err := newClient(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	created, err := client.CreateCachedContent(ctx, cc)
+	if err != nil {
+		return fmt.Errorf("error creating cached content: %w", requestError(ctx, cmd, err))
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), created.Name)
+	return nil
+}
+
+func runCacheListCmd(cmd *cobra.Command, args []string) error {
+	ctx, stop := newCommandContext()
+	defer stop()
+	ctx, cancel := withRequestTimeout(ctx, cmd)
+	defer cancel()
+	client, err := newClient(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var contents []*genai.CachedContent
+	iter := client.ListCachedContents(ctx)
+	for {
+		cc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return requestError(ctx, cmd, err)
+		}
+		contents = append(contents, cc)
+	}
+	return printCachedContents(cmd.OutOrStdout(), contents)
+}
+
+// printCachedContents writes a table of contents to w.
+func printCachedContents(w io.Writer, contents []*genai.CachedContent) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Name\tModel\tDisplay Name\tTokens\tExpires\n")
+	for _, cc := range contents {
+		tokens := "-"
+		if cc.UsageMetadata != nil {
+			tokens = fmt.Sprint(cc.UsageMetadata.TotalTokenCount)
+		}
+		expires := "-"
+		if t := cc.Expiration.ExpireTime; !t.IsZero() {
+			expires = t.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", cc.Name, strings.TrimPrefix(cc.Model, "models/"), cc.DisplayName, tokens, expires)
+	}
+	return tw.Flush()
+}
+
+func runCacheDeleteCmd(cmd *cobra.Command, args []string) error {
+	ctx, stop := newCommandContext()
+	defer stop()
+	ctx, cancel := withRequestTimeout(ctx, cmd)
+	defer cancel()
+	client, err := newClient(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var errs []error
+	for _, name := range args {
+		if err := client.DeleteCachedContent(ctx, cachedContentName(name)); err != nil {
+			errs = append(errs, fmt.Errorf("error deleting %s: %w", name, requestError(ctx, cmd, err)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func runCacheClearCmd(cmd *cobra.Command, args []string) error {
+	path, err := responseCachePath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Deleted 0 cached responses")
+		return nil
+	}
+	cache, err := openResponseCache(path)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+	n, err := cache.clear()
+	if err != nil {
+		return err
+	}
+	log.Printf("Deleted %d cached responses", n)
+	return nil
+}
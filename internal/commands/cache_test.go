@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestCachedContentName(t *testing.T) {
+	for _, name := range []string{"abc123", "cachedContents/abc123"} {
+		if got, want := cachedContentName(name), "cachedContents/abc123"; got != want {
+			t.Errorf("%q: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestPrintCachedContents(t *testing.T) {
+	expires := time.Date(2024, 7, 1, 10, 30, 0, 0, time.Local)
+	var sb strings.Builder
+	err := printCachedContents(&sb, []*genai.CachedContent{
+		{
+			Name:          "cachedContents/abc",
+			Model:         "models/gemini-1.5-flash-001",
+			DisplayName:   "manual",
+			UsageMetadata: &genai.CachedContentUsageMetadata{TotalTokenCount: 40000},
+			Expiration:    genai.ExpireTimeOrTTL{ExpireTime: expires},
+		},
+		{Name: "cachedContents/def", Model: "models/gemini-1.5-pro-001"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `Name                Model                 Display Name  Tokens  Expires
+cachedContents/abc  gemini-1.5-flash-001  manual        40000   2024-07-01 10:30:00
+cachedContents/def  gemini-1.5-pro-001                  -       -
+`
+	if got := sb.String(); got != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPromptCachedContent(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var gotBody string
+	fakeBackend(t, func(path string, body string) string {
+		gotBody = body
+		return `{"candidates": [{"content": {"role": "model", "parts": [{"text": "42"}]}, "finishReason": 1}]}`
+	})
+
+	executeCommand(t, "prompt", "--no-stream", "--model", "gemini-1.5-flash-001", "--cached-content", "abc", "what's the answer?")
+	if !strings.Contains(gotBody, `"cachedContent":"cachedContents/abc"`) {
+		t.Errorf("request %s doesn't refer to the cached content", gotBody)
+	}
+
+	if _, err := executeCommandErr("cache", "create", "--file", "doc.txt", "--ttl", "0s"); err == nil || !strings.Contains(err.Error(), "--ttl must be positive") {
+		t.Errorf("got error %v, want --ttl must be positive", err)
+	}
+}
@@ -4,9 +4,10 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"log"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/google/generative-ai-go/genai"
 	"github.com/spf13/cobra"
@@ -14,80 +15,183 @@ import (
 )
 
 var chatCmd = &cobra.Command{
-	Use:   "chat",
-	Short: "Interactive chat with a model",
-	Long:  `Start an interactive terminal chat with a Gemini model.`,
-	Run:   runChatCmd,
+	Use:     "chat",
+	Short:   "Interactive chat with a model",
+	Long:    strings.TrimSpace(chatUsage),
+	PreRunE: preRunModel(nil),
+	RunE:    runChatCmd,
 }
 
+var chatUsage = `
+Start an interactive terminal chat with a Gemini model.
+
+Each line of input is sent to the model as a message, and the reply is
+streamed back. The chat history is kept between messages, so the model has
+the context of the whole conversation. Special commands:
+
+* /exit: exit the chat ('exit' and 'quit' work too)
+* /reset: clear the chat history and start over
+* $load <file path>: send the contents of a file as the next message
+
+A system instruction for the model can be set with --system or --system-file;
+both can be repeated, and the parts are combined in the order given.
+
+With --tools, the model is told about the functions declared in the given JSON
+file, and may ask to call them instead of replying with text. The file holds an
+array of declarations, each with a name, a description and the parameters as a
+JSON schema, e.g.
+
+  [{"name": "get_weather", "description": "get the current weather in a city",
+    "parameters": {"type": "object", "properties": {"city": {"type": "string"}},
+                   "required": ["city"]}}]
+
+Each function call the model asks for is printed, and you're asked for its
+response: a line with a JSON object, or any other text, which is sent as the
+"result" of the call. The model then continues with the responses.
+`
+
 func init() {
 	rootCmd.AddCommand(chatCmd)
+
+	addModelFlags(chatCmd)
+	chatCmd.Flags().String("tools", "", "path to a JSON file declaring functions the model can call")
 }
 
-func runChatCmd(cmd *cobra.Command, args []string) {
+func runChatCmd(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
-	client, err := newGenaiClient(ctx, cmd)
+	client, err := newClient(ctx, cmd)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer client.Close()
 
 	modelName, _ := cmd.Flags().GetString("model")
 	model := client.GenerativeModel(modelName)
-	model.SafetySettings = []*genai.SafetySetting{
-		{
-			Category:  genai.HarmCategoryDangerousContent,
-			Threshold: genai.HarmBlockNone,
-		},
-		{
-			Category:  genai.HarmCategoryHarassment,
-			Threshold: genai.HarmBlockNone,
-		},
+	if err := configureModel(cmd, model); err != nil {
+		return err
+	}
+	if path := mustGetStringFlag(cmd, "tools"); path != "" {
+		if model.Tools, err = loadTools(path); err != nil {
+			return err
+		}
+	}
+
+	// With --quiet, everything besides the model's replies goes to stderr.
+	w := cmd.OutOrStdout()
+	status := w
+	if mustGetBoolFlag(cmd, "quiet") {
+		status = cmd.ErrOrStderr()
 	}
 
 	session := model.StartChat()
-	fmt.Printf("Chatting with %s\n", modelName)
-	fmt.Println("Type 'exit' or 'quit' to exit, or '$load <file path>' to load a file")
-	reader := bufio.NewReader(os.Stdin)
+	fmt.Fprintf(status, "Chatting with %s\n", modelName)
+	fmt.Fprintln(status, "Type '/exit' to exit, '/reset' to clear the chat history, or '$load <file path>' to load a file")
+	reader := bufio.NewReader(cmd.InOrStdin())
 
 	for {
-		fmt.Print("> ")
-		text, _ := reader.ReadString('\n')
+		fmt.Fprint(status, "> ")
+		text, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error reading input: %w", err)
+		}
+		atEOF := err == io.EOF
 		text = strings.TrimSpace(text)
 
-		if text == "exit" || text == "quit" {
+		if text == "/exit" || text == "exit" || text == "quit" {
 			break
 		}
+		if text == "/reset" {
+			session.History = nil
+			fmt.Fprintln(status, "Chat history cleared")
+			continue
+		}
+		if text == "" {
+			if atEOF {
+				break
+			}
+			continue
+		}
 
 		var inputPart genai.Part
 		// Detect a special chat command.
 		if path, found := strings.CutPrefix(text, "$load"); found {
 			part, err := getPartFromFile(strings.TrimSpace(path))
 			if err != nil {
-				log.Fatalf("error loading file %s: %v", path, err)
+				return fmt.Errorf("error loading file %s: %w", path, err)
 			}
 			inputPart = part
 		} else {
 			inputPart = genai.Text(text)
 		}
 
-		iter := session.SendMessageStream(ctx, inputPart)
+		// With tools, the model can reply with function calls instead of text; the
+		// responses to them are sent back, until the model replies with text.
+		parts := []genai.Part{inputPart}
+		for len(parts) > 0 {
+			// Each request gets its own context, so that Ctrl-C or a timeout only
+			// abandons the current reply rather than the whole chat.
+			msgCtx, stop := newCommandContext()
+			msgCtx, cancel := withRequestTimeout(msgCtx, cmd)
+			logRequest(model, modelName, len(parts))
+			start := time.Now()
+			calls, _, err := streamChatReply(msgCtx, session, parts, w, &partPrinter{})
+			logResponse(start)
+			fmt.Fprintln(status)
+			if err != nil && msgCtx.Err() != nil {
+				log.Println(requestError(msgCtx, cmd, err))
 
-	ResponseIter:
-		for {
-			resp, err := iter.Next()
-			if err == iterator.Done {
-				break ResponseIter
+				// The message didn't get a reply; drop it from the history so the
+				// conversation stays consistent.
+				if n := len(session.History); n > 0 && session.History[n-1].Role == "user" {
+					session.History = session.History[:n-1]
+				}
+				err, calls = nil, nil
+			}
+			cancel()
+			stop()
+			if err != nil {
+				return err
 			}
+
+			parts, err = readToolResponses(reader, status, calls)
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
-			if len(resp.Candidates) >= 0 {
-				c := resp.Candidates[0]
-				if c.Content != nil {
-					for _, part := range c.Content.Parts {
-						fmt.Print(part)
-					}
+		}
+
+		if atEOF {
+			break
+		}
+	}
+	return nil
+}
+
+// streamChatReply sends parts in session, and writes the text of the reply to
+// w with pp as it's streamed back. It returns the function calls in the reply,
+// if any, and the candidate of the last chunk received, which has the finish
+// reason of the reply; the candidate is returned even if the stream fails
+// after it.
+func streamChatReply(ctx context.Context, session *genai.ChatSession, parts []genai.Part, w io.Writer, pp *partPrinter) ([]genai.FunctionCall, *genai.Candidate, error) {
+	var calls []genai.FunctionCall
+	var last *genai.Candidate
+	iter := session.SendMessageStream(ctx, parts...)
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			return calls, last, nil
+		}
+		if err != nil {
+			return nil, last, err
+		}
+		if len(resp.Candidates) > 0 {
+			last = resp.Candidates[0]
+		}
+		if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil {
+			for _, part := range resp.Candidates[0].Content.Parts {
+				if call, ok := part.(genai.FunctionCall); ok {
+					calls = append(calls, call)
+				} else if err := pp.print(w, part); err != nil {
+					return nil, last, err
 				}
 			}
 		}
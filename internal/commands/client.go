@@ -2,8 +2,11 @@ package commands
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"os"
 
 	"github.com/eliben/gemini-cli/internal/apikey"
 	"github.com/google/generative-ai-go/genai"
@@ -11,13 +14,84 @@ import (
 	"google.golang.org/api/option"
 )
 
+// genaiClient is the part of *genai.Client that commands use to access the
+// API.
+type genaiClient interface {
+	GenerativeModel(name string) *genai.GenerativeModel
+	EmbeddingModel(name string) *genai.EmbeddingModel
+	ListModels(ctx context.Context) *genai.ModelInfoIterator
+	CreateCachedContent(ctx context.Context, cc *genai.CachedContent) (*genai.CachedContent, error)
+	ListCachedContents(ctx context.Context) *genai.CachedContentIterator
+	DeleteCachedContent(ctx context.Context, name string) error
+	Close() error
+}
+
+// newClient creates the client commands use to access the API; it's
+// newGenaiClient, except in tests, which replace it to talk to a fake
+// backend.
+var newClient = func(ctx context.Context, cmd *cobra.Command) (genaiClient, error) {
+	client, err := newGenaiClient(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
 // newGenaiClient creates a new genai.Client given the configuration of
-// cmd flags (for API key, proxy selection, etc.)
+// cmd flags (for API key, proxy selection, endpoint, etc.)
+//
+// The client authenticates with the service account credentials given with
+// --credentials-file if set, and with the API key otherwise. It talks to the
+// endpoint given with --endpoint or the GEMINI_CLI_ENDPOINT env var (e.g. a
+// gateway in front of the API), or to the public Gemini API if neither is
+// set. The endpoint "vertex" selects Vertex AI in --project and --location
+// (see newVertexClient).
 func newGenaiClient(ctx context.Context, cmd *cobra.Command) (*genai.Client, error) {
-	key := apikey.Get(cmd)
-
 	var clientOpts []option.ClientOption
-	if proxyURL, _ := cmd.Flags().GetString("proxy"); len(proxyURL) > 0 {
+
+	endpoint := mustGetStringFlag(cmd, "endpoint")
+	if endpoint == "" {
+		endpoint = os.Getenv("GEMINI_CLI_ENDPOINT")
+	}
+	project := mustGetStringFlag(cmd, "project")
+	location := mustGetStringFlag(cmd, "location")
+	proxyURL := mustGetStringFlag(cmd, "proxy")
+	if endpoint == vertexEndpoint {
+		if proxyURL != "" {
+			return nil, errors.New("--proxy can't be used with --endpoint vertex")
+		}
+		return newVertexClient(ctx, project, location, mustGetStringFlag(cmd, "credentials-file"))
+	}
+	if project != "" || location != "" {
+		return nil, errors.New("--project and --location are only used with --endpoint vertex")
+	}
+	if endpoint != "" {
+		clientOpts = append(clientOpts, option.WithEndpoint(endpoint))
+	}
+
+	if credsFile := mustGetStringFlag(cmd, "credentials-file"); credsFile != "" {
+		// The proxy support passes the API key with each request through its
+		// own HTTP client, which would bypass the credentials.
+		if proxyURL != "" {
+			return nil, errors.New("--proxy can't be used with --credentials-file")
+		}
+		// The credentials are passed as JSON rather than with
+		// option.WithCredentialsFile, which genai.NewClient doesn't recognize
+		// as an auth option.
+		b, err := os.ReadFile(credsFile)
+		if err != nil {
+			return nil, &authError{fmt.Errorf("error reading credentials file: %w", err)}
+		}
+		clientOpts = append(clientOpts, option.WithCredentialsJSON(b))
+		return genai.NewClient(ctx, clientOpts...)
+	}
+
+	key, err := apikey.Get(cmd)
+	if err != nil {
+		return nil, &authError{err}
+	}
+
+	if len(proxyURL) > 0 {
 		c := &http.Client{Transport: &proxyRoundTripper{
 			APIKey:   key,
 			ProxyURL: proxyURL,
@@ -28,8 +102,7 @@ func newGenaiClient(ctx context.Context, cmd *cobra.Command) (*genai.Client, err
 		clientOpts = append(clientOpts, option.WithAPIKey(key))
 	}
 
-	client, err := genai.NewClient(ctx, clientOpts...)
-	return client, err
+	return genai.NewClient(ctx, clientOpts...)
 }
 
 type proxyRoundTripper struct {
@@ -0,0 +1,197 @@
+package commands
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/spf13/cobra"
+	"google.golang.org/api/option"
+)
+
+// fakeBackend makes the commands run by the test talk to a fake API server
+// that responds to each request with the result of respond, given the
+// request's path (e.g. "/v1beta/models/gemini-1.5-flash:generateContent").
+func fakeBackend(t *testing.T, respond func(path string, body string) string) {
+	t.Helper()
+	fakeBackendHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, respond(r.URL.Path, string(body)))
+	}))
+}
+
+// fakeBackendHandler is like fakeBackend, with the fake API server's
+// requests served by handler.
+func fakeBackendHandler(t *testing.T, handler http.Handler) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	origNewClient := newClient
+	newClient = func(ctx context.Context, cmd *cobra.Command) (genaiClient, error) {
+		return genai.NewClient(ctx, option.WithEndpoint(srv.URL), option.WithAPIKey("fake-key"), option.WithHTTPClient(srv.Client()))
+	}
+	t.Cleanup(func() { newClient = origNewClient })
+}
+
+func TestPromptWithFakeBackend(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeBackend(t, func(path string, body string) string {
+		switch {
+		case strings.HasSuffix(path, ":generateContent") && strings.Contains(body, "why is the sky blue?"):
+			return `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Rayleigh scattering."}]}, "finishReason": 1}]}`
+		case strings.HasSuffix(path, ":countTokens"):
+			return `{"totalTokens": 7}`
+		}
+		t.Errorf("unexpected request %s: %s", path, body)
+		return `{}`
+	})
+
+	if got, want := executeCommand(t, "prompt", "--no-stream", "why is the sky blue?"), "Rayleigh scattering.\n"; got != want {
+		t.Errorf("prompt: got output %q, want %q", got, want)
+	}
+	if got, want := executeCommand(t, "prompt", "--no-stream", "--quiet", "why is the sky blue?"), "Rayleigh scattering."; got != want {
+		t.Errorf("prompt --quiet: got output %q, want %q", got, want)
+	}
+	if got, want := executeCommand(t, "counttok", "why is the sky blue?"), "7\n"; got != want {
+		t.Errorf("counttok: got output %q, want %q", got, want)
+	}
+}
+
+func TestVertexRoundTripper(t *testing.T) {
+	var gotPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "hi"}]}, "finishReason": 1}]}`)
+	}))
+	defer srv.Close()
+
+	rt := &vertexRoundTripper{project: "my-project", location: "europe-west4", base: srv.Client().Transport}
+	ctx := context.Background()
+	// The key is only for the cache client, which doesn't use the HTTP client.
+	client, err := genai.NewClient(ctx, option.WithEndpoint(srv.URL), option.WithAPIKey("fake-key"), option.WithHTTPClient(&http.Client{Transport: rt}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.GenerativeModel("gemini-1.5-flash").GenerateContent(ctx, genai.Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/v1beta1/projects/my-project/locations/europe-west4/publishers/google/models/gemini-1.5-flash:generateContent"; gotPath != want {
+		t.Errorf("got path %q, want %q", gotPath, want)
+	}
+
+	for _, path := range []string{"/v1beta/models", "/v1beta/files/abc", "/v1beta/tunedModels/x:generateContent"} {
+		if _, err := rt.vertexPath(path); err == nil {
+			t.Errorf("%s: got no error, want error", path)
+		}
+	}
+}
+
+func TestVertexFlags(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GEMINI_API_KEY", "fake-key")
+	if got, want := vertexHost("us-central1"), "us-central1-aiplatform.googleapis.com"; got != want {
+		t.Errorf("got host %q, want %q", got, want)
+	}
+	if got, want := vertexHost("global"), "aiplatform.googleapis.com"; got != want {
+		t.Errorf("got host %q, want %q", got, want)
+	}
+
+	for _, tt := range []struct {
+		args    []string
+		wantErr string
+	}{
+		{[]string{"--endpoint", "vertex"}, "needs both --project and --location"},
+		{[]string{"--endpoint", "vertex", "--project", "p"}, "needs both --project and --location"},
+		{[]string{"--endpoint", "vertex", "--project", "p", "--location", "us-central1.evil.com/x"}, "invalid --location"},
+		{[]string{"--endpoint", "vertex", "--project", "p", "--location", "global", "--proxy", "http://localhost:1"}, "--proxy can't be used"},
+		{[]string{"--project", "p", "--location", "us-central1"}, "only used with --endpoint vertex"},
+	} {
+		args := append([]string{"models"}, tt.args...)
+		if _, err := executeCommandErr(args...); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%v: got error %v, want %q", tt.args, err, tt.wantErr)
+		}
+	}
+}
+
+// countingClient is a genaiClient that counts how many times it's closed.
+type countingClient struct {
+	genaiClient
+	closed *atomic.Int32
+}
+
+func (c countingClient) Close() error {
+	c.closed.Add(1)
+	return c.genaiClient.Close()
+}
+
+func TestClientReuse(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeBackend(t, func(path string, body string) string {
+		if strings.HasSuffix(path, ":batchEmbedContents") {
+			n := strings.Count(body, `"content"`)
+			return `{"embeddings": [` + strings.Repeat(`{"values": [1, 2]}, `, n-1) + `{"values": [1, 2]}]}`
+		}
+		return `{"candidates": [{"content": {"role": "model", "parts": [{"text": "ok"}]}, "finishReason": 1}]}`
+	})
+
+	// Each command creates a single client for all its requests, and closes
+	// it however it exits.
+	var created, closed atomic.Int32
+	fakeNewClient := newClient
+	newClient = func(ctx context.Context, cmd *cobra.Command) (genaiClient, error) {
+		client, err := fakeNewClient(ctx, cmd)
+		if err != nil {
+			return nil, err
+		}
+		created.Add(1)
+		return countingClient{genaiClient: client, closed: &closed}, nil
+	}
+	t.Cleanup(func() { newClient = fakeNewClient })
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.csv")
+	if err := os.WriteFile(input, []byte("id,text\n1,a\n2,b\n3,c\n4,d\n5,e\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name    string
+		stdin   string
+		args    []string
+		wantErr bool
+	}{
+		{"batch", "one\ntwo\nthree\nfour\n", []string{"prompt", "--batch", "--concurrency", "2"}, false},
+		{"batch ndjson", "one\ntwo\nthree\n", []string{"prompt", "--batch", "--format", "ndjson"}, false},
+		{"batch config error", "one\n", []string{"prompt", "--batch", "--top-k", "0"}, true},
+		{"chat", "/exit\n", []string{"chat"}, false},
+		{"chat config error", "", []string{"chat", "--temp", "3"}, true},
+		{"embed db", "", []string{"embed", "db", filepath.Join(dir, "out.db"), input, "--batch-size", "2", "--concurrency", "2", "--no-cache"}, false},
+	}
+	for _, tt := range tests {
+		created.Store(0)
+		closed.Store(0)
+		rootCmd.SetIn(strings.NewReader(tt.stdin))
+		_, err := executeCommandErr(tt.args...)
+		rootCmd.SetIn(nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error: %v", tt.name, err, tt.wantErr)
+		}
+		if created.Load() != 1 || closed.Load() != 1 {
+			t.Errorf("%s: created %d clients and closed %d, want 1 and 1", tt.name, created.Load(), closed.Load())
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+)
+
+// newCommandContext returns a context for the requests made by a command. The
+// context is canceled when the user interrupts the program with Ctrl-C.
+func newCommandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+// withRequestTimeout returns a context derived from ctx that expires after
+// the duration given with the --timeout flag. A timeout of 0 means no timeout.
+func withRequestTimeout(ctx context.Context, cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	timeout := mustGetDurationFlag(cmd, "timeout")
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// requestError returns err with a clearer message if it was caused by ctx
+// timing out or being interrupted, by the prompt being longer than the model
+// accepts, or by the model blocking the prompt or its response; otherwise it
+// returns err unchanged.
+func requestError(ctx context.Context, cmd *cobra.Command, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return &annotatedError{
+			msg: fmt.Sprintf("request timed out after %v", mustGetDurationFlag(cmd, "timeout")),
+			err: context.DeadlineExceeded,
+		}
+	case errors.Is(ctx.Err(), context.Canceled):
+		return errors.New("request interrupted")
+	case isContextLengthError(err):
+		return &annotatedError{
+			msg: fmt.Sprintf("the prompt is longer than the model accepts (%v); check its size with 'gemini-cli counttok', and see the 'Max In' column of 'gemini-cli models' for models with a larger input token limit", err),
+			err: err,
+		}
+	default:
+		return blockedError(err)
+	}
+}
@@ -1,6 +1,14 @@
 package commands
 
-import "github.com/spf13/cobra"
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
 
 // mustGetStringFlag gets a string flag value from cmd, and panics if this
 // results in an error (for example, if such a flag wasn't defined for the
@@ -23,6 +31,36 @@ func mustGetIntFlag(cmd *cobra.Command, name string) int {
 	return v
 }
 
+// mustGetInt32Flag gets an int32 flag value from cmd, and panics if this
+// results in an error.
+func mustGetInt32Flag(cmd *cobra.Command, name string) int32 {
+	v, err := cmd.Flags().GetInt32(name)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// mustGetStringArrayFlag gets a string array flag value from cmd, and panics
+// if this results in an error.
+func mustGetStringArrayFlag(cmd *cobra.Command, name string) []string {
+	v, err := cmd.Flags().GetStringArray(name)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// mustGetInt64Flag gets an int64 flag value from cmd, and panics if this
+// results in an error.
+func mustGetInt64Flag(cmd *cobra.Command, name string) int64 {
+	v, err := cmd.Flags().GetInt64(name)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
 // mustGetBoolFlag gets an bool flag value from cmd, and panics if this
 // results in an error.
 func mustGetBoolFlag(cmd *cobra.Command, name string) bool {
@@ -42,3 +80,68 @@ func mustGetStringSliceFlag(cmd *cobra.Command, name string) []string {
 	}
 	return v
 }
+
+// mustGetDurationFlag gets a duration flag value from cmd, and panics if this
+// results in an error.
+func mustGetDurationFlag(cmd *cobra.Command, name string) time.Duration {
+	v, err := cmd.Flags().GetDuration(name)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// mustGetFloat32Flag gets a float32 flag value from cmd, and panics if this
+// results in an error.
+func mustGetFloat32Flag(cmd *cobra.Command, name string) float32 {
+	v, err := cmd.Flags().GetFloat32(name)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// mustGetFloat64Flag gets a float64 flag value from cmd, and panics if this
+// results in an error.
+func mustGetFloat64Flag(cmd *cobra.Command, name string) float64 {
+	v, err := cmd.Flags().GetFloat64(name)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// setFlagAliases makes cmd accept alternative names for some of its flags;
+// aliases maps each alternative name to the name of the flag it stands for.
+// Aliases don't appear in the help text. It can be called several times for
+// the same command; the aliases accumulate.
+func setFlagAliases(cmd *cobra.Command, aliases map[string]string) {
+	prev := cmd.Flags().GetNormalizeFunc()
+	cmd.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if alias, ok := aliases[name]; ok {
+			name = alias
+		}
+		return prev(f, name)
+	})
+}
+
+// checkEnumFlags returns a function for the PreRunE of a command, which
+// checks that each of the string flags in enums (a map from flag name to the
+// values it allows) has one of its allowed values. This catches a bad value
+// before the command reads its input or calls the API; the error lists the
+// allowed values, and is a usage error.
+func checkEnumFlags(enums map[string][]string) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		names := make([]string, 0, len(enums))
+		for name := range enums {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			if v := mustGetStringFlag(cmd, name); !slices.Contains(enums[name], v) {
+				return &usageError{fmt.Errorf("invalid --%s value %q; expect one of: %s", name, v, strings.Join(enums[name], ", "))}
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/api/iterator"
+)
+
+var completionCmd = &cobra.Command{
+	Use:       "completion <bash|zsh|fish|powershell>",
+	Short:     "Generate a shell completion script",
+	Long:      strings.TrimSpace(completionUsage),
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+	RunE:      runCompletionCmd,
+}
+
+var completionUsage = `
+Generate a completion script for the given shell, and print it to stdout.
+
+Besides commands and flags, the script completes the names of models for
+--model (this asks the API for the list of models, so it needs an API key) and
+the keys of templates for the template command.
+
+For example, to load completions in the current bash session:
+
+  source <(gemini-cli completion bash)
+
+See the documentation of your shell for how to load the completions in every
+session.
+`
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+}
+
+func runCompletionCmd(cmd *cobra.Command, args []string) error {
+	w := cmd.OutOrStdout()
+	root := cmd.Root()
+	switch args[0] {
+	case "bash":
+		return root.GenBashCompletionV2(w, true)
+	case "zsh":
+		return root.GenZshCompletion(w)
+	case "fish":
+		return root.GenFishCompletion(w, true)
+	case "powershell":
+		return root.GenPowerShellCompletionWithDesc(w)
+	default:
+		return fmt.Errorf("unsupported shell %q", args[0])
+	}
+}
+
+// completeModelNames completes the value of --model with the names of the
+// models listed by the API, without their "models/" prefix. Nothing is
+// completed if the models can't be listed (e.g. without an API key).
+func completeModelNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	ctx, stop := newCommandContext()
+	defer stop()
+	ctx, cancel := withRequestTimeout(ctx, cmd)
+	defer cancel()
+	client, err := newClient(ctx, cmd)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	defer client.Close()
+
+	var names []string
+	iter := client.ListModels(ctx)
+	for {
+		mi, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		name := strings.TrimPrefix(mi.Name, "models/")
+		if strings.HasPrefix(name, toComplete) {
+			names = append(names, name+"\t"+mi.DisplayName)
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeTemplateKeys completes a template key with the keys in the
+// templates file, described by their templates.
+func completeTemplateKeys(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	filePath, err := templatesFilePath(cmd)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	if err := loadTemplates(filePath); err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var keys []string
+	for key, value := range templates {
+		if strings.HasPrefix(key, toComplete) {
+			keys = append(keys, key+"\t"+value)
+		}
+	}
+	slices.Sort(keys)
+	return keys, cobra.ShellCompDirectiveNoFileComp
+}
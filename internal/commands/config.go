@@ -0,0 +1,199 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// config holds the settings read from the config file, as a map from section
+// name to the key/value settings in that section. Settings that appear before
+// any section header are in the "" section.
+type config map[string]map[string]string
+
+// configFlags maps the settings supported in each config file section to the
+// names of the flags they provide defaults for. Settings in the "" section
+// apply to commands that use generative models; settings in the "embed"
+// section apply to the embed commands.
+var configFlags = map[string]map[string]string{
+	"": {
+		"model":       "model",
+		"temperature": "temperature",
+		"safety":      "safety",
+		"stream":      "stream",
+	},
+	"embed": {
+		"model": "model",
+	},
+}
+
+// aliasesSection is the config file section that maps model aliases to the
+// names of the models they stand for, e.g. "pro = gemini-1.5-pro-latest". The
+// --model flag of all commands is resolved through it.
+const aliasesSection = "aliases"
+
+// configFilePath returns the path of the config file.
+func configFilePath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".config", "gemini-cli", "config.toml"), nil
+}
+
+// parseConfig parses a config file from r. The format is a small subset of
+// TOML: "key = value" lines, optionally grouped by "[section]" headers, with
+// '#' starting a comment. Values may be quoted with double quotes.
+func parseConfig(r io.Reader) (config, error) {
+	cfg := config{"": {}}
+	section := ""
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: malformed section header %q", lineNum, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if cfg[section] == nil {
+				cfg[section] = make(map[string]string)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expect 'key = value', got %q", lineNum, line)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			v, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: malformed quoted value %s", lineNum, value)
+			}
+			value = v
+		} else if v, _, found := strings.Cut(value, "#"); found {
+			// Trailing comment after an unquoted value.
+			value = strings.TrimSpace(v)
+		}
+		cfg[section][key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadConfig reads and parses the config file. A missing config file is not
+// an error; it results in an empty config.
+func loadConfig() (config, error) {
+	path, err := configFilePath()
+	if err != nil {
+		return config{}, nil
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, err := parseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	for section, settings := range cfg {
+		if section == aliasesSection {
+			continue
+		}
+		for key := range settings {
+			if _, ok := configFlags[section][key]; !ok {
+				return nil, fmt.Errorf("config file %s: unknown setting '%s' in section [%s]", path, key, section)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// configAnnotation is the annotation of the flags whose values were set from
+// the config file by applyConfig.
+const configAnnotation = "gemini-cli-config"
+
+// flagIsSet reports whether the flag name of cmd was set, either on the
+// command line or from the config file. Values from the config file are
+// defaults, so they don't count for cmd.Flags().Changed; e.g. "stream = true"
+// doesn't conflict with --no-stream, and an [embed] model isn't an explicit
+// --model.
+func flagIsSet(cmd *cobra.Command, name string) bool {
+	f := cmd.Flags().Lookup(name)
+	if f == nil {
+		return false
+	}
+	_, fromConfig := f.Annotations[configAnnotation]
+	return f.Changed || fromConfig
+}
+
+// setFlagFromConfig sets the value of f from the config file, without marking
+// it as changed.
+func setFlagFromConfig(cmd *cobra.Command, f *pflag.Flag, value string) error {
+	if err := f.Value.Set(value); err != nil {
+		return err
+	}
+	return cmd.Flags().SetAnnotation(f.Name, configAnnotation, []string{value})
+}
+
+// applyConfig sets the flags of cmd from the config file settings, unless
+// they were explicitly set on the command line. The settings are applied as
+// defaults, which aren't marked as changed (see flagIsSet).
+func applyConfig(cmd *cobra.Command) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	section := ""
+	for c := cmd; c != nil; c = c.Parent() {
+		if c == embedCmd {
+			section = "embed"
+		}
+	}
+
+	for key, value := range cfg[section] {
+		flagName := configFlags[section][key]
+		f := cmd.Flags().Lookup(flagName)
+		if f == nil || f.Changed {
+			continue
+		}
+		if err := setFlagFromConfig(cmd, f, value); err != nil {
+			return fmt.Errorf("config file: invalid value for '%s': %w", key, err)
+		}
+	}
+
+	if f := cmd.Flags().Lookup("model"); f != nil {
+		alias := f.Value.String()
+		if name, ok := cfg[aliasesSection][alias]; ok {
+			if err := setFlagFromConfig(cmd, f, name); err != nil {
+				return err
+			}
+			verboseLog.Debug("resolved model alias", "alias", alias, "model", name)
+		}
+	}
+	return nil
+}
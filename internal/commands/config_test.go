@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseConfig(t *testing.T) {
+	input := `
+# comment
+model = "gemini-1.5-pro"
+temperature = 0.5   # trailing comment
+stream=false
+
+[embed]
+model = text-embedding-004
+`
+	cfg, err := parseConfig(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := config{
+		"": {
+			"model":       "gemini-1.5-pro",
+			"temperature": "0.5",
+			"stream":      "false",
+		},
+		"embed": {
+			"model": "text-embedding-004",
+		},
+	}
+	if diff := cmp.Diff(want, cfg); diff != "" {
+		t.Errorf("config mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	var tests = []struct {
+		input   string
+		wantErr string
+	}{
+		{"model", "line 1: expect 'key = value'"},
+		{"\n[embed\nmodel = x", "line 2: malformed section header"},
+		{`model = "foo`, "line 1: malformed quoted value"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseConfig(strings.NewReader(tt.input))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("got error %v, want error containing %q", err, tt.wantErr)
+		}
+	}
+}
+
+func TestModelAliases(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	configDir := filepath.Join(home, ".config", "gemini-cli")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	configText := `
+model = flash
+
+[aliases]
+pro = gemini-1.5-pro-latest
+flash = "gemini-1.5-flash-002"
+`
+	if err := os.WriteFile(filepath.Join(configDir, "config.toml"), []byte(configText), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotPath string
+	fakeBackend(t, func(path string, body string) string {
+		gotPath = path
+		return `{"candidates": [{"content": {"role": "model", "parts": [{"text": "hi"}]}, "finishReason": 1}]}`
+	})
+
+	var tests = []struct {
+		args     []string
+		wantPath string
+	}{
+		{[]string{"--model", "pro"}, "/v1beta/models/gemini-1.5-pro-latest:generateContent"},
+		{nil, "/v1beta/models/gemini-1.5-flash-002:generateContent"},
+		{[]string{"--model", "gemini-1.0-pro"}, "/v1beta/models/gemini-1.0-pro:generateContent"},
+	}
+	for _, tt := range tests {
+		executeCommand(t, append([]string{"prompt", "--no-stream", "hello"}, tt.args...)...)
+		if gotPath != tt.wantPath {
+			t.Errorf("%v: got request to %s, want %s", tt.args, gotPath, tt.wantPath)
+		}
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	configDir := filepath.Join(home, ".config", "gemini-cli")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "config.toml"), []byte("stream = true\ntemperature = 0.5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotPath, gotBody string
+	fakeBackend(t, func(path string, body string) string {
+		gotPath, gotBody = path, body
+		return `{"candidates": [{"content": {"role": "model", "parts": [{"text": "hi"}]}, "finishReason": 1}]}`
+	})
+
+	// The config file's stream setting is a default, which --no-stream
+	// overrides instead of conflicting with it.
+	if got := executeCommand(t, "prompt", "--no-stream", "hello"); got != "hi\n" {
+		t.Errorf("got output %q, want %q", got, "hi\n")
+	}
+	if !strings.HasSuffix(gotPath, ":generateContent") {
+		t.Errorf("got request to %s, want generateContent", gotPath)
+	}
+	if !strings.Contains(gotBody, `"temperature":0.5`) {
+		t.Errorf("got request %s, want the temperature of the config file", gotBody)
+	}
+}
@@ -1,57 +1,66 @@
 package commands
 
 import (
-	"context"
+	"encoding/json"
 	"fmt"
-	"io"
-	"log"
 	"strings"
 
-	"github.com/google/generative-ai-go/genai"
 	"github.com/spf13/cobra"
 )
 
 var countTokCmd = &cobra.Command{
-	Use:     "counttok <content or '-'>",
-	Aliases: []string{"tokcount"},
+	Use:     "counttok <content or '-'>...",
+	Aliases: []string{"tokcount", "tokens"},
 	Short:   "Count tokens in content",
-	Args:    cobra.ExactArgs(1),
+	Args:    cobra.MinimumNArgs(1),
 	Long:    strings.TrimSpace(countTokUsage),
-	Run:     runCountTokCmd,
+	RunE:    runCountTokCmd,
 }
 
 var countTokUsage = `
 Count the number of LLM tokens in the given content.
 
-The content is passed as a string on the command-line (quote it if spaces are
-included), or read from standard input if '-' is provided.
+The content is built from the arguments the same way the prompt command does
+it: each argument is some text (quote it if spaces are included), a name of an
+image or PDF file on the local filesystem, a URL pointing to such a file online,
+or '-' to read content from standard input. Downloads of URLs are limited by
+--max-download-bytes and --download-timeout, as for prompt.
+
+With --json, the count is emitted as a JSON object.
 `
 
 func init() {
 	rootCmd.AddCommand(countTokCmd)
-}
 
-func runCountTokCmd(cmd *cobra.Command, args []string) {
-	content := args[0]
+	countTokCmd.Flags().Bool("json", false, "emit the token count as JSON")
+	addDownloadFlags(countTokCmd)
+}
 
-	if content == "-" {
-		b, err := io.ReadAll(cmd.InOrStdin())
-		if err != nil {
-			log.Fatal("error reading content from stdin:", err)
-		}
-		content = string(b)
+func runCountTokCmd(cmd *cobra.Command, args []string) error {
+	promptParts, err := buildPromptParts(cmd, args)
+	if err != nil {
+		return err
 	}
 
-	ctx := context.Background()
-	client, err := newGenaiClient(ctx, cmd)
+	ctx, stop := newCommandContext()
+	defer stop()
+	ctx, cancel := withRequestTimeout(ctx, cmd)
+	defer cancel()
+	client, err := newClient(ctx, cmd)
 	if err != nil {
-		log.Fatal()
+		return err
 	}
+	defer client.Close()
 
 	model := client.GenerativeModel(mustGetStringFlag(cmd, "model"))
-	resp, err := model.CountTokens(ctx, genai.Text(content))
+	resp, err := model.CountTokens(ctx, promptParts...)
 	if err != nil {
-		log.Fatal("error counting tokens:", err)
+		return fmt.Errorf("error counting tokens: %w", requestError(ctx, cmd, err))
+	}
+
+	if mustGetBoolFlag(cmd, "json") {
+		return json.NewEncoder(cmd.OutOrStdout()).Encode(map[string]int32{"totalTokens": resp.TotalTokens})
 	}
-	fmt.Println(resp.TotalTokens)
+	fmt.Fprintln(cmd.OutOrStdout(), resp.TotalTokens)
+	return nil
 }
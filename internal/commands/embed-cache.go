@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// embeddingCacheTable is the name of the table in which embed db caches the
+// embeddings it calculated, by the hash of their content. Texts that have
+// been embedded before (under any id, and in any table of the DB) aren't
+// sent to the model again.
+const embeddingCacheTable = "gemini_cli_embedding_cache"
+
+// embeddingCacheKey returns the key of the embedding of text, with its title
+// (which may be empty), calculated by modelName for taskType. These are all
+// that determine the embedding.
+func embeddingCacheKey(modelName string, taskType genai.TaskType, title string, text string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%s\x00", modelName, taskType, title)
+	h.Write([]byte(text))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// embeddingCache is a cache of embeddings, stored in embeddingCacheTable of
+// a DB. The embeddings are stored in full, before any reduction of their
+// dimensions.
+type embeddingCache struct {
+	db *sql.DB
+}
+
+// openEmbeddingCache returns the embedding cache in db, creating its table if
+// it doesn't exist yet.
+func openEmbeddingCache(db *sql.DB) (*embeddingCache, error) {
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (key TEXT PRIMARY KEY, embedding BLOB)`, embeddingCacheTable))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create table '%v' in DB: %w", embeddingCacheTable, err)
+	}
+	return &embeddingCache{db: db}, nil
+}
+
+// get returns the cached embedding for key, or nil if there's none.
+func (c *embeddingCache) get(key string) ([]float32, error) {
+	var b []byte
+	err := c.db.QueryRow(fmt.Sprintf(`SELECT embedding FROM %s WHERE key = ?`, embeddingCacheTable), key).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading embedding cache: %w", err)
+	}
+	return decodeEmbedding(b), nil
+}
+
+// put caches emb as the embedding for key.
+func (c *embeddingCache) put(key string, emb []float32) error {
+	_, err := c.db.Exec(fmt.Sprintf(`INSERT OR REPLACE INTO %s VALUES (?, ?)`, embeddingCacheTable), key, encodeEmbedding(emb))
+	if err != nil {
+		return fmt.Errorf("unable to write embedding cache: %w", err)
+	}
+	return nil
+}
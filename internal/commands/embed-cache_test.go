@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestEmbeddingCacheKey(t *testing.T) {
+	key := embeddingCacheKey("text-embedding-004", genai.TaskTypeRetrievalDocument, "", "some text")
+	for _, other := range []string{
+		embeddingCacheKey("embedding-001", genai.TaskTypeRetrievalDocument, "", "some text"),
+		embeddingCacheKey("text-embedding-004", genai.TaskTypeClustering, "", "some text"),
+		embeddingCacheKey("text-embedding-004", genai.TaskTypeRetrievalDocument, "title", "some text"),
+		embeddingCacheKey("text-embedding-004", genai.TaskTypeRetrievalDocument, "", "other text"),
+		// The parts of the key are delimited, so moving text between them
+		// changes it.
+		embeddingCacheKey("text-embedding-004", genai.TaskTypeRetrievalDocument, "some", " text"),
+	} {
+		if other == key {
+			t.Errorf("got the same key %s for different embeddings", key)
+		}
+	}
+	if got := embeddingCacheKey("text-embedding-004", genai.TaskTypeRetrievalDocument, "", "some text"); got != key {
+		t.Errorf("got key %s, want %s for the same embedding", got, key)
+	}
+}
+
+func TestEmbedDBCache(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	numEmbedded := 0
+	fakeBackend(t, func(path string, body string) string {
+		if !strings.HasSuffix(path, ":batchEmbedContents") {
+			t.Errorf("unexpected request %s: %s", path, body)
+			return `{}`
+		}
+		var req struct {
+			Requests []json.RawMessage `json:"requests"`
+		}
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatal(err)
+		}
+		numEmbedded += len(req.Requests)
+		embs := make([]string, len(req.Requests))
+		for i := range embs {
+			embs[i] = `{"values": [1, 2, 3]}`
+		}
+		return `{"embeddings": [` + strings.Join(embs, ", ") + `]}`
+	})
+
+	dir := t.TempDir()
+	var files []string
+	for name, content := range map[string]string{"a.txt": "same", "b.txt": "same", "c.txt": "different"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+	dbPath := filepath.Join(dir, "out.db")
+	filesList := strings.Join(files, ",")
+
+	var tests = []struct {
+		name         string
+		args         []string
+		wantEmbedded int
+	}{
+		// Duplicated content is only embedded once.
+		{"first run", []string{"--table", "t1"}, 2},
+		// Everything is cached now, even for another table.
+		{"cached", []string{"--table", "t2"}, 0},
+		{"no cache", []string{"--table", "t3", "--no-cache"}, 3},
+		// A different task type gets different embeddings.
+		{"task type", []string{"--table", "t4", "--task-type", "CLUSTERING"}, 2},
+	}
+	for _, tt := range tests {
+		numEmbedded = 0
+		executeCommand(t, append([]string{"embed", "db", dbPath, "--files-list", filesList}, tt.args...)...)
+		if numEmbedded != tt.wantEmbedded {
+			t.Errorf("%s: embedded %d values, want %d", tt.name, numEmbedded, tt.wantEmbedded)
+		}
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, table := range []string{"t1", "t2", "t3", "t4"} {
+		var n int
+		if err := db.QueryRow("SELECT count(*) FROM " + table + " WHERE embedding IS NOT NULL").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 3 {
+			t.Errorf("table %s has %d embeddings, want 3", table, n)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/spf13/cobra"
+)
+
+var embedCompareCmd = &cobra.Command{
+	Use:   "compare <text a> <text b>",
+	Short: "Compare the embeddings of two texts",
+	Long:  strings.TrimSpace(embedCompareUsage),
+	Args:  cobra.ExactArgs(2),
+	PreRunE: preRunEmbed(map[string][]string{
+		"metric": similarityMetricNames,
+	}),
+	RunE: runEmbedCompareCmd,
+}
+
+var embedCompareUsage = `
+Embed two texts and print how similar their embeddings are, without storing
+them in a DB. Either text (but not both) may be '-', to read it from standard
+input.
+
+--metric selects the score, as for 'embed similar': "cosine" (the default) is
+the cosine similarity of the embeddings, "dot" their dot product, and
+"euclidean" the Euclidean distance between them. Both texts are embedded with
+--task-type, which defaults to SEMANTIC_SIMILARITY; e.g. RETRIEVAL_QUERY shows
+how a query scores against the documents of 'embed similar'.
+`
+
+func init() {
+	embedCmd.AddCommand(embedCompareCmd)
+	embedCompareCmd.Flags().String("metric", "cosine", `how to compare the embeddings: "cosine" (cosine similarity), "dot" (dot product) or "euclidean" (distance)`)
+}
+
+func runEmbedCompareCmd(cmd *cobra.Command, args []string) error {
+	if args[0] == "-" && args[1] == "-" {
+		return errors.New("only one of the texts can be read from standard input")
+	}
+	texts := make([]string, len(args))
+	for i, arg := range args {
+		texts[i] = arg
+		if arg == "-" {
+			b, err := io.ReadAll(cmd.InOrStdin())
+			if err != nil {
+				return fmt.Errorf("error reading content from stdin: %w", err)
+			}
+			texts[i] = string(b)
+		}
+	}
+
+	taskType, err := embeddingTaskType(cmd, genai.TaskTypeSemanticSimilarity)
+	if err != nil {
+		return err
+	}
+	metric := similarityMetrics[mustGetStringFlag(cmd, "metric")]
+
+	ctx, stop := newCommandContext()
+	defer stop()
+	ctx, cancel := withRequestTimeout(ctx, cmd)
+	defer cancel()
+	client, err := newClient(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	modelName := mustGetStringFlag(cmd, "model")
+	dims, err := embeddingDimensions(cmd, modelName)
+	if err != nil {
+		return err
+	}
+
+	model := client.EmbeddingModel(modelName)
+	model.TaskType = taskType
+	batch := model.NewBatch()
+	for _, text := range texts {
+		batch.AddContent(genai.Text(text))
+	}
+	res, err := model.BatchEmbedContents(ctx, batch)
+	if err != nil {
+		return fmt.Errorf("error embedding content: %w", requestError(ctx, cmd, err))
+	}
+	if len(res.Embeddings) != len(texts) || res.Embeddings[0] == nil || res.Embeddings[1] == nil {
+		return errors.New("got no embeddings back from model")
+	}
+
+	a := truncateEmbedding(res.Embeddings[0].Values, dims)
+	b := truncateEmbedding(res.Embeddings[1].Values, dims)
+	if len(a) != len(b) {
+		return fmt.Errorf("got embeddings of different sizes (%d and %d dimensions)", len(a), len(b))
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "%v\n", metric.score(a, b))
+	return nil
+}
@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEmbedCompare(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var gotTaskTypes []genai.TaskType
+	fakeBackend(t, func(path string, body string) string {
+		if !strings.HasSuffix(path, ":batchEmbedContents") {
+			t.Errorf("unexpected request %s: %s", path, body)
+			return `{}`
+		}
+		var req struct {
+			Requests []struct {
+				TaskType genai.TaskType `json:"taskType"`
+			} `json:"requests"`
+		}
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Error(err)
+		}
+		for _, r := range req.Requests {
+			gotTaskTypes = append(gotTaskTypes, r.TaskType)
+		}
+		return `{"embeddings": [{"values": [3, 4, 0]}, {"values": [0, 4, 3]}]}`
+	})
+
+	var tests = []struct {
+		args []string
+		want string
+	}{
+		{nil, "0.64\n"},
+		{[]string{"--metric", "dot"}, "16\n"},
+		{[]string{"--metric", "euclidean", "--dimensions", "1"}, "3\n"},
+	}
+	for _, tt := range tests {
+		args := append([]string{"embed", "compare", "a cat", "a kitten"}, tt.args...)
+		if got := executeCommand(t, args...); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	gotTaskTypes = nil
+	rootCmd.SetIn(strings.NewReader("a kitten"))
+	defer rootCmd.SetIn(nil)
+	executeCommand(t, "embed", "compare", "a cat", "-", "--task-type", "RETRIEVAL_QUERY")
+	if diff := cmp.Diff([]genai.TaskType{genai.TaskTypeRetrievalQuery, genai.TaskTypeRetrievalQuery}, gotTaskTypes); diff != "" {
+		t.Errorf("task types mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, args := range [][]string{
+		{"-", "-"},
+		{"a", "b", "--metric", "manhattan"},
+	} {
+		if _, err := executeCommandErr(append([]string{"embed", "compare"}, args...)...); err == nil {
+			t.Errorf("%v: got no error, want error", args)
+		}
+	}
+}
@@ -1,13 +1,11 @@
 package commands
 
 import (
-	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"os"
 	"strings"
 
 	"github.com/google/generative-ai-go/genai"
@@ -19,71 +17,93 @@ var embedContentCmd = &cobra.Command{
 	Short: "Embed a single input using an embedding model",
 	Long:  strings.TrimSpace(embedContentUsage),
 	Args:  cobra.ExactArgs(1),
-	Run:   runEmbedContentCmd,
+	PreRunE: preRunEmbed(map[string][]string{
+		"format": embeddingFormats,
+	}),
+	RunE: runEmbedContentCmd,
 }
 
 var embedContentUsage = `
 Use a Gemini embedding model to embed a single string of content, emitting the
 result to stdout. The --format flag controls the format of the emitted
-embedding.
+embedding: json and base64 are followed by a single newline, while blob is
+the raw little-endian float32 values, with nothing after them.
 
 The content is passed as a string on the command-line (quote it if spaces are
 included), or read from standard input if '-' is provided.
 `
 
+// embeddingFormats lists the formats that can be passed to --format.
+var embeddingFormats = []string{"json", "base64", "blob"}
+
 func init() {
 	embedCmd.AddCommand(embedContentCmd)
 	embedContentCmd.Flags().String("format", "json", "format for embedding output: json, base64, blob")
 }
 
-func runEmbedContentCmd(cmd *cobra.Command, args []string) {
+func runEmbedContentCmd(cmd *cobra.Command, args []string) error {
 	content := args[0]
 
 	if content == "-" {
 		b, err := io.ReadAll(cmd.InOrStdin())
 		if err != nil {
-			log.Fatal("error reading content from stdin:", err)
+			return fmt.Errorf("error reading content from stdin: %w", err)
 		}
 		content = string(b)
 	}
 
-	ctx := context.Background()
-	client, err := newGenaiClient(ctx, cmd)
+	taskType, err := embeddingTaskType(cmd, genai.TaskTypeUnspecified)
 	if err != nil {
-		log.Fatal()
+		return err
 	}
 
-	model := client.EmbeddingModel(mustGetStringFlag(cmd, "model"))
+	ctx, stop := newCommandContext()
+	defer stop()
+	ctx, cancel := withRequestTimeout(ctx, cmd)
+	defer cancel()
+	client, err := newClient(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	modelName := mustGetStringFlag(cmd, "model")
+	dims, err := embeddingDimensions(cmd, modelName)
+	if err != nil {
+		return err
+	}
+
+	model := client.EmbeddingModel(modelName)
+	model.TaskType = taskType
 	res, err := model.EmbedContent(ctx, genai.Text(content))
 	if err != nil {
-		log.Fatal("error embedding content:", err)
+		return fmt.Errorf("error embedding content: %w", requestError(ctx, cmd, err))
 	}
 
 	if emb := res.Embedding; emb != nil {
-		emitEmbedding(os.Stdout, emb.Values, mustGetStringFlag(cmd, "format"))
-	} else {
-		log.Fatal("got no embedding back from model")
+		return emitEmbedding(cmd.OutOrStdout(), truncateEmbedding(emb.Values, dims), mustGetStringFlag(cmd, "format"))
 	}
+	return errors.New("got no embedding back from model")
 }
 
-func emitEmbedding(w io.Writer, v []float32, format string) {
+// emitEmbedding writes v to w in the given format. The text formats (json and
+// base64) always end with exactly one newline; blob is the raw encoded
+// embedding, without anything after it.
+func emitEmbedding(w io.Writer, v []float32, format string) error {
 	switch format {
 	case "json":
+		// Encode terminates the value with a newline.
 		encoder := json.NewEncoder(w)
-		err := encoder.Encode(v)
-		if err != nil {
-			log.Fatal(err)
-		}
+		return encoder.Encode(v)
 	case "base64":
 		b := encodeEmbedding(v)
-		encoder := base64.NewEncoder(base64.StdEncoding, w)
-		encoder.Write(b)
-		encoder.Close()
-		fmt.Println()
+		_, err := fmt.Fprintln(w, base64.StdEncoding.EncodeToString(b))
+		return err
 	case "blob":
 		b := encodeEmbedding(v)
-		w.Write(b)
+		_, err := w.Write(b)
+		return err
 	default:
-		log.Fatalf("invalid format: %s", format)
+		return fmt.Errorf("invalid format: %s", format)
 	}
 }
@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEmitEmbedding(t *testing.T) {
+	emb := []float32{1, -2.5}
+	var tests = []struct {
+		format string
+		want   string
+	}{
+		{"json", "[1,-2.5]\n"},
+		{"base64", "AACAPwAAIMA=\n"},
+		{"blob", "\x00\x00\x80\x3f\x00\x00\x20\xc0"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := emitEmbedding(&buf, emb, tt.format); err != nil {
+			t.Fatalf("%s: got error %v", tt.format, err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.format, got, tt.want)
+		}
+	}
+
+	if err := emitEmbedding(&bytes.Buffer{}, emb, "csv"); err == nil {
+		t.Errorf("got no error for an invalid format, want error")
+	}
+}
+
+func TestEmbedContentOutput(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeBackend(t, func(path string, body string) string {
+		if !strings.HasSuffix(path, ":embedContent") {
+			t.Errorf("unexpected request %s: %s", path, body)
+			return `{}`
+		}
+		return `{"embedding": {"values": [1, -2.5]}}`
+	})
+
+	// The whole output of the command is the embedding; in particular, base64
+	// output isn't followed by an empty line.
+	for format, want := range map[string]string{
+		"json":   "[1,-2.5]\n",
+		"base64": "AACAPwAAIMA=\n",
+		"blob":   "\x00\x00\x80\x3f\x00\x00\x20\xc0",
+	} {
+		if got := executeCommand(t, "embed", "content", "--format", format, "hello"); got != want {
+			t.Errorf("%s: got %q, want %q", format, got, want)
+		}
+	}
+}
@@ -1,29 +1,50 @@
 package commands
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"database/sql"
 	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/eliben/gemini-cli/internal/tableloader"
 	"github.com/google/generative-ai-go/genai"
 	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
 )
 
 var embedDBCmd = &cobra.Command{
 	Use:   "db <output DB path> [input file or '-']",
 	Short: "Embed a multiple inputs, storing results into a SQLite DB",
 	Long:  strings.TrimSpace(embedDBUsage),
-	Args:  cobra.RangeArgs(1, 2),
-	Run:   runEmbedDBCmd,
+	Args: func(cmd *cobra.Command, args []string) error {
+		// With --jsonl, there's no output DB; the only argument is the input.
+		if mustGetBoolFlag(cmd, "jsonl") {
+			return cobra.MaximumNArgs(1)(cmd, args)
+		}
+		return cobra.RangeArgs(1, 2)(cmd, args)
+	},
+	PreRunE: preRunEmbed(map[string][]string{
+		"input-format": inputFormats,
+		"id-conflict":  {"error", "replace", "skip"},
+	}),
+	RunE: runEmbedDBCmd,
 }
 
 var embedDBUsage = `
@@ -34,56 +55,210 @@ operation based on flags.
 * With --sql, provide a SQL query to use on the DB itself. The query should
   specify at least 2 columns; the first is used as the ID for the resulting
   embedding; the rest are concatenated into a single text and the embedding is
-  computed on this text. The --attach flag can provide an alternative DB file so
-  the SQL query can read from it.
-* With --files or --files-list, the inputs are taken from the filesystem, each
-  file becoming the contents to be embedded. The file name or path becomes
-  the ID.
+  computed on this text. With --id-column, another column of the query (by
+  name, or by its 1-based position) is used as the ID instead. The --attach flag can provide other DB files so the
+  SQL query can read from them; it can be repeated to attach several DBs.
+  With --title-column, one of the text columns (by name, or by its 1-based
+  position in the query's columns) is sent to the model as the document's
+  title instead of being concatenated into the text; titles are only
+  supported with the RETRIEVAL_DOCUMENT task type.
+* With --files, --files-list or --files-stdin, the inputs are taken from the
+  filesystem, each file becoming the contents to be embedded. The file name or
+  path becomes the ID. --files-stdin reads the paths from standard input, one
+  per line (e.g. piped from find or git ls-files); files that can't be read
+  are skipped with a warning. With --input-format, each file holds multiple
+  records instead: "jsonl" expects one {"id": ..., "text": ...} JSON object
+  per line, and "csv" expects the ID in the first column and the text in the
+  rest (there's no header line).
 * Otherwise, the input is read from a file provided as an argument (or '-',
   which reads from standard input). The format of the file should be either CSV,
   TSV (tab-separated), JSON or JSONLines (one line per JSON object). At least 2
-  columns are expected: one for ID, and the rest are concatenated as inputs to
-  the embedding model.
+  columns are expected: one for ID, and the rest are concatenated (in the order
+  of their names) as inputs to the embedding model.
+
+The texts are sent to the model in batches of --batch-size texts. With
+--concurrency N, up to N batches are sent in parallel, which can speed up
+large jobs considerably. The embeddings of each batch are stored as soon as
+it's done; if a run is interrupted, running the same command again with
+--resume only embeds the values that don't have an embedding in the table yet.
+
+An error embedding a batch normally stops the run. With --continue-on-error,
+the texts of a failed batch are embedded one by one instead; those that still
+fail are reported with their ids and left out of the table, and the rest is
+embedded. At the end, the number of values that failed is reported, and the
+command exits with an error if there were any.
+
+Embeddings are cached in the DB by a hash of the model, task type, title and
+text they were calculated from. Values whose content was embedded before
+(under any ID and into any table of the DB), or that appear several times in
+the input, are only sent to the model once. --no-cache turns the cache off.
+The same hash is stored with each embedding, in the 'content_hash' column of
+the table; 'embed reindex' uses it to only embed the values that changed.
+
+With --estimate, nothing is embedded; instead, the number of tokens in the
+texts that would be embedded is estimated and reported, along with the cost
+of embedding them at --price-per-1k per 1000 tokens. The estimate is
+calculated locally (at about 4 characters per token) without calling the API,
+so it's approximate.
+
+With --normalize, each embedding is scaled to a unit vector (L2-normalized)
+before it's stored, and the table is recorded as normalized in the DB. For
+unit vectors, the cosine similarity is just their dot product, so 'embed
+similar' can skip computing the magnitudes of the stored embeddings.
+
+With --chunk-size N, texts longer than N characters are split into chunks of
+up to N characters, cut at whitespace where possible, and each chunk is
+embedded on its own. With --chunk-overlap M, each chunk starts with the last M
+characters of the one before it, so text around the cuts isn't lost. The
+chunks of the value with ID <id> are stored as <id>#0, <id>#1 and so on, and
+the 'parent_id' column of the table has <id> for each of them (and for values
+that weren't split).
+
+With --jsonl, the embeddings aren't stored in a DB (and no DB path is given);
+each is written to stdout as a line of JSON instead, in the format of 'embed
+export', e.g. {"id":"doc1","embedding":[0.013,-0.021,...]}, so they can be
+piped to other tools. This works with --files, --files-list, --files-stdin and
+an input file, which is then the only argument. With --concurrency above 1,
+the lines come in the order the batches finish. There's no cache of
+embeddings without a DB.
 `
 
 func init() {
 	embedCmd.AddCommand(embedDBCmd)
-	embedDBCmd.Flags().String("table", "embeddings", "DB table name to store embeddings into")
-	embedDBCmd.Flags().Int("batch-size", 32, "size of batches (number of rows) to send for embedding")
+	addEmbedDBFlags(embedDBCmd)
+	embedDBCmd.Flags().String("id-conflict", "error", `what to do when inserting IDs that already exist: "error", "replace" or "skip"`)
+	embedDBCmd.Flags().Bool("resume", false, `skip IDs that already have an embedding in the table, e.g. to continue an interrupted run`)
+	embedDBCmd.MarkFlagsMutuallyExclusive("resume", "id-conflict")
+	embedDBCmd.Flags().Bool("jsonl", false, `write the embeddings to stdout as JSON Lines instead of storing them in a DB, which isn't given then; only with --files* or an input file`)
+	for _, flag := range []string{"sql", "table", "store", "metadata", "id-conflict", "resume", "no-cache"} {
+		embedDBCmd.MarkFlagsMutuallyExclusive("jsonl", flag)
+	}
+}
+
+// addEmbedDBFlags adds the flags of embed db that select the values to embed
+// and how they're embedded and stored to cmd; they're shared by embed reindex.
+func addEmbedDBFlags(cmd *cobra.Command) {
+	cmd.Flags().String("table", "embeddings", "DB table name to store embeddings into")
+	cmd.Flags().Int("batch-size", 32, "size of batches (number of rows) to send for embedding")
+	cmd.Flags().Int("concurrency", 1, "maximal number of batches to send for embedding in parallel")
 
-	embedDBCmd.Flags().String("sql", "", "SQL mode with a query")
-	embedDBCmd.Flags().StringSlice("attach", nil, "additional DB to attach - specify <alias>,<filename> pair")
+	cmd.Flags().String("sql", "", "SQL mode with a query")
+	cmd.Flags().StringArray("attach", nil, "additional DB to attach - specify <alias>,<filename> pair; can be repeated")
+	cmd.Flags().String("id-column", "", "in SQL mode, the query column (name or 1-based position) to use as the ID; the first column by default")
+	cmd.Flags().String("title-column", "", "in SQL mode, the query column (name or 1-based position) to use as the document title")
 
-	embedDBCmd.Flags().StringSlice("files", nil, strings.TrimSpace(`
+	cmd.Flags().StringSlice("files", nil, strings.TrimSpace(`
 files to embed as a <root dir>,<glob> pair;
 the directory will be traversed recursively,
 picking all the files that match the glob`))
-	embedDBCmd.Flags().StringSlice("files-list", nil, `comma-separated list of files to embed`)
+	cmd.Flags().StringSlice("files-list", nil, `comma-separated list of files to embed`)
+	cmd.Flags().Bool("files-stdin", false, `read the list of files to embed from stdin, one path per line`)
+	cmd.Flags().String("input-format", "txt", `format of the files in --files* modes: "txt" (each file is a single text, with its path as ID), "jsonl" or "csv"`)
 
-	embedDBCmd.Flags().Bool("store", false, `also store the original content in the embeddings table ('content' column)`)
-	embedDBCmd.Flags().String("metadata", "", `also store this metadata in the embeddings table ('metadata' column)`)
-	embedDBCmd.Flags().String("prefix", "", `prepend a prefix to the stored ID of each row`)
-	embedDBCmd.Flags().String("id-conflict", "error", `what to do when inserting IDs that already exist: "error", "replace" or "skip"`)
+	cmd.Flags().Bool("store", false, `also store the original content in the embeddings table ('content' column)`)
+	cmd.Flags().String("metadata", "", `also store this metadata in the embeddings table ('metadata' column)`)
+	cmd.Flags().String("prefix", "", `prepend a prefix to the stored ID of each row`)
+
+	cmd.Flags().Int("chunk-size", 0, `split texts longer than this many characters into overlapping chunks, embedded as <id>#0, <id>#1 etc.; 0 means no splitting`)
+	cmd.Flags().Int("chunk-overlap", 0, `with --chunk-size, the number of characters at the end of each chunk that the next chunk starts with`)
+	cmd.Flags().Bool("normalize", false, `store the embeddings as unit vectors (L2-normalized), so 'embed similar' can compare them with a dot product`)
+	cmd.Flags().Bool("continue-on-error", false, `when values fail to embed, report their ids and go on embedding the rest, instead of stopping`)
+	cmd.Flags().Bool("no-cache", false, `don't use the cache of embeddings in the DB; embed all values, even if their content was embedded before`)
+	cmd.Flags().Bool("estimate", false, `don't embed anything; report an estimate of the number of tokens to embed and their cost`)
+	cmd.Flags().Float64("price-per-1k", 0, `with --estimate, the price of embedding 1000 tokens, to estimate the cost`)
 }
 
-func runEmbedDBCmd(cmd *cobra.Command, args []string) {
-	dbPath := args[0]
+func runEmbedDBCmd(cmd *cobra.Command, args []string) error {
+	return embedDB(cmd, args, false)
+}
 
+// embedDB implements embed db, and embed reindex if reindex is set: then only
+// the values whose content hash differs from the one stored in the table are
+// embedded, and the rows of ids that aren't in the input anymore are deleted.
+func embedDB(cmd *cobra.Command, args []string, reindex bool) error {
 	sqlMode := mustGetStringFlag(cmd, "sql")
 	filesMode := len(mustGetStringSliceFlag(cmd, "files")) > 0 ||
-		len(mustGetStringSliceFlag(cmd, "files-list")) > 0
+		len(mustGetStringSliceFlag(cmd, "files-list")) > 0 ||
+		mustGetBoolFlag(cmd, "files-stdin")
 
 	if sqlMode != "" && filesMode {
-		log.Fatal("--files* mode is mutually exclusive with --sql")
+		return &usageError{errors.New("--files* mode is mutually exclusive with --sql")}
 	}
 
-	db, err := sql.Open("sqlite", dbPath)
+	taskType, err := embeddingTaskType(cmd, genai.TaskTypeRetrievalDocument)
 	if err != nil {
-		log.Fatalf("unable to open DB at '%v': %v", dbPath, err)
+		return err
+	}
+
+	attachments, err := parseAttachments(mustGetStringArrayFlag(cmd, "attach"))
+	if err != nil {
+		return err
+	}
+	if len(attachments) > 0 && sqlMode == "" {
+		return &usageError{errors.New("--attach is only supported with --sql")}
+	}
+	if cmd.Flags().Changed("input-format") && !filesMode {
+		return &usageError{errors.New("--input-format is only supported with --files, --files-list or --files-stdin")}
+	}
+
+	idColumn := mustGetStringFlag(cmd, "id-column")
+	if idColumn != "" && sqlMode == "" {
+		return &usageError{errors.New("--id-column is only supported with --sql")}
 	}
-	defer db.Close()
 
+	titleColumn := mustGetStringFlag(cmd, "title-column")
+	if titleColumn != "" {
+		if sqlMode == "" {
+			return &usageError{errors.New("--title-column is only supported with --sql")}
+		}
+		if taskType != genai.TaskTypeRetrievalDocument {
+			return &usageError{errors.New("--title-column requires the RETRIEVAL_DOCUMENT task type")}
+		}
+	}
+
+	if price := mustGetFloat64Flag(cmd, "price-per-1k"); price < 0 {
+		return &usageError{fmt.Errorf("--price-per-1k can't be negative, got %v", price)}
+	}
+	if cmd.Flags().Changed("price-per-1k") && !mustGetBoolFlag(cmd, "estimate") {
+		return &usageError{errors.New("--price-per-1k is only supported with --estimate")}
+	}
+
+	if concurrency := mustGetIntFlag(cmd, "concurrency"); concurrency < 1 {
+		return &usageError{fmt.Errorf("--concurrency must be positive, got %v", concurrency)}
+	}
+
+	chunkSize := mustGetIntFlag(cmd, "chunk-size")
+	chunkOverlap := mustGetIntFlag(cmd, "chunk-overlap")
+	if chunkSize < 0 {
+		return &usageError{fmt.Errorf("--chunk-size can't be negative, got %v", chunkSize)}
+	}
+	if cmd.Flags().Changed("chunk-overlap") && chunkSize == 0 {
+		return &usageError{errors.New("--chunk-overlap is only supported with --chunk-size")}
+	}
+	if chunkOverlap < 0 || (chunkSize > 0 && chunkOverlap >= chunkSize) {
+		return &usageError{fmt.Errorf("--chunk-overlap must be at least 0 and less than --chunk-size, got %v", chunkOverlap)}
+	}
+
+	if !reindex && mustGetBoolFlag(cmd, "jsonl") {
+		return embedJSONL(cmd, args, filesMode, taskType, chunkSize, chunkOverlap)
+	}
+	dbPath := args[0]
+
+	// The table name is interpolated into SQL statements, so it has to be a
+	// plain identifier.
 	tableName := mustGetStringFlag(cmd, "table")
+	if err := checkIdentifier("--table", tableName); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return fmt.Errorf("unable to open DB at '%v': %w", dbPath, err)
+	}
+	defer db.Close()
+	// ATTACH applies to a single connection; make sure all statements run on
+	// the same one.
+	db.SetMaxOpenConns(1)
 
 	// Build up table schema based on passed flags
 	columns := []string{
@@ -97,6 +272,10 @@ func runEmbedDBCmd(cmd *cobra.Command, args []string) {
 	if mustGetStringFlag(cmd, "metadata") != "" {
 		columns = append(columns, "metadata TEXT")
 	}
+	if chunkSize > 0 {
+		columns = append(columns, "parent_id TEXT")
+	}
+	columns = append(columns, "content_hash TEXT")
 
 	tableCreateSchema := strings.TrimSpace(fmt.Sprintf(`
 	CREATE TABLE IF NOT EXISTS %s (
@@ -105,7 +284,29 @@ func runEmbedDBCmd(cmd *cobra.Command, args []string) {
 
 	_, err = db.Exec(tableCreateSchema)
 	if err != nil {
-		log.Fatalf("unable to create table '%v' in DB: %v", tableName, err)
+		return fmt.Errorf("unable to create table '%v' in DB: %w", tableName, err)
+	}
+	// Tables created before content hashes were stored get the column now;
+	// their existing rows have no hash.
+	hasHash, err := hasColumn(db, tableName, "content_hash")
+	if err != nil {
+		return err
+	}
+	if !hasHash {
+		if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN content_hash TEXT`, tableName)); err != nil {
+			return fmt.Errorf("unable to add content_hash column to table '%v': %w", tableName, err)
+		}
+	}
+	if chunkSize > 0 {
+		hasParent, err := hasColumn(db, tableName, "parent_id")
+		if err != nil {
+			return err
+		}
+		if !hasParent {
+			if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN parent_id TEXT`, tableName)); err != nil {
+				return fmt.Errorf("unable to add parent_id column to table '%v': %w", tableName, err)
+			}
+		}
 	}
 
 	// We extract a list of [id, text] pairs - either from the DB itself (in --sql
@@ -114,186 +315,729 @@ func runEmbedDBCmd(cmd *cobra.Command, args []string) {
 	// columns following ID that the SQL query specifies.
 	var ids []string
 	var texts []string
+	// titles is only populated with --title-column; otherwise it stays empty.
+	var titles []string
 
 	if sqlMode != "" {
-		attachPair := mustGetStringSliceFlag(cmd, "attach")
-		if len(attachPair) > 0 {
-			if len(attachPair) != 2 {
-				log.Fatal("expect <alias>,<db path> pair for --attach")
-			}
-
-			alias := attachPair[0]
-			path := attachPair[1]
-
-			attachStmt := fmt.Sprintf("ATTACH DATABASE '%v' as %v", path, alias)
-			_, err := db.Exec(attachStmt)
-			if err != nil {
-				log.Fatalf("unable to attach %v: %v", path, err)
-			}
+		if err := attachDatabases(db, attachments); err != nil {
+			return err
 		}
 
 		rows, err := db.Query(sqlMode)
 		if err != nil {
-			log.Fatal("error running SQL query:", err)
+			return fmt.Errorf("error running SQL query: %w", err)
 		}
 		defer rows.Close()
 
+		colNames, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		idIndex := 0
+		if idColumn != "" {
+			idIndex, err = queryColumnIndex("--id-column", idColumn, colNames)
+			if err != nil {
+				return err
+			}
+		}
+		titleIndex := -1
+		if titleColumn != "" {
+			titleIndex, err = titleColumnIndex(titleColumn, colNames, idIndex)
+			if err != nil {
+				return err
+			}
+		}
+
 		for rows.Next() {
 			// Scan all len(colNames) columns into the values slice.
-			values := scanRowIntoSlice(rows)
+			values, err := scanRowIntoSlice(rows)
+			if err != nil {
+				return err
+			}
 			if len(values) < 2 {
-				log.Fatalf("expect at least 2 columns from query; got %v", len(values))
+				return fmt.Errorf("expect at least 2 columns from query; got %v", len(values))
 			}
 
 			var rowTexts []string
-			for _, v := range values[1:] {
-				rowTexts = append(rowTexts, fmt.Sprintf("%v", v))
+			for i, v := range values {
+				switch i {
+				case idIndex:
+				case titleIndex:
+					titles = append(titles, fmt.Sprintf("%v", v))
+				default:
+					rowTexts = append(rowTexts, fmt.Sprintf("%v", v))
+				}
 			}
-			ids = append(ids, fmt.Sprintf("%v", values[0]))
+			ids = append(ids, fmt.Sprintf("%v", values[idIndex]))
 			texts = append(texts, strings.Join(rowTexts, " "))
 		}
 
 		// Check for errors from iterating over rows.
 		if err := rows.Err(); err != nil {
-			log.Fatal("error scanning DB:", err)
+			return fmt.Errorf("error scanning DB: %w", err)
 		}
 	} else if filesMode {
-		ids, texts = collectFiles(cmd)
+		ids, texts, err = collectFiles(cmd)
+		if err != nil {
+			return err
+		}
 	} else {
 		if len(args) < 2 {
-			log.Fatal("when --sql or --files* is not passed, expect filename or '-' as second argument")
+			return errors.New("when --sql or --files* is not passed, expect filename or '-' as second argument")
+		}
+		ids, texts, err = readInputFile(cmd, args[1])
+		if err != nil {
+			return err
 		}
-		inputFilename := args[1]
+	}
+	log.Printf("Found %d values to embed", len(texts))
 
-		var inputReader io.Reader
-		if inputFilename == "-" {
-			inputReader = cmd.InOrStdin()
-		} else {
-			file, err := os.Open(inputFilename)
-			if err != nil {
-				log.Fatalf("unable to open %v: %v", inputFilename, err)
+	// With --chunk-size, texts that are longer are split into chunks, each
+	// embedded as a value of its own. parentIDs maps the ids of all the
+	// values to the id of the text they're from, which is stored in the
+	// parent_id column.
+	var parentIDs map[string]string
+	if chunkSize > 0 {
+		ids, texts, titles, parentIDs = chunkValues(ids, texts, titles, chunkSize, chunkOverlap)
+	}
+
+	modelName := mustGetStringFlag(cmd, "model")
+	prefix := mustGetStringFlag(cmd, "prefix")
+	resume := !reindex && mustGetBoolFlag(cmd, "resume")
+	if resume {
+		embedded, err := embeddedIDs(db, tableName)
+		if err != nil {
+			return err
+		}
+		ids, texts, titles = skipEmbedded(ids, texts, titles, prefix, embedded)
+		log.Printf("Skipping values that are already embedded; %d left to embed", len(texts))
+	}
+
+	// contentHash returns the hash stored with the embedding of the i-th
+	// value, which tells embed reindex whether it changed. It's the key of
+	// the embedding in the cache.
+	contentHash := func(i int) string {
+		title := ""
+		if len(titles) > 0 {
+			title = titles[i]
+		}
+		return embeddingCacheKey(modelName, taskType, title, texts[i])
+	}
+
+	if reindex {
+		stored, err := storedContentHashes(db, tableName, prefix)
+		if err != nil {
+			return err
+		}
+		var changedIDs, changedTexts, changedTitles []string
+		inInput := make(map[string]bool)
+		for i, id := range ids {
+			inInput[prefix+id] = true
+			if hash, ok := stored[prefix+id]; ok && hash == contentHash(i) {
+				continue
+			}
+			changedIDs = append(changedIDs, id)
+			changedTexts = append(changedTexts, texts[i])
+			if len(titles) > 0 {
+				changedTitles = append(changedTitles, titles[i])
+			}
+		}
+		var removed []string
+		for id := range stored {
+			if !inInput[id] {
+				removed = append(removed, id)
 			}
-			inputReader = file
 		}
+		slices.Sort(removed)
+		log.Printf("Reindexing: %d values are new or changed, %d unchanged; %d ids aren't in the input anymore", len(changedTexts), len(texts)-len(changedTexts), len(removed))
+		ids, texts, titles = changedIDs, changedTexts, changedTitles
 
-		_, table, err := tableloader.LoadTable(inputReader, tableloader.FormatUnknown)
+		if !mustGetBoolFlag(cmd, "estimate") {
+			if err := deleteIDs(db, tableName, removed); err != nil {
+				return err
+			}
+		}
+	}
+
+	if mustGetBoolFlag(cmd, "estimate") {
+		printEstimate(cmd, texts, titles)
+		return nil
+	}
+
+	dims, err := embeddingDimensions(cmd, modelName)
+	if err != nil {
+		return err
+	}
+	normalize := mustGetBoolFlag(cmd, "normalize")
+	prevMeta, err := readEmbeddingMeta(db, tableName)
+	if err != nil {
+		return err
+	}
+	if prevMeta.model != "" && prevMeta.model != modelName {
+		log.Printf("WARNING: table %s has embeddings from model %s; adding embeddings from model %s", tableName, prevMeta.model, modelName)
+	}
+	// The table is only recorded as normalized if all of its embeddings are.
+	meta := embeddingMeta{model: modelName, normalized: normalize}
+	if prevMeta.model != "" && prevMeta.normalized != normalize {
+		log.Printf("WARNING: table %s has embeddings that are %s; adding embeddings that are %s", tableName, normalizedDesc(prevMeta.normalized), normalizedDesc(normalize))
+		meta.normalized = false
+	}
+	if err := writeEmbeddingMeta(db, tableName, meta); err != nil {
+		return err
+	}
+
+	insertColumns := []string{"id", "embedding"}
+	if mustGetBoolFlag(cmd, "store") {
+		insertColumns = append(insertColumns, "content")
+	}
+	if mustGetStringFlag(cmd, "metadata") != "" {
+		insertColumns = append(insertColumns, "metadata")
+	}
+	if parentIDs != nil {
+		insertColumns = append(insertColumns, "parent_id")
+	}
+	insertColumns = append(insertColumns, "content_hash")
+
+	insertOr := ""
+	switch {
+	case resume, reindex:
+		// With --resume, the ids that are left either aren't in the table, or
+		// are in it without an embedding; the latter are replaced. Reindexing
+		// replaces the rows of the values that changed.
+		insertOr = "OR REPLACE"
+	case mustGetStringFlag(cmd, "id-conflict") == "skip":
+		insertOr = "OR IGNORE"
+	case mustGetStringFlag(cmd, "id-conflict") == "replace":
+		insertOr = "OR REPLACE"
+	default:
+		// With --id-conflict error, don't add anything; the SQL INSERT will
+		// error out on conflicts.
+	}
+
+	query := fmt.Sprintf("INSERT %s INTO %s (%s) VALUES (%s)",
+		insertOr, tableName, strings.Join(insertColumns, ", "), strings.Join(strings.Split(strings.Repeat("?", len(insertColumns)), ""), ", "))
+
+	ctx, stop := newCommandContext()
+	defer stop()
+	client, err := newClient(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	em := client.EmbeddingModel(modelName)
+	em.TaskType = taskType
+
+	numEmbs := 0
+	insertRow := func(i int, emb []float32) error {
+		id := prefix + ids[i]
+
+		emb = truncateEmbedding(emb, dims)
+		if normalize {
+			emb = normalizeEmbedding(emb)
+		}
+		columns := []any{id, encodeEmbedding(emb)}
+		if mustGetBoolFlag(cmd, "store") {
+			columns = append(columns, texts[i])
+		}
+		if metadata := mustGetStringFlag(cmd, "metadata"); metadata != "" {
+			columns = append(columns, metadata)
+		}
+		if parentIDs != nil {
+			columns = append(columns, prefix+parentIDs[ids[i]])
+		}
+		columns = append(columns, contentHash(i))
+		_, err := db.Exec(query, columns...)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("unable to insert embedding into DB (id = %v): %w", id, err)
 		}
+		numEmbs++
+		return nil
+	}
 
-		for _, row := range table {
-			// It's mandatory to have an 'id'; the other columns will be concatenated
-			// into texts.
-			id, ok := row["id"]
-			if !ok {
-				log.Fatalf("expect input row to have 'id' column; got %v", row)
+	// Group the rows by the embedding they need: with the cache, rows with the
+	// same content share an embedding, which is only calculated once (and not
+	// at all if it's cached). Without it, each row is embedded on its own.
+	var groups [][]int
+	var keys []string
+	var cache *embeddingCache
+	if mustGetBoolFlag(cmd, "no-cache") {
+		for i := range texts {
+			groups = append(groups, []int{i})
+		}
+	} else {
+		if cache, err = openEmbeddingCache(db); err != nil {
+			return err
+		}
+		groupOfKey := make(map[string]int)
+		numCached := 0
+		for i := range texts {
+			key := contentHash(i)
+			if g, ok := groupOfKey[key]; ok {
+				groups[g] = append(groups[g], i)
+				continue
 			}
 
-			var rowTexts []string
-			for k, v := range row {
-				if k != "id" {
-					rowTexts = append(rowTexts, v)
+			emb, err := cache.get(key)
+			if err != nil {
+				return err
+			}
+			if emb != nil {
+				numCached++
+				if err := insertRow(i, emb); err != nil {
+					return err
 				}
+				continue
 			}
+			groupOfKey[key] = len(groups)
+			groups = append(groups, []int{i})
+			keys = append(keys, key)
+		}
+		if numCached > 0 || len(groups) < len(texts) {
+			log.Printf("Found %d values in the embedding cache; %d distinct values left to embed", numCached, len(groups))
+		}
+	}
 
-			ids = append(ids, id)
-			texts = append(texts, strings.Join(rowTexts, " "))
+	groupTexts := make([]string, len(groups))
+	var groupTitles []string
+	for g, rows := range groups {
+		groupTexts[g] = texts[rows[0]]
+		if len(titles) > 0 {
+			groupTitles = append(groupTitles, titles[rows[0]])
 		}
 	}
-	log.Printf("Found %d values to embed", len(texts))
 
-	ctx := context.Background()
-	client, err := newGenaiClient(ctx, cmd)
+	// The embeddings of each batch are inserted as soon as the batch is done,
+	// so an interrupted run keeps the embeddings calculated so far (and can be
+	// continued with --resume).
+	// With --continue-on-error, the values that fail to embed are reported
+	// and left out of the table.
+	numFailed := 0
+	var onError func(g int, err error)
+	if mustGetBoolFlag(cmd, "continue-on-error") {
+		onError = func(g int, err error) {
+			for _, row := range groups[g] {
+				log.Printf("WARNING: unable to embed id %v: %v", prefix+ids[row], err)
+				numFailed++
+			}
+		}
+	}
+	err = embedTexts(ctx, cmd, em, groupTexts, groupTitles, func(first int, embs [][]float32) error {
+		for i, emb := range embs {
+			if emb == nil {
+				continue
+			}
+			g := first + i
+			if cache != nil {
+				if err := cache.put(keys[g], emb); err != nil {
+					return err
+				}
+			}
+			for _, row := range groups[g] {
+				if err := insertRow(row, emb); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}, onError)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer client.Close()
-	em := client.EmbeddingModel(mustGetStringFlag(cmd, "model"))
 
-	batchSize := mustGetIntFlag(cmd, "batch-size")
-	numBatches := len(texts) / batchSize
-	if len(texts)%batchSize != 0 {
-		numBatches++
+	log.Printf("Inserted %d embeddings into table %s", numEmbs, tableName)
+	if numFailed > 0 {
+		return fmt.Errorf("failed to embed %d of %d values; run again with --resume to retry them", numFailed, numFailed+numEmbs)
 	}
-	log.Printf("Splitting to %d batches", numBatches)
+	return nil
+}
 
-	// Build a batch and send it for embedding. cursor points to the current
-	// text being added to a batch.
-	cursor := 0
-	embs := make([][]float32, 0, len(texts))
-	for bn := 0; bn < numBatches; bn++ {
-		batch := em.NewBatch()
+// readInputFile reads the values to embed from the input file at path, or
+// from stdin if path is '-': a table (e.g. CSV or JSON Lines) with an "id"
+// column, and the other columns of each row concatenated into its text, in
+// the order of their names.
+func readInputFile(cmd *cobra.Command, path string) ([]string, []string, error) {
+	var inputReader io.Reader
+	if path == "-" {
+		inputReader = cmd.InOrStdin()
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to open %v: %w", path, err)
+		}
+		defer file.Close()
+		inputReader = file
+	}
+
+	_, table, err := tableloader.LoadTable(inputReader, tableloader.FormatUnknown)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		sizeOfThisBatch := batchSize
-		if cursor+batchSize >= len(texts) {
-			sizeOfThisBatch = len(texts) - cursor
+	var ids, texts []string
+	for _, row := range table {
+		// It's mandatory to have an 'id'; the other columns will be concatenated
+		// into texts.
+		id, ok := row["id"]
+		if !ok {
+			return nil, nil, fmt.Errorf("expect input row to have 'id' column; got %v", row)
 		}
-		log.Printf("Embedding batch #%d / %d, size=%d", bn+1, numBatches, sizeOfThisBatch)
 
-		for i := 0; i < sizeOfThisBatch; i++ {
-			batch.AddContent(genai.Text(texts[cursor]))
-			cursor++
+		// The columns are joined in the order of their names, so that the
+		// text (and its content hash) is the same every time the row is read.
+		var columns []string
+		for k := range row {
+			if k != "id" {
+				columns = append(columns, k)
+			}
+		}
+		slices.Sort(columns)
+		var rowTexts []string
+		for _, k := range columns {
+			rowTexts = append(rowTexts, row[k])
 		}
 
-		res, err := em.BatchEmbedContents(ctx, batch)
-		if err != nil {
-			log.Fatalf("error embedding batch %d: %v", bn, err)
+		ids = append(ids, id)
+		texts = append(texts, strings.Join(rowTexts, " "))
+	}
+	return ids, texts, nil
+}
+
+// chunkValues splits the texts of the values with ids (and titles, if there
+// are any) into chunks with chunkText. Each chunk becomes a value of its own,
+// with the id <id>#<n> if the text was split, and the same title as its text.
+// The returned map has the id of the text that each value is from.
+func chunkValues(ids, texts, titles []string, size, overlap int) ([]string, []string, []string, map[string]string) {
+	parentIDs := make(map[string]string)
+	var chunkIDs, chunkTexts, chunkTitles []string
+	for i, text := range texts {
+		chunks := chunkText(text, size, overlap)
+		for n, chunk := range chunks {
+			id := ids[i]
+			if len(chunks) > 1 {
+				id = fmt.Sprintf("%s#%d", ids[i], n)
+			}
+			parentIDs[id] = ids[i]
+			chunkIDs = append(chunkIDs, id)
+			chunkTexts = append(chunkTexts, chunk)
+			if len(titles) > 0 {
+				chunkTitles = append(chunkTitles, titles[i])
+			}
+		}
+	}
+	if len(chunkTexts) > len(texts) {
+		log.Printf("Split %d values into %d chunks of up to %d characters", len(texts), len(chunkTexts), size)
+	}
+	return chunkIDs, chunkTexts, chunkTitles, parentIDs
+}
+
+// printEstimate reports the estimated number of tokens in texts and titles,
+// and their cost with --price-per-1k, for --estimate.
+func printEstimate(cmd *cobra.Command, texts, titles []string) {
+	tokens := 0
+	for i, text := range texts {
+		tokens += estimateTokens(text)
+		if len(titles) > 0 {
+			tokens += estimateTokens(titles[i])
 		}
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Estimated tokens: %d in %d texts\n", tokens, len(texts))
+	if price := mustGetFloat64Flag(cmd, "price-per-1k"); price > 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "Estimated cost: %.4f (at %v per 1k tokens)\n", float64(tokens)/1000*price, price)
+	}
+}
 
-		if len(res.Embeddings) != sizeOfThisBatch {
-			log.Fatalf("expected %d embeddings for batch, got %d", sizeOfThisBatch, len(res.Embeddings))
+// embedJSONL implements embed db --jsonl: the values from the files (in
+// files mode) or from the input file in args are embedded, and each
+// embedding is written to stdout as a line of JSON (see embeddingJSON),
+// without a DB.
+func embedJSONL(cmd *cobra.Command, args []string, filesMode bool, taskType genai.TaskType, chunkSize, chunkOverlap int) error {
+	var ids, texts []string
+	var err error
+	if filesMode {
+		ids, texts, err = collectFiles(cmd)
+	} else {
+		if len(args) < 1 {
+			return errors.New("when --files* is not passed, expect filename or '-' as argument with --jsonl")
 		}
+		ids, texts, err = readInputFile(cmd, args[0])
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Found %d values to embed", len(texts))
+
+	var parentIDs map[string]string
+	if chunkSize > 0 {
+		ids, texts, _, parentIDs = chunkValues(ids, texts, nil, chunkSize, chunkOverlap)
+	}
+	if mustGetBoolFlag(cmd, "estimate") {
+		printEstimate(cmd, texts, nil)
+		return nil
+	}
 
-		for _, e := range res.Embeddings {
-			embs = append(embs, e.Values)
+	modelName := mustGetStringFlag(cmd, "model")
+	dims, err := embeddingDimensions(cmd, modelName)
+	if err != nil {
+		return err
+	}
+	prefix := mustGetStringFlag(cmd, "prefix")
+	normalize := mustGetBoolFlag(cmd, "normalize")
+
+	ctx, stop := newCommandContext()
+	defer stop()
+	client, err := newClient(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	em := client.EmbeddingModel(modelName)
+	em.TaskType = taskType
+
+	numEmbs, numFailed := 0, 0
+	var onError func(i int, err error)
+	if mustGetBoolFlag(cmd, "continue-on-error") {
+		onError = func(i int, err error) {
+			log.Printf("WARNING: unable to embed id %v: %v", prefix+ids[i], err)
+			numFailed++
+		}
+	}
+	enc := json.NewEncoder(cmd.OutOrStdout())
+	err = embedTexts(ctx, cmd, em, texts, nil, func(first int, embs [][]float32) error {
+		for i, emb := range embs {
+			if emb == nil {
+				continue
+			}
+			emb = truncateEmbedding(emb, dims)
+			if normalize {
+				emb = normalizeEmbedding(emb)
+			}
+			ej := embeddingJSON{ID: prefix + ids[first+i], Embedding: emb}
+			if parentIDs != nil {
+				ej.ParentID = prefix + parentIDs[ids[first+i]]
+			}
+			if err := enc.Encode(ej); err != nil {
+				return err
+			}
+			numEmbs++
 		}
+		return nil
+	}, onError)
+	if err != nil {
+		return err
 	}
 
-	log.Printf("Collected %d embeddings; inserting into table %s", len(embs), tableName)
+	log.Printf("Wrote %d embeddings", numEmbs)
+	if numFailed > 0 {
+		return fmt.Errorf("failed to embed %d of %d values", numFailed, numFailed+numEmbs)
+	}
+	return nil
+}
 
-	numColumns := 2
-	if mustGetBoolFlag(cmd, "store") {
-		numColumns++
+// chunkText splits text into chunks of at most size characters, each starting
+// with the last overlap characters of the one before it. Chunks are cut at
+// whitespace where there's some in the second half of the chunk, so that
+// words aren't split. Text of at most size characters is a single chunk.
+func chunkText(text string, size int, overlap int) []string {
+	runes := []rune(text)
+	if len(runes) <= size {
+		return []string{text}
 	}
-	if mustGetStringFlag(cmd, "metadata") != "" {
-		numColumns++
+
+	var chunks []string
+	for start := 0; ; {
+		end := min(start+size, len(runes))
+		if end < len(runes) {
+			for j := end; j > start+size/2; j-- {
+				if unicode.IsSpace(runes[j]) {
+					end = j
+					break
+				}
+			}
+		}
+		if chunk := strings.TrimSpace(string(runes[start:end])); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		if end == len(runes) {
+			return chunks
+		}
+		start = max(end-overlap, start+1)
 	}
+}
 
-	idConflictStrategy := mustGetStringFlag(cmd, "id-conflict")
-	insertOr := ""
-	switch idConflictStrategy {
-	case "error":
-		// Don't add anything; the SQL INSERT will error out on conflcts.
-	case "skip":
-		insertOr = "OR IGNORE"
-	case "replace":
-		insertOr = "OR REPLACE"
-	default:
-		log.Fatal("invalid value of --id-conflict flag")
+// embeddedIDs returns the set of ids in tableName that have an embedding.
+func embeddedIDs(db *sql.DB, tableName string) (map[string]bool, error) {
+	rows, err := db.Query(fmt.Sprintf(`SELECT id FROM %s WHERE embedding IS NOT NULL`, tableName))
+	if err != nil {
+		return nil, fmt.Errorf("error reading ids from table %s: %w", tableName, err)
 	}
+	defer rows.Close()
 
-	query := fmt.Sprintf("INSERT %s INTO %s VALUES (%s)",
-		insertOr, tableName, strings.Join(strings.Split(strings.Repeat("?", numColumns), ""), ", "))
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// storedContentHashes returns the content hashes of the rows of tableName
+// whose ids start with prefix, by id. Rows without a hash (stored before they
+// were recorded) or without an embedding have an empty hash.
+func storedContentHashes(db *sql.DB, tableName string, prefix string) (map[string]string, error) {
+	query := fmt.Sprintf(`SELECT id, CASE WHEN embedding IS NULL THEN '' ELSE coalesce(content_hash, '') END FROM %s`, tableName)
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("error reading content hashes from table %s: %w", tableName, err)
+	}
+	defer rows.Close()
 
-	for i, emb := range embs {
-		id := ids[i]
-		if prefix := mustGetStringFlag(cmd, "prefix"); prefix != "" {
-			id = prefix + id
+	hashes := make(map[string]string)
+	for rows.Next() {
+		var id, hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			return nil, err
 		}
+		if strings.HasPrefix(id, prefix) {
+			hashes[id] = hash
+		}
+	}
+	return hashes, rows.Err()
+}
 
-		columns := []any{id, encodeEmbedding(emb)}
-		if mustGetBoolFlag(cmd, "store") {
-			columns = append(columns, texts[i])
+// deleteIDs deletes the rows of ids from tableName.
+func deleteIDs(db *sql.DB, tableName string, ids []string) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, tableName)
+	for _, id := range ids {
+		if _, err := db.Exec(query, id); err != nil {
+			return fmt.Errorf("unable to delete id %v from table %s: %w", id, tableName, err)
 		}
-		if metadata := mustGetStringFlag(cmd, "metadata"); metadata != "" {
-			columns = append(columns, metadata)
+	}
+	if len(ids) > 0 {
+		log.Printf("Deleted %d ids from table %s", len(ids), tableName)
+	}
+	return nil
+}
+
+// skipEmbedded filters out the ids (with their texts and titles, if any) that
+// are in embedded when prefixed with prefix.
+func skipEmbedded(ids, texts, titles []string, prefix string, embedded map[string]bool) ([]string, []string, []string) {
+	var leftIDs, leftTexts, leftTitles []string
+	for i, id := range ids {
+		if embedded[prefix+id] {
+			continue
 		}
-		_, err = db.Exec(query, columns...)
-		if err != nil {
-			log.Fatalf("unable to insert embedding into DB (id = %v): %v", id, err)
+		leftIDs = append(leftIDs, id)
+		leftTexts = append(leftTexts, texts[i])
+		if len(titles) > 0 {
+			leftTitles = append(leftTitles, titles[i])
 		}
 	}
+	return leftIDs, leftTexts, leftTitles
+}
+
+// embedTexts calculates the embeddings of texts with em. If titles isn't
+// empty, it has the title of each text. The texts are sent in batches of
+// --batch-size, with up to --concurrency batches in flight at once. As each
+// batch is done, store is called with the embeddings of the batch and the
+// index of the first of its texts; calls to store don't overlap, but come in
+// the order the batches complete. If embedding a batch or storing it fails,
+// the batches still in flight are canceled and the error is returned.
+//
+// If onError isn't nil, a batch that fails to embed is embedded again text by
+// text, and the texts that still fail are passed to onError (in the same
+// goroutine as store) instead of failing the whole run; their embeddings are
+// nil in the call to store.
+func embedTexts(ctx context.Context, cmd *cobra.Command, em *genai.EmbeddingModel, texts []string, titles []string, store func(first int, embs [][]float32) error, onError func(i int, err error)) error {
+	batchSize := mustGetIntFlag(cmd, "batch-size")
+	numBatches := len(texts) / batchSize
+	if len(texts)%batchSize != 0 {
+		numBatches++
+	}
+	log.Printf("Splitting to %d batches", numBatches)
+
+	var mu sync.Mutex
+	prog := newProgress("Embedded", len(texts))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(mustGetIntFlag(cmd, "concurrency"))
+	for bn := 0; bn < numBatches && gctx.Err() == nil; bn++ {
+		first := bn * batchSize
+		last := min(first+batchSize, len(texts))
+
+		g.Go(func() error {
+			// On a terminal, the progress bar shows how far along the batches are.
+			if !prog.tty {
+				log.Printf("Embedding batch #%d / %d, size=%d", bn+1, numBatches, last-first)
+			}
+			embs, err := embedBatch(gctx, cmd, em, texts, titles, first, last)
+			errs := make([]error, last-first)
+			if err != nil {
+				if onError == nil || gctx.Err() != nil {
+					return fmt.Errorf("error embedding batch %d: %w", bn, err)
+				}
+				// Find out which of the texts failed.
+				embs = make([][]float32, last-first)
+				for i := first; i < last; i++ {
+					single, err := embedBatch(gctx, cmd, em, texts, titles, i, i+1)
+					if gctx.Err() != nil {
+						return fmt.Errorf("error embedding batch %d: %w", bn, err)
+					}
+					if err != nil {
+						errs[i-first] = err
+						continue
+					}
+					embs[i-first] = single[0]
+				}
+			}
 
+			mu.Lock()
+			defer mu.Unlock()
+			for i, err := range errs {
+				if err != nil {
+					onError(first+i, err)
+				}
+			}
+			if err := store(first, embs); err != nil {
+				return err
+			}
+			prog.add(len(embs))
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// embedBatch calculates the embeddings of texts[first:last] (with their
+// titles, if there are any) with em in a single request.
+func embedBatch(ctx context.Context, cmd *cobra.Command, em *genai.EmbeddingModel, texts []string, titles []string, first, last int) ([][]float32, error) {
+	batch := em.NewBatch()
+	for i := first; i < last; i++ {
+		if len(titles) > 0 {
+			batch.AddContentWithTitle(titles[i], genai.Text(texts[i]))
+		} else {
+			batch.AddContent(genai.Text(texts[i]))
+		}
+	}
+
+	// The timeout applies to each batch separately, since the number of
+	// batches in a run can be very large.
+	batchCtx, cancel := withRequestTimeout(ctx, cmd)
+	defer cancel()
+	res, err := em.BatchEmbedContents(batchCtx, batch)
+	if err != nil {
+		return nil, requestError(batchCtx, cmd, err)
+	}
+
+	if len(res.Embeddings) != last-first {
+		return nil, fmt.Errorf("expected %d embeddings for batch, got %d", last-first, len(res.Embeddings))
+	}
+	embs := make([][]float32, len(res.Embeddings))
+	for i, e := range res.Embeddings {
+		embs[i] = e.Values
+	}
+	return embs, nil
 }
 
 // encodeEmbedding encodes an embedding into a byte buffer, e.g. for DB
@@ -329,10 +1073,10 @@ func decodeEmbedding(b []byte) []float32 {
 }
 
 // scanRowIntoSlice scans a row into a slice of any.
-func scanRowIntoSlice(row *sql.Rows) []any {
+func scanRowIntoSlice(row *sql.Rows) ([]any, error) {
 	colNames, err := row.Columns()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	values := make([]interface{}, len(colNames))
@@ -343,46 +1087,290 @@ func scanRowIntoSlice(row *sql.Rows) []any {
 
 	err = row.Scan(scanArgs...)
 	if err != nil {
-		log.Fatal("error scanning row:", err)
+		return nil, fmt.Errorf("error scanning row: %w", err)
+	}
+	return values, nil
+}
+
+// embeddingsMetaTable is the name of the table in which embed db records
+// how the embeddings in each embeddings table were computed (see
+// embeddingMeta), so they can be compared with embeddings from the same model
+// later.
+const embeddingsMetaTable = "gemini_cli_meta"
+
+// embeddingMeta is what's recorded in embeddingsMetaTable about the embeddings
+// in a table.
+type embeddingMeta struct {
+	// model is the name of the model that computed the embeddings.
+	model string
+
+	// normalized is true if all embeddings in the table were stored as unit
+	// vectors (with --normalize).
+	normalized bool
+}
+
+// writeEmbeddingMeta records meta for the embeddings in tableName.
+func writeEmbeddingMeta(db *sql.DB, tableName string, meta embeddingMeta) error {
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (table_name TEXT PRIMARY KEY, model TEXT, normalized INTEGER NOT NULL DEFAULT 0)`, embeddingsMetaTable))
+	if err != nil {
+		return fmt.Errorf("unable to create table '%v' in DB: %w", embeddingsMetaTable, err)
+	}
+	// Tables created by older versions don't have the 'normalized' column.
+	hasNormalized, err := hasColumn(db, embeddingsMetaTable, "normalized")
+	if err != nil {
+		return err
+	}
+	if !hasNormalized {
+		_, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN normalized INTEGER NOT NULL DEFAULT 0`, embeddingsMetaTable))
+		if err != nil {
+			return fmt.Errorf("unable to add column to table '%v' in DB: %w", embeddingsMetaTable, err)
+		}
+	}
+
+	_, err = db.Exec(fmt.Sprintf(`INSERT OR REPLACE INTO %s (table_name, model, normalized) VALUES (?, ?, ?)`, embeddingsMetaTable), tableName, meta.model, meta.normalized)
+	if err != nil {
+		return fmt.Errorf("unable to record embedding model in DB: %w", err)
+	}
+	return nil
+}
+
+// readEmbeddingMeta returns what's recorded for the embeddings in tableName;
+// its model is "" if there's nothing (e.g. for DBs created by older versions).
+func readEmbeddingMeta(db *sql.DB, tableName string) (embeddingMeta, error) {
+	// The metadata of a table in an attached DB (alias.table) is in the meta
+	// table of that DB.
+	schema, table := splitTableName(tableName)
+	metaTable := schema + "." + embeddingsMetaTable
+
+	var exists int
+	err := db.QueryRow(fmt.Sprintf(`SELECT count(*) FROM %s.sqlite_master WHERE type = 'table' AND name = ?`, schema), embeddingsMetaTable).Scan(&exists)
+	if err != nil {
+		return embeddingMeta{}, fmt.Errorf("error reading DB schema: %w", err)
+	}
+	if exists == 0 {
+		return embeddingMeta{}, nil
+	}
+	hasNormalized, err := hasColumn(db, metaTable, "normalized")
+	if err != nil {
+		return embeddingMeta{}, err
+	}
+	normalizedColumn := "0"
+	if hasNormalized {
+		normalizedColumn = "normalized"
+	}
+
+	var meta embeddingMeta
+	err = db.QueryRow(fmt.Sprintf(`SELECT model, %s FROM %s WHERE table_name = ?`, normalizedColumn, metaTable), table).Scan(&meta.model, &meta.normalized)
+	if errors.Is(err, sql.ErrNoRows) {
+		return embeddingMeta{}, nil
+	} else if err != nil {
+		return embeddingMeta{}, fmt.Errorf("error reading embedding model from DB: %w", err)
+	}
+	return meta, nil
+}
+
+// normalizedDesc describes embeddings that are normalized or not.
+func normalizedDesc(normalized bool) string {
+	if normalized {
+		return "normalized"
+	}
+	return "not normalized"
+}
+
+// hasColumn reports whether the table tableName of db has the given column.
+// tableName may be qualified with the alias of an attached DB.
+func hasColumn(db *sql.DB, tableName string, column string) (bool, error) {
+	schema, table := splitTableName(tableName)
+	var n int
+	err := db.QueryRow(`SELECT count(*) FROM pragma_table_info(?, ?) WHERE name = ?`, table, schema, column).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("error reading DB schema: %w", err)
 	}
-	return values
+	return n > 0, nil
 }
 
-// collectFiles reads files provided with the --files or --files-list flags
-// and generates a list of ids (file paths) and a corresponding list of texts
-// (file contents).
-func collectFiles(cmd *cobra.Command) ([]string, []string) {
+// queryColumnIndex finds the index of the column named by the value spec of
+// flagName in colNames, the columns of the --sql query. spec is either a
+// column name or a 1-based column position.
+func queryColumnIndex(flagName string, spec string, colNames []string) (int, error) {
+	index := slices.Index(colNames, spec)
+	if n, err := strconv.Atoi(spec); err == nil && index < 0 {
+		index = n - 1
+		if index < 0 || index >= len(colNames) {
+			return 0, fmt.Errorf("%s %d is out of range; the query has %d columns", flagName, n, len(colNames))
+		}
+	}
+	if index < 0 {
+		return 0, fmt.Errorf("%s %q isn't a column of the query; columns are %v", flagName, spec, colNames)
+	}
+	return index, nil
+}
+
+// titleColumnIndex finds the index of the column named by the --title-column
+// value spec in colNames, the columns of the --sql query (see
+// queryColumnIndex). The column at idIndex is the ID, so it can't be the
+// title; and at least one other column has to be left for the text.
+func titleColumnIndex(spec string, colNames []string, idIndex int) (int, error) {
+	index, err := queryColumnIndex("--title-column", spec, colNames)
+	if err != nil {
+		return 0, err
+	}
+	if index == idIndex {
+		return 0, &usageError{errors.New("--title-column can't be the ID column")}
+	}
+	if len(colNames) < 3 {
+		return 0, errors.New("--title-column needs a query with at least 3 columns: ID, title and text")
+	}
+	return index, nil
+}
+
+// attachment is a DB to attach in --sql mode, as given to --attach.
+type attachment struct {
+	alias string
+	path  string
+}
+
+// parseAttachments parses the values of the --attach flag, each an
+// <alias>,<db path> pair.
+func parseAttachments(values []string) ([]attachment, error) {
+	var attachments []attachment
+	for _, v := range values {
+		alias, path, ok := strings.Cut(v, ",")
+		alias = strings.TrimSpace(alias)
+		path = strings.TrimSpace(path)
+		if !ok || alias == "" || path == "" {
+			return nil, fmt.Errorf("expect <alias>,<db path> pair for --attach, got %q", v)
+		}
+		if err := checkIdentifier("--attach alias", alias); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment{alias: alias, path: path})
+	}
+	return attachments, nil
+}
+
+// attachDatabases attaches all the given DBs to db. The paths are passed as
+// query parameters, so they may contain any characters; the aliases are
+// expected to have been checked with checkIdentifier.
+func attachDatabases(db *sql.DB, attachments []attachment) error {
+	for _, a := range attachments {
+		_, err := db.Exec(fmt.Sprintf("ATTACH DATABASE ? AS %s", a.alias), a.path)
+		if err != nil {
+			return fmt.Errorf("unable to attach %v: %w", a.path, err)
+		}
+	}
+	return nil
+}
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// checkIdentifier checks that name, provided by the flag described by
+// flagDesc, is a plain SQL identifier that's safe to interpolate into a
+// statement: letters, digits and underscores, not starting with a digit.
+func checkIdentifier(flagDesc string, name string) error {
+	if !identifierRegexp.MatchString(name) {
+		return fmt.Errorf("invalid %s %q: expect letters, digits and underscores only, not starting with a digit", flagDesc, name)
+	}
+	return nil
+}
+
+// checkTableName checks that name, provided by the flag described by flagDesc,
+// is a table name that's safe to interpolate into a statement: an identifier
+// (see checkIdentifier), optionally qualified with the alias of an attached
+// DB as <alias>.<table>.
+func checkTableName(flagDesc string, name string) error {
+	if alias, table, ok := strings.Cut(name, "."); ok {
+		if checkIdentifier(flagDesc, alias) != nil || checkIdentifier(flagDesc, table) != nil {
+			return fmt.Errorf("invalid %s %q: expect <table> or <alias>.<table>, with letters, digits and underscores only, not starting with a digit", flagDesc, name)
+		}
+		return nil
+	}
+	return checkIdentifier(flagDesc, name)
+}
+
+// splitTableName splits a table name checked by checkTableName into the
+// schema it's in ("main" if it's not qualified) and its name in the schema.
+func splitTableName(name string) (schema string, table string) {
+	if alias, table, ok := strings.Cut(name, "."); ok {
+		return alias, table
+	}
+	return "main", name
+}
+
+// collectFiles reads files provided with the --files, --files-list or
+// --files-stdin flags and generates a list of ids (file paths) and a
+// corresponding list of texts (file contents).
+func collectFiles(cmd *cobra.Command) ([]string, []string, error) {
 	filesList := mustGetStringSliceFlag(cmd, "files-list")
 	filesDirGlobPair := mustGetStringSliceFlag(cmd, "files")
+	filesStdin := mustGetBoolFlag(cmd, "files-stdin")
+
+	numModes := 0
+	for _, set := range []bool{len(filesList) > 0, len(filesDirGlobPair) > 0, filesStdin} {
+		if set {
+			numModes++
+		}
+	}
+	if numModes > 1 {
+		return nil, nil, errors.New("expect only one of --files, --files-list & --files-stdin")
+	}
+
+	inputFormat := mustGetStringFlag(cmd, "input-format")
 
 	var ids []string
 	var texts []string
-	if len(filesList) > 0 {
-		if len(filesDirGlobPair) > 0 {
-			log.Fatal("expect only one of --files & --files-list")
+	addFile := func(path string, data []byte) error {
+		fileIDs, fileTexts, err := parseInputFile(inputFormat, path, data)
+		if err != nil {
+			return err
 		}
+		ids = append(ids, fileIDs...)
+		texts = append(texts, fileTexts...)
+		return nil
+	}
 
+	if filesStdin {
+		scanner := bufio.NewScanner(cmd.InOrStdin())
+		for scanner.Scan() {
+			path := strings.TrimSpace(scanner.Text())
+			if path == "" {
+				continue
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				log.Printf("skipping %v: %v", path, err)
+				continue
+			}
+			if err := addFile(path, b); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, fmt.Errorf("error reading file list from stdin: %w", err)
+		}
+	} else if len(filesList) > 0 {
 		for _, path := range filesList {
 			b, err := os.ReadFile(path)
 			if err != nil {
-				log.Fatal(err)
+				return nil, nil, err
+			}
+			if err := addFile(path, b); err != nil {
+				return nil, nil, err
 			}
-			ids = append(ids, path)
-			texts = append(texts, string(b))
 		}
 	} else if len(filesDirGlobPair) > 0 {
 		if len(filesDirGlobPair) != 2 {
-			log.Fatal("expect <root dir>,<glob> pair for --files")
+			return nil, nil, errors.New("expect <root dir>,<glob> pair for --files")
 		}
 		rootDir := filesDirGlobPair[0]
 		glob := filesDirGlobPair[1]
 
 		fileInfo, err := os.Stat(rootDir)
 		if err != nil {
-			log.Fatal(err)
+			return nil, nil, err
 		}
 		if !fileInfo.IsDir() {
-			log.Fatalf("expect directory as the first item provided to --files, got %v", rootDir)
+			return nil, nil, fmt.Errorf("expect directory as the first item provided to --files, got %v", rootDir)
 		}
 
 		visit := func(path string, d fs.DirEntry, err error) error {
@@ -397,10 +1385,11 @@ func collectFiles(cmd *cobra.Command) ([]string, []string) {
 				if matched {
 					b, err := os.ReadFile(path)
 					if err != nil {
-						log.Fatal(err)
+						return err
+					}
+					if err := addFile(path, b); err != nil {
+						return err
 					}
-					ids = append(ids, path)
-					texts = append(texts, string(b))
 				}
 			}
 			return nil
@@ -408,10 +1397,89 @@ func collectFiles(cmd *cobra.Command) ([]string, []string) {
 
 		err = filepath.WalkDir(rootDir, visit)
 		if err != nil {
-			log.Fatalf("error visiting %v: %v", rootDir, err)
+			return nil, nil, fmt.Errorf("error visiting %v: %w", rootDir, err)
 		}
 	} else {
-		panic("expect --files or --files-list")
+		panic("expect --files, --files-list or --files-stdin")
+	}
+	return ids, texts, nil
+}
+
+// charsPerToken is the approximate number of characters in a token, used by
+// estimateTokens.
+const charsPerToken = 4
+
+// estimateTokens returns an approximation of the number of tokens text is
+// split into by the model, without calling the API.
+func estimateTokens(text string) int {
+	n := utf8.RuneCountInString(text)
+	return (n + charsPerToken - 1) / charsPerToken
+}
+
+// inputFormats lists the formats that can be passed to --input-format.
+var inputFormats = []string{"txt", "jsonl", "csv"}
+
+// parseInputFile parses the data read from the file at path according to
+// format (one of inputFormats), and returns the ids and texts of the records
+// in it. Errors report the line of the record that couldn't be parsed.
+func parseInputFile(format string, path string, data []byte) ([]string, []string, error) {
+	var ids []string
+	var texts []string
+
+	switch format {
+	case "txt":
+		return []string{path}, []string{string(data)}, nil
+	case "jsonl":
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, len(data)+1)
+		for line := 1; scanner.Scan(); line++ {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			var record struct {
+				ID   any     `json:"id"`
+				Text *string `json:"text"`
+			}
+			dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+			dec.UseNumber()
+			if err := dec.Decode(&record); err != nil {
+				return nil, nil, fmt.Errorf("%v:%d: %w", path, line, err)
+			}
+			switch record.ID.(type) {
+			case string, json.Number:
+			case nil:
+				return nil, nil, fmt.Errorf("%v:%d: record has no \"id\"", path, line)
+			default:
+				return nil, nil, fmt.Errorf("%v:%d: \"id\" must be a string or a number", path, line)
+			}
+			if record.Text == nil {
+				return nil, nil, fmt.Errorf("%v:%d: record has no \"text\"", path, line)
+			}
+			ids = append(ids, fmt.Sprintf("%v", record.ID))
+			texts = append(texts, *record.Text)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, fmt.Errorf("error reading %v: %w", path, err)
+		}
+	case "csv":
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = -1
+		for {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, nil, fmt.Errorf("%v: %w", path, err)
+			}
+			if len(record) < 2 {
+				line, _ := r.FieldPos(0)
+				return nil, nil, fmt.Errorf("%v:%d: expect at least 2 columns (id and text), got %d", path, line, len(record))
+			}
+			ids = append(ids, record[0])
+			texts = append(texts, strings.Join(record[1:], " "))
+		}
+	default:
+		panic("unknown input format " + format)
 	}
-	return ids, texts
+	return ids, texts, nil
 }
@@ -0,0 +1,582 @@
+package commands
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseAttachments(t *testing.T) {
+	got, err := parseAttachments([]string{"a,x.db", " b , dir/y.db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []attachment{{alias: "a", path: "x.db"}, {alias: "b", path: "dir/y.db"}}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(attachment{})); diff != "" {
+		t.Errorf("attachments mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, bad := range []string{"a", "a,", ",x.db", "", "a b,x.db", "x; DROP TABLE t,x.db", "1a,x.db"} {
+		if _, err := parseAttachments([]string{"a,x.db", bad}); err == nil {
+			t.Errorf("%q: got no error, want error", bad)
+		}
+	}
+}
+
+func TestCheckIdentifier(t *testing.T) {
+	for _, name := range []string{"embeddings", "_t", "Docs_2"} {
+		if err := checkIdentifier("--table", name); err != nil {
+			t.Errorf("%q: got error %v, want nil", name, err)
+		}
+	}
+
+	for _, name := range []string{
+		"",
+		"2docs",
+		"my table",
+		"t; DROP TABLE embeddings",
+		"t(id)",
+		`"quoted"`,
+		"t'",
+		"main.embeddings",
+	} {
+		if err := checkIdentifier("--table", name); err == nil {
+			t.Errorf("%q: got no error, want error", name)
+		}
+	}
+}
+
+func TestCheckTableName(t *testing.T) {
+	for _, name := range []string{"embeddings", "docs.embeddings", "_a._t"} {
+		if err := checkTableName("--table", name); err != nil {
+			t.Errorf("%q: got error %v, want nil", name, err)
+		}
+	}
+
+	for _, name := range []string{
+		"",
+		".embeddings",
+		"docs.",
+		"a.b.c",
+		"docs.t; DROP TABLE embeddings",
+		"2docs.embeddings",
+	} {
+		if err := checkTableName("--table", name); err == nil {
+			t.Errorf("%q: got no error, want error", name)
+		}
+	}
+}
+
+func TestAttachDatabasesQuotedPath(t *testing.T) {
+	dir := t.TempDir()
+	otherPath := filepath.Join(dir, "it's a db'; DROP TABLE x; --.db")
+
+	other, err := sql.Open("sqlite", otherPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Exec("CREATE TABLE docs (id TEXT); INSERT INTO docs VALUES ('d1')"); err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+
+	db, err := sql.Open("sqlite", filepath.Join(dir, "main.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// ATTACH is per connection; keep a single one so the query below sees it.
+	db.SetMaxOpenConns(1)
+
+	if err := attachDatabases(db, []attachment{{alias: "other", path: otherPath}}); err != nil {
+		t.Fatal(err)
+	}
+	var id string
+	if err := db.QueryRow("SELECT id FROM other.docs").Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	if id != "d1" {
+		t.Errorf("got id %q, want d1", id)
+	}
+}
+
+func TestEmbeddingMetaRoundTrip(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "emb.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Nothing recorded yet.
+	got, err := readEmbeddingMeta(db, "embeddings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != (embeddingMeta{}) {
+		t.Errorf("got meta %+v for new DB, want none", got)
+	}
+
+	if err := writeEmbeddingMeta(db, "embeddings", embeddingMeta{model: "text-embedding-004"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeEmbeddingMeta(db, "other", embeddingMeta{model: "embedding-001"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeEmbeddingMeta(db, "other", embeddingMeta{model: "text-embedding-004", normalized: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		table string
+		want  embeddingMeta
+	}{
+		{"embeddings", embeddingMeta{model: "text-embedding-004"}},
+		{"other", embeddingMeta{model: "text-embedding-004", normalized: true}},
+		{"missing", embeddingMeta{}},
+	} {
+		got, err := readEmbeddingMeta(db, tt.table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got meta %+v, want %+v", tt.table, got, tt.want)
+		}
+	}
+}
+
+func TestEmbeddingMetaOldSchema(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "emb.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Older versions recorded only the model.
+	if _, err := db.Exec(`CREATE TABLE gemini_cli_meta (table_name TEXT PRIMARY KEY, model TEXT);
+		INSERT INTO gemini_cli_meta VALUES ('embeddings', 'embedding-001')`); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readEmbeddingMeta(db, "embeddings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (embeddingMeta{model: "embedding-001"}); got != want {
+		t.Errorf("got meta %+v, want %+v", got, want)
+	}
+
+	if err := writeEmbeddingMeta(db, "other", embeddingMeta{model: "text-embedding-004", normalized: true}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = readEmbeddingMeta(db, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (embeddingMeta{model: "text-embedding-004", normalized: true}); got != want {
+		t.Errorf("got meta %+v, want %+v", got, want)
+	}
+}
+
+func TestTitleColumnIndex(t *testing.T) {
+	cols := []string{"id", "title", "content"}
+
+	var tests = []struct {
+		spec string
+		want int
+	}{
+		{"title", 1},
+		{"content", 2},
+		{"2", 1},
+		{"3", 2},
+	}
+	for _, tt := range tests {
+		got, err := titleColumnIndex(tt.spec, cols, 0)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.spec, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.spec, got, tt.want)
+		}
+	}
+
+	// A column whose name is a number is found by name first.
+	if got, err := titleColumnIndex("1", []string{"id", "x", "1"}, 0); err != nil || got != 2 {
+		t.Errorf("got (%d, %v), want (2, nil)", got, err)
+	}
+
+	for _, bad := range []string{"id", "1", "0", "4", "missing"} {
+		if _, err := titleColumnIndex(bad, cols, 0); err == nil {
+			t.Errorf("%q: got no error, want error", bad)
+		}
+	}
+	if _, err := titleColumnIndex("title", []string{"id", "title"}, 0); err == nil {
+		t.Errorf("got no error for a query without text columns, want error")
+	}
+
+	// With the ID in another column, the first column can be the title.
+	if got, err := titleColumnIndex("title", []string{"title", "content", "id"}, 2); err != nil || got != 0 {
+		t.Errorf("got (%d, %v), want (0, nil)", got, err)
+	}
+	if _, err := titleColumnIndex("id", []string{"title", "content", "id"}, 2); err == nil {
+		t.Errorf("got no error for the ID column as the title, want error")
+	}
+}
+
+func TestQueryColumnIndex(t *testing.T) {
+	cols := []string{"content", "key"}
+	for _, tt := range []struct {
+		spec string
+		want int
+	}{
+		{"key", 1},
+		{"content", 0},
+		{"2", 1},
+	} {
+		got, err := queryColumnIndex("--id-column", tt.spec, cols)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.spec, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"0", "3", "id"} {
+		_, err := queryColumnIndex("--id-column", bad, cols)
+		if err == nil || !strings.Contains(err.Error(), "--id-column") {
+			t.Errorf("%q: got error %v, want one about --id-column", bad, err)
+		}
+	}
+}
+
+func TestSkipEmbedded(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "emb.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE embeddings (id TEXT PRIMARY KEY, embedding BLOB);
+		INSERT INTO embeddings VALUES ('p-1', x'0000803f'), ('p-2', NULL), ('p-4', x'00000040')`); err != nil {
+		t.Fatal(err)
+	}
+	embedded, err := embeddedIDs(db, "embeddings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]bool{"p-1": true, "p-4": true}, embedded); diff != "" {
+		t.Errorf("embedded ids mismatch (-want +got):\n%s", diff)
+	}
+
+	ids, texts, titles := skipEmbedded(
+		[]string{"1", "2", "3", "4"},
+		[]string{"one", "two", "three", "four"},
+		[]string{"t1", "t2", "t3", "t4"},
+		"p-", embedded)
+	if diff := cmp.Diff([]string{"2", "3"}, ids); diff != "" {
+		t.Errorf("ids mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"two", "three"}, texts); diff != "" {
+		t.Errorf("texts mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"t2", "t3"}, titles); diff != "" {
+		t.Errorf("titles mismatch (-want +got):\n%s", diff)
+	}
+
+	// Without titles, none are returned.
+	_, _, titles = skipEmbedded([]string{"1", "2"}, []string{"one", "two"}, nil, "p-", embedded)
+	if len(titles) != 0 {
+		t.Errorf("got titles %v, want none", titles)
+	}
+}
+
+func TestParseInputFile(t *testing.T) {
+	var tests = []struct {
+		format    string
+		data      string
+		wantIDs   []string
+		wantTexts []string
+	}{
+		{"txt", "line one\nline two\n", []string{"in"}, []string{"line one\nline two\n"}},
+		{"jsonl", "{\"id\": \"a\", \"text\": \"first\"}\n\n{\"id\": 12, \"text\": \"second\"}\n", []string{"a", "12"}, []string{"first", "second"}},
+		{"csv", "a,some text\nb,\"quoted, with comma\",more\n", []string{"a", "b"}, []string{"some text", "quoted, with comma more"}},
+	}
+
+	for _, tt := range tests {
+		ids, texts, err := parseInputFile(tt.format, "in", []byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: got error %v", tt.format, err)
+			continue
+		}
+		if diff := cmp.Diff(tt.wantIDs, ids); diff != "" {
+			t.Errorf("%s: ids mismatch (-want +got):\n%s", tt.format, diff)
+		}
+		if diff := cmp.Diff(tt.wantTexts, texts); diff != "" {
+			t.Errorf("%s: texts mismatch (-want +got):\n%s", tt.format, diff)
+		}
+	}
+
+	var errTests = []struct {
+		format  string
+		data    string
+		wantErr string
+	}{
+		{"jsonl", "{\"id\": \"a\", \"text\": \"ok\"}\nnot json\n", "in:2:"},
+		{"jsonl", "{\"text\": \"no id\"}\n", "in:1: record has no \"id\""},
+		{"jsonl", "{\"id\": \"a\"}\n", "in:1: record has no \"text\""},
+		{"jsonl", "{\"id\": [1], \"text\": \"x\"}\n", "in:1: \"id\" must be a string or a number"},
+		{"csv", "a,text\nb\n", "in:2: expect at least 2 columns"},
+		{"csv", "a,text\nb,\"unterminated\n", "line 2"},
+	}
+	for _, tt := range errTests {
+		_, _, err := parseInputFile(tt.format, "in", []byte(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s %q: got error %v, want it to contain %q", tt.format, tt.data, err, tt.wantErr)
+		}
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	var tests = []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"abc", 1},
+		{"abcd", 1},
+		{"abcde", 2},
+		{"héllo wörld", 3},
+	}
+	for _, tt := range tests {
+		if got := estimateTokens(tt.text); got != tt.want {
+			t.Errorf("estimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEmbedDBContinueOnError(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	// Batches with a "bad" text fail.
+	fakeBackendHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(string(body), "bad") {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error": {"code": 400, "message": "input too long", "status": "INVALID_ARGUMENT"}}`)
+			return
+		}
+		var req struct {
+			Requests []json.RawMessage `json:"requests"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Error(err)
+		}
+		embs := make([]string, len(req.Requests))
+		for i := range embs {
+			embs[i] = `{"values": [1, 2, 3]}`
+		}
+		io.WriteString(w, `{"embeddings": [`+strings.Join(embs, ", ")+`]}`)
+	}))
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.csv")
+	if err := os.WriteFile(input, []byte("id,text\n1,good\n2,bad\n3,fine\n4,great\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(dir, "out.db")
+
+	if _, err := executeCommandErr("embed", "db", dbPath, input, "--table", "t1", "--batch-size", "2"); err == nil {
+		t.Errorf("got no error without --continue-on-error")
+	}
+
+	_, err := executeCommandErr("embed", "db", dbPath, input, "--table", "t2", "--batch-size", "2", "--continue-on-error", "--no-cache")
+	if err == nil || !strings.Contains(err.Error(), "failed to embed 1 of 4 values") {
+		t.Errorf("got error %v, want one about 1 failed value", err)
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT id FROM t2 ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if diff := cmp.Diff([]string{"1", "3", "4"}, ids); diff != "" {
+		t.Errorf("ids mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestChunkText(t *testing.T) {
+	var tests = []struct {
+		text          string
+		size, overlap int
+		want          []string
+	}{
+		{"short text", 20, 5, []string{"short text"}},
+		{"abcdefghij", 4, 0, []string{"abcd", "efgh", "ij"}},
+		{"abcdefghij", 4, 2, []string{"abcd", "cdef", "efgh", "ghij"}},
+		// Chunks are cut at whitespace in their second half.
+		{"the quick brown fox jumps", 12, 0, []string{"the quick", "brown fox", "jumps"}},
+		{"the quick brown fox jumps", 12, 3, []string{"the quick", "ick brown", "own fox", "fox jumps"}},
+		{"ñandú ñandú", 6, 0, []string{"ñandú", "ñandú"}},
+	}
+	for _, tt := range tests {
+		got := chunkText(tt.text, tt.size, tt.overlap)
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%q (%d, %d): chunks mismatch (-want +got):\n%s", tt.text, tt.size, tt.overlap, diff)
+		}
+	}
+}
+
+func TestEmbedDBChunks(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var embedded []string
+	fakeBackend(t, func(path string, body string) string {
+		var req struct {
+			Requests []struct {
+				Content contentJSON `json:"content"`
+			} `json:"requests"`
+		}
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Error(err)
+		}
+		embs := make([]string, len(req.Requests))
+		for i, r := range req.Requests {
+			embedded = append(embedded, *r.Content.Parts[0].Text)
+			embs[i] = `{"values": [1, 2, 3]}`
+		}
+		return `{"embeddings": [` + strings.Join(embs, ", ") + `]}`
+	})
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.csv")
+	if err := os.WriteFile(input, []byte("id,text\nlong,one two three four five six\nshort,seven\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(dir, "out.db")
+	executeCommand(t, "embed", "db", dbPath, input, "--chunk-size", "10", "--chunk-overlap", "4", "--prefix", "doc:", "--no-cache")
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT id, parent_id FROM embeddings ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var id, parent string
+		if err := rows.Scan(&id, &parent); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, id+" <- "+parent)
+	}
+	want := []string{
+		"doc:long#0 <- doc:long",
+		"doc:long#1 <- doc:long",
+		"doc:long#2 <- doc:long",
+		"doc:long#3 <- doc:long",
+		"doc:long#4 <- doc:long",
+		"doc:short <- doc:short",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("rows mismatch (-want +got):\n%s", diff)
+	}
+	slices.Sort(embedded)
+	if diff := cmp.Diff([]string{"five six", "four five", "hree four", "one two", "seven", "two three"}, embedded); diff != "" {
+		t.Errorf("embedded texts mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, args := range [][]string{
+		{"--chunk-size", "10", "--chunk-overlap", "10"},
+		{"--chunk-overlap", "2"},
+		{"--chunk-size", "-1"},
+	} {
+		args = append([]string{"embed", "db", dbPath, input}, args...)
+		if _, err := executeCommandErr(args...); err == nil {
+			t.Errorf("%v: got no error, want error", args)
+		}
+	}
+}
+
+func TestEmbedDBJSONL(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeBackend(t, func(path string, body string) string {
+		n := strings.Count(body, `"content"`)
+		return `{"embeddings": [` + strings.Repeat(`{"values": [1, 2, 3]}, `, n-1) + `{"values": [1, 2, 3]}]}`
+	})
+
+	dir := t.TempDir()
+	file1 := filepath.Join(dir, "a.txt")
+	file2 := filepath.Join(dir, "b.txt")
+	for _, path := range []string{file1, file2} {
+		if err := os.WriteFile(path, []byte("some text"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = []struct {
+		stdin string
+		args  []string
+		want  []string
+	}{
+		{
+			"id,text\n1,one\n2,two\n",
+			[]string{"-", "--prefix", "q:", "--dimensions", "2"},
+			[]string{`{"id":"q:1","embedding":[1,2]}`, `{"id":"q:2","embedding":[1,2]}`},
+		},
+		{
+			"",
+			[]string{"--files-list", file1 + "," + file2, "--batch-size", "1"},
+			[]string{`{"id":"` + file1 + `","embedding":[1,2,3]}`, `{"id":"` + file2 + `","embedding":[1,2,3]}`},
+		},
+		{
+			"id,text\nx,one two three\n",
+			[]string{"-", "--chunk-size", "8"},
+			[]string{`{"id":"x#0","parent_id":"x","embedding":[1,2,3]}`, `{"id":"x#1","parent_id":"x","embedding":[1,2,3]}`},
+		},
+	}
+	for _, tt := range tests {
+		rootCmd.SetIn(strings.NewReader(tt.stdin))
+		out := executeCommand(t, append([]string{"embed", "db", "--jsonl"}, tt.args...)...)
+		rootCmd.SetIn(nil)
+		if diff := cmp.Diff(tt.want, strings.Split(strings.TrimSpace(out), "\n")); diff != "" {
+			t.Errorf("%v: output mismatch (-want +got):\n%s", tt.args, diff)
+		}
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"out.db", "input.csv"},
+		{"--sql", "select id, text from docs"},
+		{"-", "--store"},
+	} {
+		if _, err := executeCommandErr(append([]string{"embed", "db", "--jsonl"}, args...)...); err == nil {
+			t.Errorf("%v: got no error, want error", args)
+		}
+	}
+}
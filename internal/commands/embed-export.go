@@ -0,0 +1,219 @@
+package commands
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var embedExportCmd = &cobra.Command{
+	Use:   "export <DB path>",
+	Short: "Export the embeddings stored in a DB",
+	Long:  strings.TrimSpace(embedExportUsage),
+	Args:  cobra.ExactArgs(1),
+	PreRunE: checkEnumFlags(map[string][]string{
+		"format": {"json", "npy"},
+	}),
+	RunE: runEmbedExportCmd,
+}
+
+var embedExportUsage = `
+Export the embeddings stored in a DB by 'embed db', for use in other tools.
+
+With --format json (the default), each row of the table is emitted as a JSON
+object with its id and embedding on a separate line (JSON Lines), e.g.
+{"id":"doc1","embedding":[0.013,-0.021,...]}.
+
+With --format npy, the embeddings are emitted as a 2D array of float32 in
+NumPy's .npy format (one row per embedding), which can be loaded with
+numpy.load. The .npy format has no room for the ids; pass --ids to write
+them to a separate file, one per line in the same order as the array's rows.
+All embeddings must have the same number of dimensions for this format.
+
+The rows are read from the DB and written out one at a time, so large tables
+can be exported without holding them in memory.
+`
+
+func init() {
+	embedCmd.AddCommand(embedExportCmd)
+	embedExportCmd.Flags().String("table", "embeddings", "DB table name to export embeddings from")
+	embedExportCmd.Flags().String("format", "json", "format for the exported embeddings: json or npy")
+	embedExportCmd.Flags().StringP("output", "o", "", "write the embeddings to this file instead of stdout")
+	embedExportCmd.Flags().String("ids", "", "with --format npy, write the ids of the embeddings to this file, one per line")
+}
+
+func runEmbedExportCmd(cmd *cobra.Command, args []string) (err error) {
+	dbPath := args[0]
+
+	tableName := mustGetStringFlag(cmd, "table")
+	if err := checkIdentifier("--table", tableName); err != nil {
+		return err
+	}
+	format := mustGetStringFlag(cmd, "format")
+	idsPath := mustGetStringFlag(cmd, "ids")
+	if idsPath != "" && format != "npy" {
+		return &usageError{errors.New("--ids is only supported with --format npy")}
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return fmt.Errorf("unable to open DB at %v: %w", dbPath, err)
+	}
+	defer db.Close()
+
+	// Check that the table exists before creating any output files.
+	var numRows int
+	if err := db.QueryRow(fmt.Sprintf("SELECT count(*) FROM %s", tableName)).Scan(&numRows); err != nil {
+		return fmt.Errorf("error reading table %s: %w", tableName, err)
+	}
+
+	w := cmd.OutOrStdout()
+	if outPath := mustGetStringFlag(cmd, "output"); outPath != "" {
+		f, err := os.Create(outPath)
+		if err != nil {
+			return fmt.Errorf("error creating output file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	defer func() {
+		if flushErr := bw.Flush(); err == nil {
+			err = flushErr
+		}
+	}()
+
+	rows, err := db.Query(fmt.Sprintf("SELECT id, embedding FROM %s", tableName))
+	if err != nil {
+		return fmt.Errorf("error running SQL query: %w", err)
+	}
+	defer rows.Close()
+
+	if format == "json" {
+		return exportJSON(bw, rows)
+	}
+
+	var idsWriter *bufio.Writer
+	if idsPath != "" {
+		f, err := os.Create(idsPath)
+		if err != nil {
+			return fmt.Errorf("error creating ids file: %w", err)
+		}
+		defer f.Close()
+		idsWriter = bufio.NewWriter(f)
+		defer func() {
+			if flushErr := idsWriter.Flush(); err == nil {
+				err = flushErr
+			}
+		}()
+	}
+	return exportNPY(bw, idsWriter, rows, numRows)
+}
+
+// embeddingJSON is an embedding with its id, as written on each line of the
+// JSON output of embed export and of embed db --jsonl.
+type embeddingJSON struct {
+	ID string `json:"id"`
+	// ParentID is the id of the value a chunk is from, with --chunk-size.
+	ParentID  string    `json:"parent_id,omitempty"`
+	Embedding []float32 `json:"embedding"`
+}
+
+// exportJSON writes the id and embedding of each of rows to w as a line of
+// JSON.
+func exportJSON(w io.Writer, rows *sql.Rows) error {
+	enc := json.NewEncoder(w)
+	for rows.Next() {
+		var id string
+		var blob []byte
+		if err := rows.Scan(&id, &blob); err != nil {
+			return fmt.Errorf("error scanning DB: %w", err)
+		}
+		err := enc.Encode(embeddingJSON{ID: id, Embedding: decodeEmbedding(blob)})
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// exportNPY writes the embeddings of rows to w as a .npy array of numRows
+// rows. If idsWriter isn't nil, the id of each row is written to it on a
+// separate line.
+func exportNPY(w io.Writer, idsWriter io.Writer, rows *sql.Rows, numRows int) error {
+	dims := -1
+	n := 0
+	for rows.Next() {
+		var id string
+		var blob []byte
+		if err := rows.Scan(&id, &blob); err != nil {
+			return fmt.Errorf("error scanning DB: %w", err)
+		}
+
+		// The shape goes into the header, so it's written once the size of
+		// the first embedding is known.
+		rowDims := len(blob) / 4
+		if dims < 0 {
+			dims = rowDims
+			if _, err := w.Write(npyHeader(numRows, dims)); err != nil {
+				return err
+			}
+		} else if rowDims != dims {
+			return fmt.Errorf("embedding of id %v has %d dimensions, expected %d; all embeddings need the same size for npy", id, rowDims, dims)
+		}
+
+		// Embeddings are stored as little-endian float32 values, which is
+		// the layout of the array's data.
+		if _, err := w.Write(blob[:rowDims*4]); err != nil {
+			return err
+		}
+		if idsWriter != nil {
+			if _, err := fmt.Fprintln(idsWriter, id); err != nil {
+				return err
+			}
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if dims < 0 {
+		// An empty table; there's no embedding to take the size from.
+		_, err := w.Write(npyHeader(0, 0))
+		return err
+	}
+	if n != numRows {
+		return fmt.Errorf("table changed during export: expected %d rows, got %d", numRows, n)
+	}
+	return nil
+}
+
+// npyHeader returns the header of a version 1.0 .npy file holding a C-order
+// rows x cols array of little-endian float32. See
+// https://numpy.org/doc/stable/reference/generated/numpy.lib.format.html
+func npyHeader(rows, cols int) []byte {
+	const magic = "\x93NUMPY\x01\x00"
+
+	dict := fmt.Sprintf("{'descr': '<f4', 'fortran_order': False, 'shape': (%d, %d), }", rows, cols)
+	// The header, including the magic string, its length and a terminating
+	// newline, is padded with spaces to a multiple of 64 bytes.
+	headerLen := len(dict) + 1
+	total := len(magic) + 2 + headerLen
+	if rem := total % 64; rem != 0 {
+		headerLen += 64 - rem
+	}
+	dict += strings.Repeat(" ", headerLen-len(dict)-1) + "\n"
+
+	b := []byte(magic)
+	b = binary.LittleEndian.AppendUint16(b, uint16(headerLen))
+	return append(b, dict...)
+}
@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newExportTestDB(t *testing.T, embs map[string][]float32) *sql.DB {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "emb.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(`CREATE TABLE embeddings (id TEXT PRIMARY KEY, embedding BLOB)`); err != nil {
+		t.Fatal(err)
+	}
+	for id, emb := range embs {
+		if _, err := db.Exec(`INSERT INTO embeddings VALUES (?, ?)`, id, encodeEmbedding(emb)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestExportJSON(t *testing.T) {
+	db := newExportTestDB(t, map[string][]float32{"a": {0.5, -1}, "b": {2, 0.25}})
+	rows, err := db.Query(`SELECT id, embedding FROM embeddings ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var sb strings.Builder
+	if err := exportJSON(&sb, rows); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":"a","embedding":[0.5,-1]}
+{"id":"b","embedding":[2,0.25]}
+`
+	if got := sb.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExportNPY(t *testing.T) {
+	db := newExportTestDB(t, map[string][]float32{"a": {0.5, -1, 3}, "b": {2, 0.25, -8}})
+	rows, err := db.Query(`SELECT id, embedding FROM embeddings ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var buf bytes.Buffer
+	var ids strings.Builder
+	if err := exportNPY(&buf, &ids, rows, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	header := npyHeader(2, 3)
+	if !bytes.HasPrefix(buf.Bytes(), header) {
+		t.Fatalf("got output %q, want it to start with header %q", buf.Bytes(), header)
+	}
+	data := decodeEmbedding(buf.Bytes()[len(header):])
+	want := []float32{0.5, -1, 3, 2, 0.25, -8}
+	if len(data) != len(want) {
+		t.Fatalf("got data %v, want %v", data, want)
+	}
+	for i := range want {
+		if data[i] != want[i] {
+			t.Errorf("got data %v, want %v", data, want)
+			break
+		}
+	}
+	if got := ids.String(); got != "a\nb\n" {
+		t.Errorf("got ids %q, want %q", got, "a\nb\n")
+	}
+}
+
+func TestExportNPYMismatchedDimensions(t *testing.T) {
+	db := newExportTestDB(t, map[string][]float32{"a": {0.5, -1, 3}, "b": {2, 0.25}})
+	rows, err := db.Query(`SELECT id, embedding FROM embeddings ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var buf bytes.Buffer
+	if err := exportNPY(&buf, nil, rows, 2); err == nil {
+		t.Errorf("got no error for embeddings of different sizes, want error")
+	}
+}
+
+func TestNPYHeader(t *testing.T) {
+	for _, shape := range [][2]int{{0, 0}, {2, 3}, {100000, 768}} {
+		h := npyHeader(shape[0], shape[1])
+		if len(h)%64 != 0 {
+			t.Errorf("%v: header length %d isn't a multiple of 64", shape, len(h))
+		}
+		if !bytes.HasPrefix(h, []byte("\x93NUMPY\x01\x00")) {
+			t.Errorf("%v: header %q doesn't start with the magic string", shape, h)
+		}
+		if !bytes.HasSuffix(h, []byte("\n")) {
+			t.Errorf("%v: header %q doesn't end with a newline", shape, h)
+		}
+		if got := int(h[8]) | int(h[9])<<8; got != len(h)-10 {
+			t.Errorf("%v: header length field is %d, want %d", shape, got, len(h)-10)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var embedReindexCmd = &cobra.Command{
+	Use:   "reindex <DB path> [input file or '-']",
+	Short: "Update an embeddings table from its source, re-embedding changed values",
+	Long:  strings.TrimSpace(embedReindexUsage),
+	Args:  cobra.RangeArgs(1, 2),
+	PreRunE: preRunEmbed(map[string][]string{
+		"input-format": inputFormats,
+	}),
+	RunE: runEmbedReindexCmd,
+}
+
+var embedReindexUsage = `
+Bring a table of embeddings created with 'embed db' up to date with the
+source it was embedded from. The source is given with the same arguments and
+flags as for 'embed db' (--sql, --files*, or an input file).
+
+'embed db' stores a hash of the content of each value along with its
+embedding (in the 'content_hash' column). The hash covers the text, the
+title, the model and the task type. reindex compares the hashes of the values
+in the source with the stored ones, and only embeds the values that are new
+or whose hash changed, replacing their rows. The rows of ids that aren't in
+the source anymore are deleted; with --prefix, only the ids that start with
+the prefix are considered, so several sources can share a table.
+
+Rows stored before content hashes were recorded have no hash, so the first
+reindex of such a table embeds all of its values again (the embedding cache
+still avoids calling the model for content it embedded before).
+
+With --estimate, nothing is embedded or deleted; the estimate covers the
+values that would be embedded.
+`
+
+func init() {
+	embedCmd.AddCommand(embedReindexCmd)
+	addEmbedDBFlags(embedReindexCmd)
+}
+
+func runEmbedReindexCmd(cmd *cobra.Command, args []string) error {
+	return embedDB(cmd, args, true)
+}
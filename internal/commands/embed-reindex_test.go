@@ -0,0 +1,150 @@
+package commands
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEmbedReindex(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	// embedded lists the texts sent to the model.
+	var embedded []string
+	fakeBackend(t, func(path string, body string) string {
+		if !strings.HasSuffix(path, ":batchEmbedContents") {
+			t.Errorf("unexpected request %s: %s", path, body)
+			return `{}`
+		}
+		var req struct {
+			Requests []struct {
+				Content contentJSON `json:"content"`
+			} `json:"requests"`
+		}
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatal(err)
+		}
+		var embs []string
+		for _, r := range req.Requests {
+			text := *r.Content.Parts[0].Text
+			embedded = append(embedded, text)
+			embs = append(embs, `{"values": [1, 2]}`)
+		}
+		return `{"embeddings": [` + strings.Join(embs, ", ") + `]}`
+	})
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.csv")
+	dbPath := filepath.Join(dir, "out.db")
+	writeInput := func(data string) {
+		if err := os.WriteFile(input, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readIDs := func() []string {
+		db, err := sql.Open("sqlite", dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		rows, err := db.Query("SELECT id FROM embeddings ORDER BY id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+		return ids
+	}
+
+	writeInput("id,text\n1,apple\n2,banana\n3,cherry\n")
+	executeCommand(t, "embed", "db", dbPath, input, "--no-cache")
+	if diff := cmp.Diff([]string{"apple", "banana", "cherry"}, embedded); diff != "" {
+		t.Errorf("embedded texts mismatch (-want +got):\n%s", diff)
+	}
+
+	// 2 changed, 3 was removed and 4 is new.
+	embedded = nil
+	writeInput("id,text\n1,apple\n2,blueberry\n4,date\n")
+	executeCommand(t, "embed", "reindex", dbPath, input, "--no-cache")
+	if diff := cmp.Diff([]string{"blueberry", "date"}, embedded); diff != "" {
+		t.Errorf("reindexed texts mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"1", "2", "4"}, readIDs()); diff != "" {
+		t.Errorf("ids mismatch (-want +got):\n%s", diff)
+	}
+
+	// Nothing changed.
+	embedded = nil
+	executeCommand(t, "embed", "reindex", dbPath, input, "--no-cache")
+	if len(embedded) > 0 {
+		t.Errorf("got texts %v embedded again, want none", embedded)
+	}
+
+	// Only the ids with the prefix belong to the source.
+	writeInput("id,text\nx,extra\n")
+	executeCommand(t, "embed", "reindex", dbPath, input, "--no-cache", "--prefix", "other:")
+	if diff := cmp.Diff([]string{"1", "2", "4", "other:x"}, readIDs()); diff != "" {
+		t.Errorf("ids with prefix mismatch (-want +got):\n%s", diff)
+	}
+
+	// The columns of a row are joined in a fixed order, so an unchanged row
+	// with several text columns isn't embedded again.
+	embedded = nil
+	columnsDB := filepath.Join(dir, "columns.db")
+	writeInput("id,title,body\n1,Apples,red fruit\n2,Bananas,yellow fruit\n")
+	executeCommand(t, "embed", "db", columnsDB, input, "--no-cache")
+	if diff := cmp.Diff([]string{"red fruit Apples", "yellow fruit Bananas"}, embedded); diff != "" {
+		t.Errorf("embedded texts of columns mismatch (-want +got):\n%s", diff)
+	}
+	embedded = nil
+	for range 10 {
+		executeCommand(t, "embed", "reindex", columnsDB, input, "--no-cache")
+	}
+	if len(embedded) > 0 {
+		t.Errorf("got texts %v embedded again, want none", embedded)
+	}
+}
+
+func TestEmbedReindexOldTable(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	numEmbedded := 0
+	fakeBackend(t, func(path string, body string) string {
+		numEmbedded += strings.Count(body, `"content"`)
+		return `{"embeddings": [{"values": [1, 2]}]}`
+	})
+
+	// A table from before content hashes were stored.
+	dbPath := filepath.Join(t.TempDir(), "out.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE embeddings (id TEXT PRIMARY KEY, embedding BLOB)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO embeddings VALUES ('1', ?)`, encodeEmbedding([]float32{1, 2})); err != nil {
+		t.Fatal(err)
+	}
+
+	input := filepath.Join(t.TempDir(), "input.csv")
+	if err := os.WriteFile(input, []byte("id,text\n1,apple\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	executeCommand(t, "embed", "reindex", dbPath, input, "--no-cache")
+	executeCommand(t, "embed", "reindex", dbPath, input, "--no-cache")
+	if numEmbedded != 1 {
+		t.Errorf("got %d values embedded, want 1: once for the missing hash, and not again", numEmbedded)
+	}
+}
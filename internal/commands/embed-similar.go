@@ -1,13 +1,13 @@
 package commands
 
 import (
-	"context"
+	"cmp"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"os"
 	"slices"
 	"strings"
 
@@ -21,30 +21,94 @@ var embedSimilarCmd = &cobra.Command{
 	Short: "Find items in the DB similar to the given content",
 	Long:  strings.TrimSpace(embedSimilarUsage),
 	Args:  cobra.ExactArgs(2),
-	Run:   runEmbedSimilarCmd,
+	PreRunE: preRunEmbed(map[string][]string{
+		"metric": similarityMetricNames,
+	}),
+	RunE: runEmbedSimilarCmd,
 }
 
 var embedSimilarUsage = `
 Use vector embeddings to calculate similarity.
 
 The given content (argument or from standard input if '-' is passed) is embedded
-and compared to the items stored in the DB's 'embeddings' table. The most
-similar items are reported. This command expects the rows in the DB to have
-at least 'id' and 'embeddings' columns. By default, the 'id' of similar items
+and compared to the items stored in the DB's 'embeddings' table (or the one
+named with --table). The most similar items are reported. This command expects
+the rows of the table to have at least 'id' and 'embedding' columns. By default, the 'id' of similar items
 is reported along with a similarity score; this can be controlled with the
 '--show' flag.
 
 The items are reported in JSONLines format (each entry is encoded as a JSON
 object and printed on a separate line).
+
+The content has to be embedded with the same model as the items in the DB for
+the similarity scores to be meaningful. 'embed db' records the model it used
+in the DB; unless --model is set explicitly, that model is used here as well.
+If --model is set to a different model, a warning is printed.
+
+The 'embeddings' table only has the ids of the items, not the content they were
+calculated from. To see it in the results, name the table holding the content
+with --source-table and its column with --text-column; the table is expected
+to have an 'id' column matching the ids of the embeddings. Each result then has
+a "snippet" with the start of the item's content.
+
+The --attach flag attaches other DB files, as <alias>,<db path> pairs; it can
+be repeated to attach several DBs. --table and --source-table can then name a
+table of an attached DB as <alias>.<table>, so that the embeddings and their
+content can be kept in different DBs, e.g.:
+
+  gemini-cli embed similar queries.db "some question" --attach docs,docs.db \
+    --table docs.embeddings --source-table docs.documents --text-column body
+
+--metric selects how the items are compared with the content: "cosine" (the
+default) is the cosine similarity of their embeddings, "dot" their dot product,
+and "euclidean" the Euclidean distance between them. Items are reported from
+the most similar: from the highest score for cosine and dot, and from the
+lowest for euclidean, whose score is a distance.
+
+If the embeddings were stored normalized ('embed db --normalize'), the
+content's embedding is normalized as well, and the cosine similarity is
+calculated with a dot product, skipping the normalization.
 `
 
 func init() {
 	embedCmd.AddCommand(embedSimilarCmd)
 	embedSimilarCmd.Flags().Int("topk", 5, "top K: how many most similar entries to return")
+	embedSimilarCmd.Flags().String("table", "embeddings", "DB table with the embeddings to compare with; may be <alias>.<table> for an attached DB")
+	embedSimilarCmd.Flags().StringArray("attach", nil, "additional DB to attach - specify <alias>,<filename> pair; can be repeated")
 	embedSimilarCmd.Flags().StringSlice("show", []string{"id", "score"}, "the columns to emit for the most similar DB entries")
+	embedSimilarCmd.Flags().String("source-table", "", "table in the DB holding the content the embeddings were calculated from; needs --text-column")
+	embedSimilarCmd.Flags().String("text-column", "", "column of --source-table with the content to show a snippet of in the results")
+	embedSimilarCmd.MarkFlagsRequiredTogether("source-table", "text-column")
+	embedSimilarCmd.Flags().String("metric", "cosine", `how to compare embeddings: "cosine" (cosine similarity), "dot" (dot product) or "euclidean" (distance)`)
+}
+
+// similarityMetric is a way of comparing embeddings for embed similar.
+type similarityMetric struct {
+	// score compares two embeddings of the same size.
+	score func(a, b []float32) float32
+	// ascending is set if lower scores are more similar, as for distances.
+	ascending bool
+}
+
+// similarityMetrics maps the values of --metric to their similarityMetric.
+var similarityMetrics = map[string]similarityMetric{
+	"cosine":    {score: cosineSimilarity},
+	"dot":       {score: dotProduct},
+	"euclidean": {score: euclideanDistance, ascending: true},
 }
 
-func runEmbedSimilarCmd(cmd *cobra.Command, args []string) {
+// similarityMetricNames lists the values of --metric: the keys of
+// similarityMetrics, sorted.
+var similarityMetricNames = func() []string {
+	var names []string
+	for name := range similarityMetrics {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}()
+
+func runEmbedSimilarCmd(cmd *cobra.Command, args []string) error {
 	dbPath := args[0]
 
 	// Read content from argument or stdin
@@ -52,50 +116,117 @@ func runEmbedSimilarCmd(cmd *cobra.Command, args []string) {
 	if content == "-" {
 		b, err := io.ReadAll(cmd.InOrStdin())
 		if err != nil {
-			log.Fatal("error reading content from stdin:", err)
+			return fmt.Errorf("error reading content from stdin: %w", err)
 		}
 		content = string(b)
 	}
 
+	taskType, err := embeddingTaskType(cmd, genai.TaskTypeRetrievalQuery)
+	if err != nil {
+		return err
+	}
+
+	metricName := mustGetStringFlag(cmd, "metric")
+	metric := similarityMetrics[metricName]
+
+	tableName := mustGetStringFlag(cmd, "table")
+	if err := checkTableName("--table", tableName); err != nil {
+		return err
+	}
+	attachments, err := parseAttachments(mustGetStringArrayFlag(cmd, "attach"))
+	if err != nil {
+		return err
+	}
+
+	sourceTable := mustGetStringFlag(cmd, "source-table")
+	textColumn := mustGetStringFlag(cmd, "text-column")
+	if sourceTable != "" {
+		if err := checkTableName("--source-table", sourceTable); err != nil {
+			return err
+		}
+		if err := checkIdentifier("--text-column", textColumn); err != nil {
+			return err
+		}
+	}
+
+	// Open the DB first to find out which model its embeddings were calculated
+	// with.
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return fmt.Errorf("unable to open DB at %v: %w", dbPath, err)
+	}
+	defer db.Close()
+	// Attached DBs are only visible on the connection that attached them.
+	db.SetMaxOpenConns(1)
+	if err := attachDatabases(db, attachments); err != nil {
+		return err
+	}
+
+	modelName := mustGetStringFlag(cmd, "model")
+	dbMeta, err := readEmbeddingMeta(db, tableName)
+	if err != nil {
+		return err
+	}
+	dbModelName := dbMeta.model
+	if dbModelName != "" && dbModelName != modelName {
+		if cmd.Flags().Changed("model") {
+			log.Printf("WARNING: the embeddings in %v were calculated with model %v, but --model is %v; similarity scores won't be meaningful", dbPath, dbModelName, modelName)
+		} else {
+			modelName = dbModelName
+		}
+	}
+
+	dims, err := embeddingDimensions(cmd, modelName)
+	if err != nil {
+		return err
+	}
+
 	// Calculate the content's embedding vector
-	ctx := context.Background()
-	client, err := newGenaiClient(ctx, cmd)
+	ctx, stop := newCommandContext()
+	defer stop()
+	ctx, cancel := withRequestTimeout(ctx, cmd)
+	defer cancel()
+	client, err := newClient(ctx, cmd)
 	if err != nil {
-		log.Fatal()
+		return err
 	}
+	defer client.Close()
 
-	model := client.EmbeddingModel(mustGetStringFlag(cmd, "model"))
+	model := client.EmbeddingModel(modelName)
+	model.TaskType = taskType
 	res, err := model.EmbedContent(ctx, genai.Text(content))
 	if err != nil {
-		log.Fatal("error embedding content:", err)
+		return fmt.Errorf("error embedding content: %w", requestError(ctx, cmd, err))
 	}
 
 	var contentEmb []float32
 	if emb := res.Embedding; emb != nil {
-		contentEmb = emb.Values
+		contentEmb = truncateEmbedding(emb.Values, dims)
 	} else {
-		log.Fatal("got no embedding back from model")
+		return errors.New("got no embedding back from model")
 	}
-
-	// Open the DB and read items and their embeddings from the 'embeddings'
-	// table. For each item, calculate its cosine similarity to the content's
-	// embedding.
-	db, err := sql.Open("sqlite", dbPath)
-	if err != nil {
-		log.Fatalf("unable to open DB at %v", dbPath)
+	// If the stored embeddings are unit vectors, the content's embedding is
+	// compared as one too; this makes their dot product the cosine
+	// similarity.
+	if dbMeta.normalized {
+		contentEmb = normalizeEmbedding(contentEmb)
+		if metricName == "cosine" {
+			metric.score = dotProduct
+		}
 	}
-	defer db.Close()
 
-	query := `SELECT * FROM embeddings`
+	// Read items and their embeddings from the table. For each item,
+	// calculate its score against the content's embedding.
+	query := fmt.Sprintf(`SELECT * FROM %s`, tableName)
 	rows, err := db.Query(query)
 	if err != nil {
-		log.Fatal("error running SQL query:", err)
+		return fmt.Errorf("error running SQL query: %w", err)
 	}
 	defer rows.Close()
 
 	columnNames, err := rows.Columns()
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// After scanning, dbEntries will list all DB rows with their data in cols
@@ -106,8 +237,16 @@ func runEmbedSimilarCmd(cmd *cobra.Command, args []string) {
 	}
 	var dbEntries []Entry
 
+	// Embeddings of a different size than the content's (e.g. calculated with
+	// another model) can't be compared with it; they're skipped.
+	numMismatched := 0
+	mismatchedDims := 0
+
 	for rows.Next() {
-		columns := scanRowIntoSlice(rows)
+		columns, err := scanRowIntoSlice(rows)
+		if err != nil {
+			return err
+		}
 
 		entryCols := make(map[string]any)
 		for i, col := range columnNames {
@@ -115,16 +254,33 @@ func runEmbedSimilarCmd(cmd *cobra.Command, args []string) {
 		}
 
 		entryEmb := decodeEmbedding(entryCols["embedding"].([]byte))
-		score := cosineSimilarity(entryEmb, contentEmb)
+		if len(entryEmb) != len(contentEmb) {
+			numMismatched++
+			mismatchedDims = len(entryEmb)
+			continue
+		}
+		score := metric.score(entryEmb, contentEmb)
 
 		dbEntries = append(dbEntries, Entry{cols: entryCols, score: score})
 	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error scanning DB: %w", err)
+	}
+	if numMismatched > 0 {
+		msg := fmt.Sprintf("%d items have embeddings of a different size than the content's (%d dimensions, e.g. %d)", numMismatched, len(contentEmb), mismatchedDims)
+		if len(dbEntries) == 0 {
+			return fmt.Errorf("%s; no items to compare with. If the DB was embedded with --dimensions, pass the same value here", msg)
+		}
+		log.Printf("WARNING: skipping %s", msg)
+	}
 
-	// Sort by descending similarity score.
-	slices.SortFunc(dbEntries, func(a, b Entry) int {
-		// The similarity scores are in the range [0, 1], so scale them to get
-		// integers for comparison. Negate the result to get descending similarity.
-		return -int(100.0 * (a.score - b.score))
+	// Sort from the most similar entry: by descending similarity score, or
+	// by ascending distance.
+	slices.SortStableFunc(dbEntries, func(a, b Entry) int {
+		if metric.ascending {
+			return cmp.Compare(a.score, b.score)
+		}
+		return cmp.Compare(b.score, a.score)
 	})
 
 	showList := mustGetStringSliceFlag(cmd, "show")
@@ -136,17 +292,56 @@ func runEmbedSimilarCmd(cmd *cobra.Command, args []string) {
 			} else {
 				entry, ok := dbEntries[i].cols[col]
 				if !ok {
-					log.Fatalf("no column '%v' to show", col)
+					return fmt.Errorf("no column '%v' to show", col)
 				}
 				display[col] = fmt.Sprintf("%v", entry)
 			}
 		}
+		if sourceTable != "" {
+			text, found, err := lookupText(db, sourceTable, textColumn, dbEntries[i].cols["id"])
+			if err != nil {
+				return err
+			}
+			if found {
+				display["snippet"] = snippet(text, snippetLength)
+			}
+		}
 
-		enc := json.NewEncoder(os.Stdout)
+		enc := json.NewEncoder(cmd.OutOrStdout())
 		if err := enc.Encode(display); err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
+	return nil
+}
+
+// snippetLength is the maximal number of characters of content shown in the
+// snippets of results.
+const snippetLength = 200
+
+// lookupText finds the value of column for the row with the given id in table.
+// found is false if there's no such row.
+func lookupText(db *sql.DB, table string, column string, id any) (text string, found bool, err error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE id = ?", column, table)
+	var value sql.NullString
+	err = db.QueryRow(query, id).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, fmt.Errorf("error reading %s.%s: %w", table, column, err)
+	}
+	return value.String, true, nil
+}
+
+// snippet returns the first n characters of text, with its whitespace
+// collapsed so that it fits on a line, and "..." appended if it was cut.
+func snippet(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n]) + "..."
 }
 
 // cosineSimilarity calculates cosine similarity (magnitude-adjusted dot
@@ -164,3 +359,32 @@ func cosineSimilarity(a, b []float32) float32 {
 	}
 	return dotProduct / (math32.Sqrt(aMag) * math32.Sqrt(bMag))
 }
+
+// dotProduct calculates the dot product of two vectors that must be of the
+// same size; for unit vectors, it's their cosine similarity.
+func dotProduct(a, b []float32) float32 {
+	if len(a) != len(b) {
+		panic("different lengths")
+	}
+
+	var dotProduct float32
+	for i := 0; i < len(a); i++ {
+		dotProduct += a[i] * b[i]
+	}
+	return dotProduct
+}
+
+// euclideanDistance calculates the Euclidean distance between two vectors
+// that must be of the same size.
+func euclideanDistance(a, b []float32) float32 {
+	if len(a) != len(b) {
+		panic("different lengths")
+	}
+
+	var sum float32
+	for i := 0; i < len(a); i++ {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return math32.Sqrt(sum)
+}
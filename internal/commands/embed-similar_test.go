@@ -0,0 +1,190 @@
+package commands
+
+import (
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chewxy/math32"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLookupText(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "docs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE docs (id TEXT PRIMARY KEY, content TEXT);
+		INSERT INTO docs VALUES ('1', 'story about dogs'), ('2', NULL)`); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		id        string
+		wantText  string
+		wantFound bool
+	}{
+		{"1", "story about dogs", true},
+		{"2", "", true},
+		{"3", "", false},
+	} {
+		text, found, err := lookupText(db, "docs", "content", tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text != tt.wantText || found != tt.wantFound {
+			t.Errorf("id %s: got (%q, %v), want (%q, %v)", tt.id, text, found, tt.wantText, tt.wantFound)
+		}
+	}
+
+	if _, _, err := lookupText(db, "docs", "nosuchcolumn", "1"); err == nil {
+		t.Errorf("got no error for missing column, want error")
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	var tests = []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"short text", 20, "short text"},
+		{"  several\n lines\tof   text ", 25, "several lines of text"},
+		{"cats are very fluffy", 8, "cats are..."},
+		{"こんにちは世界", 5, "こんにちは..."},
+		{strings.Repeat("a", 10), 10, strings.Repeat("a", 10)},
+	}
+
+	for _, tt := range tests {
+		if got := snippet(tt.text, tt.n); got != tt.want {
+			t.Errorf("snippet(%q, %d) = %q, want %q", tt.text, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestSimilarityMetrics(t *testing.T) {
+	var tests = []struct {
+		a, b                   []float32
+		cosine, dot, euclidean float32
+	}{
+		// |a| = 3, |b| = sqrt(5), a - b = (-1, 2, 1).
+		{[]float32{1, 2, 2}, []float32{2, 0, 1}, 4 / (3 * math32.Sqrt(5)), 4, math32.Sqrt(6)},
+		{[]float32{1, 0}, []float32{0, 2}, 0, 0, math32.Sqrt(5)},
+		{[]float32{1, 1}, []float32{1, 1}, 1, 2, 0},
+		{[]float32{1, 0}, []float32{-2, 0}, -1, -2, 3},
+	}
+	for _, tt := range tests {
+		for name, want := range map[string]float32{"cosine": tt.cosine, "dot": tt.dot, "euclidean": tt.euclidean} {
+			if got := similarityMetrics[name].score(tt.a, tt.b); math32.Abs(got-want) > 1e-6 {
+				t.Errorf("%s(%v, %v) = %v, want %v", name, tt.a, tt.b, got, want)
+			}
+		}
+	}
+}
+
+func TestEmbedSimilarMetric(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeBackend(t, func(path string, body string) string {
+		if !strings.HasSuffix(path, ":embedContent") {
+			t.Errorf("unexpected request %s: %s", path, body)
+			return `{}`
+		}
+		return `{"embedding": {"values": [1, 0.1]}}`
+	})
+
+	dbPath := filepath.Join(t.TempDir(), "emb.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE embeddings (id TEXT PRIMARY KEY, embedding BLOB)`); err != nil {
+		t.Fatal(err)
+	}
+	for id, emb := range map[string][]float32{"a": {1, 0}, "b": {0, 1}, "c": {3, 3}} {
+		if _, err := db.Exec(`INSERT INTO embeddings VALUES (?, ?)`, id, encodeEmbedding(emb)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for metric, want := range map[string][]string{
+		"cosine":    {"a", "c", "b"},
+		"dot":       {"c", "a", "b"},
+		"euclidean": {"a", "b", "c"},
+	} {
+		out := executeCommand(t, "embed", "similar", dbPath, "query", "--metric", metric, "--show", "id")
+		var got []string
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			var entry map[string]string
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, entry["id"])
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%s: order mismatch (-want +got):\n%s", metric, diff)
+		}
+	}
+
+	if _, err := executeCommandErr("embed", "similar", dbPath, "query", "--metric", "manhattan"); err == nil || !strings.Contains(err.Error(), "invalid --metric") {
+		t.Errorf("got error %v, want invalid --metric", err)
+	}
+}
+
+func TestEmbedSimilarAttached(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeBackend(t, func(path string, body string) string {
+		return `{"embedding": {"values": [1, 0]}}`
+	})
+
+	// The embeddings and their content are in an attached DB; the DB given as
+	// the argument is empty.
+	dir := t.TempDir()
+	docsPath := filepath.Join(dir, "docs.db")
+	docs, err := sql.Open("sqlite", docsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := docs.Exec(`CREATE TABLE vectors (id TEXT PRIMARY KEY, embedding BLOB);
+		CREATE TABLE documents (id TEXT PRIMARY KEY, body TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	for id, emb := range map[string][]float32{"near": {1, 0.1}, "far": {0, 1}} {
+		if _, err := docs.Exec(`INSERT INTO vectors VALUES (?, ?)`, id, encodeEmbedding(emb)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := docs.Exec(`INSERT INTO documents VALUES (?, ?)`, id, "the "+id+" document"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writeEmbeddingMeta(docs, "vectors", embeddingMeta{model: "text-embedding-004"}); err != nil {
+		t.Fatal(err)
+	}
+	docs.Close()
+
+	mainPath := filepath.Join(dir, "main.db")
+	out := executeCommand(t, "embed", "similar", mainPath, "query", "--attach", "d,"+docsPath,
+		"--table", "d.vectors", "--source-table", "d.documents", "--text-column", "body", "--topk", "1")
+	var got struct{ ID, Snippet string }
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := struct{ ID, Snippet string }{"near", "the near document"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, args := range [][]string{
+		{"--table", "d.vectors; DROP TABLE vectors"},
+		{"--attach", "d;x,other.db"},
+	} {
+		args = append([]string{"embed", "similar", mainPath, "query"}, args...)
+		if _, err := executeCommandErr(args...); err == nil {
+			t.Errorf("%v: got no error, want error", args)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var embedStatsCmd = &cobra.Command{
+	Use:   "stats <DB path>",
+	Short: "Report statistics of an embeddings table",
+	Long:  strings.TrimSpace(embedStatsUsage),
+	Args:  cobra.ExactArgs(1),
+	RunE:  runEmbedStatsCmd,
+}
+
+var embedStatsUsage = `
+Report statistics of a table of embeddings stored by 'embed db': the number of
+rows, the number of dimensions of the embeddings (with the number of rows for
+each, if they differ), the number of rows without an embedding, and the model
+that calculated the embeddings, if it's recorded in the DB.
+
+This is useful to check that 'embed db' completed, and to find embeddings of
+different sizes (e.g. from different models or --dimensions values), which
+'embed similar' can't compare with each other.
+`
+
+func init() {
+	embedCmd.AddCommand(embedStatsCmd)
+	embedStatsCmd.Flags().String("table", "embeddings", "DB table name to report statistics of")
+}
+
+// embeddingsTableStats are the statistics of a table of embeddings.
+type embeddingsTableStats struct {
+	numRows int
+
+	// numNull is the number of rows without an embedding.
+	numNull int
+
+	// dims has the number of dimensions of the embeddings in the table, in
+	// ascending order, and dimsRows the number of rows with each.
+	dims     []int
+	dimsRows []int
+
+	meta embeddingMeta
+}
+
+func runEmbedStatsCmd(cmd *cobra.Command, args []string) error {
+	dbPath := args[0]
+
+	tableName := mustGetStringFlag(cmd, "table")
+	if err := checkIdentifier("--table", tableName); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return fmt.Errorf("unable to open DB at %v: %w", dbPath, err)
+	}
+	defer db.Close()
+
+	stats, err := embeddingsStats(db, tableName)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Table:\t%s\n", tableName)
+	fmt.Fprintf(w, "Rows:\t%d\n", stats.numRows)
+	fmt.Fprintf(w, "Null embeddings:\t%d\n", stats.numNull)
+	switch len(stats.dims) {
+	case 0:
+		fmt.Fprintf(w, "Dimensions:\tnone\n")
+	case 1:
+		fmt.Fprintf(w, "Dimensions:\t%d\n", stats.dims[0])
+	default:
+		var parts []string
+		for i, d := range stats.dims {
+			parts = append(parts, fmt.Sprintf("%d (%d rows)", d, stats.dimsRows[i]))
+		}
+		fmt.Fprintf(w, "Dimensions:\t%s; mixed sizes can't be compared\n", strings.Join(parts, ", "))
+	}
+	if stats.meta.model != "" {
+		fmt.Fprintf(w, "Model:\t%s\n", stats.meta.model)
+		fmt.Fprintf(w, "Normalized:\t%v\n", stats.meta.normalized)
+	} else {
+		fmt.Fprintf(w, "Model:\tnot recorded\n")
+	}
+	return w.Flush()
+}
+
+// embeddingsStats calculates the statistics of the embeddings table tableName
+// in db.
+func embeddingsStats(db *sql.DB, tableName string) (embeddingsTableStats, error) {
+	var stats embeddingsTableStats
+
+	var exists int
+	err := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, tableName).Scan(&exists)
+	if err != nil {
+		return stats, fmt.Errorf("error reading DB schema: %w", err)
+	}
+	if exists == 0 {
+		return stats, fmt.Errorf("no table %s in DB", tableName)
+	}
+
+	query := fmt.Sprintf(`SELECT count(*), count(*) - count(embedding) FROM %s`, tableName)
+	if err := db.QueryRow(query).Scan(&stats.numRows, &stats.numNull); err != nil {
+		return stats, fmt.Errorf("error reading table %s: %w", tableName, err)
+	}
+
+	// Embeddings are stored as float32 values of 4 bytes each.
+	query = fmt.Sprintf(`SELECT length(embedding) / 4 AS dims, count(*) FROM %s WHERE embedding IS NOT NULL GROUP BY dims ORDER BY dims`, tableName)
+	rows, err := db.Query(query)
+	if err != nil {
+		return stats, fmt.Errorf("error reading table %s: %w", tableName, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var dims, n int
+		if err := rows.Scan(&dims, &n); err != nil {
+			return stats, err
+		}
+		stats.dims = append(stats.dims, dims)
+		stats.dimsRows = append(stats.dimsRows, n)
+	}
+	if err := rows.Err(); err != nil {
+		return stats, fmt.Errorf("error reading table %s: %w", tableName, err)
+	}
+
+	stats.meta, err = readEmbeddingMeta(db, tableName)
+	return stats, err
+}
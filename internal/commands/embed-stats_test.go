@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEmbeddingsStats(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "emb.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE embeddings (id TEXT PRIMARY KEY, embedding BLOB)`); err != nil {
+		t.Fatal(err)
+	}
+	for id, emb := range map[string][]float32{
+		"a": {1, 2, 3},
+		"b": {4, 5, 6},
+		"c": {1, 2},
+		"d": nil,
+	} {
+		var blob any
+		if emb != nil {
+			blob = encodeEmbedding(emb)
+		}
+		if _, err := db.Exec(`INSERT INTO embeddings VALUES (?, ?)`, id, blob); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writeEmbeddingMeta(db, "embeddings", embeddingMeta{model: "text-embedding-004"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := embeddingsStats(db, "embeddings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := embeddingsTableStats{
+		numRows:  4,
+		numNull:  1,
+		dims:     []int{2, 3},
+		dimsRows: []int{1, 2},
+		meta:     embeddingMeta{model: "text-embedding-004"},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(embeddingsTableStats{}, embeddingMeta{})); diff != "" {
+		t.Errorf("stats mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := embeddingsStats(db, "missing"); err == nil {
+		t.Errorf("got no error for a missing table, want error")
+	}
+
+	// Compare the output without the alignment of the values.
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(executeCommand(t, "embed", "stats", dbPath)), "\n") {
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+	wantLines := []string{
+		"Table: embeddings",
+		"Rows: 4",
+		"Null embeddings: 1",
+		"Dimensions: 2 (1 rows), 3 (2 rows); mixed sizes can't be compared",
+		"Model: text-embedding-004",
+		"Normalized: false",
+	}
+	if diff := cmp.Diff(wantLines, lines); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -1,6 +1,13 @@
 package commands
 
 import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/chewxy/math32"
+	"github.com/google/generative-ai-go/genai"
 	_ "modernc.org/sqlite"
 
 	"github.com/spf13/cobra"
@@ -16,7 +23,112 @@ var embedCmd = &cobra.Command{
 	// Therefore we don't define a Run: function for it.
 }
 
+// taskTypes lists the embedding task types that can be passed to --task-type.
+var taskTypes = []genai.TaskType{
+	genai.TaskTypeRetrievalQuery,
+	genai.TaskTypeRetrievalDocument,
+	genai.TaskTypeSemanticSimilarity,
+	genai.TaskTypeClassification,
+	genai.TaskTypeClustering,
+	genai.TaskTypeQuestionAnswering,
+	genai.TaskTypeFactVerification,
+}
+
 func init() {
 	rootCmd.AddCommand(embedCmd)
 	embedCmd.PersistentFlags().StringP("model", "m", "text-embedding-004", "name of embedding model to use")
+
+	embedCmd.PersistentFlags().String("task-type", "", fmt.Sprintf(
+		"task type the embeddings are used for: %s; 'embed db' defaults to RETRIEVAL_DOCUMENT, 'embed similar' to RETRIEVAL_QUERY and 'embed compare' to SEMANTIC_SIMILARITY",
+		strings.Join(taskTypeNames(), ", ")))
+	embedCmd.PersistentFlags().Int("dimensions", 0, "reduce the embeddings to this number of dimensions, to save storage; by default embeddings have the model's full size")
+}
+
+// fixedSizeEmbeddingModels lists the embedding models whose embeddings can't
+// be reduced in size with --dimensions.
+var fixedSizeEmbeddingModels = []string{"embedding-001", "embedding-gecko-001"}
+
+// embeddingDimensions returns the number of dimensions given with
+// --dimensions, or 0 if the flag isn't set. It warns if modelName doesn't
+// support reduced dimensions.
+func embeddingDimensions(cmd *cobra.Command, modelName string) (int, error) {
+	if !cmd.Flags().Changed("dimensions") {
+		return 0, nil
+	}
+	dims := mustGetIntFlag(cmd, "dimensions")
+	if dims < 1 {
+		return 0, &usageError{fmt.Errorf("--dimensions must be positive, got %v", dims)}
+	}
+	if slices.Contains(fixedSizeEmbeddingModels, strings.TrimPrefix(modelName, "models/")) {
+		log.Printf("WARNING: model %s doesn't support reduced dimensions; truncated embeddings from it won't be meaningful", modelName)
+	}
+	return dims, nil
+}
+
+// truncateEmbedding reduces emb to its first dims values; dims of 0 leaves
+// it as it is.
+//
+// The API can return reduced embeddings itself (output_dimensionality), but
+// the genai package doesn't expose that yet. The models that support it
+// reduce an embedding by keeping its leading values, so truncating the full
+// embedding has the same effect.
+func truncateEmbedding(emb []float32, dims int) []float32 {
+	if dims == 0 || dims >= len(emb) {
+		return emb
+	}
+	return emb[:dims]
+}
+
+// normalizeEmbedding returns a copy of emb scaled to a unit vector (with an
+// L2 norm of 1). An all-zero embedding is returned as it is.
+func normalizeEmbedding(emb []float32) []float32 {
+	var sum float32
+	for _, v := range emb {
+		sum += v * v
+	}
+	norm := math32.Sqrt(sum)
+	if norm == 0 {
+		return emb
+	}
+	normalized := make([]float32, len(emb))
+	for i, v := range emb {
+		normalized[i] = v / norm
+	}
+	return normalized
+}
+
+// embeddingTaskType returns the task type given with --task-type, or
+// defaultType if the flag is empty.
+func embeddingTaskType(cmd *cobra.Command, defaultType genai.TaskType) (genai.TaskType, error) {
+	name := strings.ToUpper(strings.TrimSpace(mustGetStringFlag(cmd, "task-type")))
+	if name == "" {
+		return defaultType, nil
+	}
+	for _, tt := range taskTypes {
+		if enumName(tt, "TaskType") == name {
+			return tt, nil
+		}
+	}
+	return genai.TaskTypeUnspecified, &usageError{fmt.Errorf("invalid --task-type value %q; expect one of: %s", name, strings.Join(taskTypeNames(), ", "))}
+}
+
+// taskTypeNames returns the names of taskTypes, as passed to --task-type.
+func taskTypeNames() []string {
+	var names []string
+	for _, tt := range taskTypes {
+		names = append(names, enumName(tt, "TaskType"))
+	}
+	return names
+}
+
+// preRunEmbed returns the PreRunE of an embed subcommand: it checks the
+// --task-type flag, and the flags in enums as checkEnumFlags does.
+func preRunEmbed(enums map[string][]string) func(cmd *cobra.Command, args []string) error {
+	checkEnums := checkEnumFlags(enums)
+	return func(cmd *cobra.Command, args []string) error {
+		if _, err := embeddingTaskType(cmd, genai.TaskTypeUnspecified); err != nil {
+			return err
+		}
+		return checkEnums(cmd, args)
+	}
 }
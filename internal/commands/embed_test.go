@@ -0,0 +1,150 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chewxy/math32"
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/cobra"
+)
+
+func TestEmbeddingTaskType(t *testing.T) {
+	addFlags := func(cmd *cobra.Command) { cmd.Flags().String("task-type", "", "") }
+
+	var tests = []struct {
+		args []string
+		want genai.TaskType
+	}{
+		{nil, genai.TaskTypeRetrievalQuery},
+		{[]string{"--task-type", "RETRIEVAL_DOCUMENT"}, genai.TaskTypeRetrievalDocument},
+		{[]string{"--task-type", "semantic_similarity"}, genai.TaskTypeSemanticSimilarity},
+		{[]string{"--task-type", "FACT_VERIFICATION"}, genai.TaskTypeFactVerification},
+	}
+
+	for _, tt := range tests {
+		got, err := embeddingTaskType(parseFlagsCmd(t, addFlags, tt.args...), genai.TaskTypeRetrievalQuery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.args, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"retrieval", "UNSPECIFIED", "TaskTypeClustering"} {
+		if _, err := embeddingTaskType(parseFlagsCmd(t, addFlags, "--task-type", bad), genai.TaskTypeRetrievalQuery); err == nil {
+			t.Errorf("%q: got no error, want error", bad)
+		}
+	}
+}
+
+func TestEmbeddingDimensions(t *testing.T) {
+	addFlags := func(cmd *cobra.Command) { cmd.Flags().Int("dimensions", 0, "") }
+
+	for _, tt := range []struct {
+		args []string
+		want int
+	}{
+		{nil, 0},
+		{[]string{"--dimensions", "256"}, 256},
+	} {
+		got, err := embeddingDimensions(parseFlagsCmd(t, addFlags, tt.args...), "text-embedding-004")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%v: got %d, want %d", tt.args, got, tt.want)
+		}
+	}
+
+	for _, arg := range []string{"0", "-5"} {
+		if _, err := embeddingDimensions(parseFlagsCmd(t, addFlags, "--dimensions", arg), "text-embedding-004"); err == nil {
+			t.Errorf("--dimensions %s: got no error, want error", arg)
+		}
+	}
+}
+
+func TestTruncateEmbedding(t *testing.T) {
+	emb := []float32{0.5, -0.25, 1.5, 3, -7}
+
+	if got := truncateEmbedding(emb, 0); len(got) != len(emb) {
+		t.Errorf("dims 0: got %d values, want %d", len(got), len(emb))
+	}
+	if got := truncateEmbedding(emb, 10); len(got) != len(emb) {
+		t.Errorf("dims 10: got %d values, want %d", len(got), len(emb))
+	}
+
+	// Reduced embeddings are still encoded and decoded correctly.
+	got := decodeEmbedding(encodeEmbedding(truncateEmbedding(emb, 3)))
+	if diff := cmp.Diff(emb[:3], got); diff != "" {
+		t.Errorf("round-trip mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNormalizeEmbedding(t *testing.T) {
+	emb := []float32{3, 0, -4}
+
+	got := normalizeEmbedding(emb)
+	if diff := cmp.Diff([]float32{0.6, 0, -0.8}, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if emb[0] != 3 {
+		t.Errorf("normalizeEmbedding modified its argument: %v", emb)
+	}
+	if d := dotProduct(got, got); math32.Abs(d-1) > 1e-6 {
+		t.Errorf("got squared norm %v, want 1", d)
+	}
+
+	// Normalized embeddings round-trip exactly through the DB encoding.
+	if diff := cmp.Diff(got, decodeEmbedding(encodeEmbedding(got))); diff != "" {
+		t.Errorf("round-trip mismatch (-want +got):\n%s", diff)
+	}
+
+	zero := []float32{0, 0}
+	if diff := cmp.Diff(zero, normalizeEmbedding(zero)); diff != "" {
+		t.Errorf("zero embedding mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEnumFlagErrors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeBackend(t, func(path string, body string) string {
+		t.Errorf("unexpected request %s: %s", path, body)
+		return `{}`
+	})
+
+	// Bad values are reported before anything is read or sent, listing the
+	// values that are allowed.
+	dbPath := filepath.Join(t.TempDir(), "missing.db")
+	var tests = []struct {
+		args    []string
+		wantErr string
+	}{
+		{[]string{"embed", "content", "hello", "--format", "csv"}, "invalid --format value \"csv\"; expect one of: json, base64, blob"},
+		{[]string{"embed", "content", "hello", "--task-type", "sorting"}, "invalid --task-type value \"SORTING\"; expect one of: RETRIEVAL_QUERY, "},
+		{[]string{"embed", "similar", dbPath, "hello", "--metric", "manhattan"}, "expect one of: cosine, dot, euclidean"},
+		{[]string{"embed", "compare", "a", "b", "--metric", "manhattan"}, "expect one of: cosine, dot, euclidean"},
+		{[]string{"embed", "db", dbPath, "--files-list", "x", "--input-format", "xml"}, "expect one of: txt, jsonl, csv"},
+		{[]string{"embed", "db", dbPath, "-", "--id-conflict", "merge"}, "expect one of: error, replace, skip"},
+		{[]string{"embed", "export", dbPath, "--format", "parquet"}, "expect one of: json, npy"},
+		{[]string{"prompt", "hi", "--stream-retry", "forever"}, "expect one of: off, restart, fallback"},
+		{[]string{"prompt", "hi", "--safety", "extreme"}, "invalid --safety value \"extreme\"; expect one of: default, low, medium, high, none"},
+	}
+	for _, tt := range tests {
+		_, err := executeCommandErr(tt.args...)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%v: got error %v, want error containing %q", tt.args, err, tt.wantErr)
+			continue
+		}
+		if got := exitCode(&cobra.Command{SilenceUsage: true}, err); got != exitUsage {
+			t.Errorf("%v: got exit code %d, want %d", tt.args, got, exitUsage)
+		}
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		t.Errorf("DB %s was created, want it untouched", dbPath)
+	}
+}
@@ -0,0 +1,155 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"github.com/spf13/cobra"
+	"google.golang.org/api/googleapi"
+)
+
+// Exit codes of the program, distinguishing categories of errors for scripts
+// running it.
+const (
+	exitOK      = 0
+	exitError   = 1 // any error not in the categories below
+	exitUsage   = 2 // bad command line: unknown command or flag, bad args
+	exitAuth    = 3 // no API key or credentials, or the API rejected them
+	exitAPI     = 4 // an error from the API, a network error or a timeout
+	exitBlocked = 5 // the prompt or the response was blocked by the model
+)
+
+// annotatedError is an error with a message of its own that still exposes
+// the error it was caused by to errors.Is and errors.As.
+type annotatedError struct {
+	msg string
+	err error
+}
+
+func (e *annotatedError) Error() string { return e.msg }
+func (e *annotatedError) Unwrap() error { return e.err }
+
+// authError marks an error as a failure to authenticate with the API, e.g.
+// when there's no API key to use.
+type authError struct {
+	err error
+}
+
+func (e *authError) Error() string { return e.err.Error() }
+func (e *authError) Unwrap() error { return e.err }
+
+// usageError marks an error as a bad command line that's only found once the
+// command runs, e.g. a numeric flag out of its range or flags that can't be
+// used together.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string { return e.err.Error() }
+func (e *usageError) Unwrap() error { return e.err }
+
+// exitCode returns the exit code for err, returned by running cmd.
+func exitCode(cmd *cobra.Command, err error) int {
+	if err == nil {
+		return exitOK
+	}
+
+	// Usage is only silenced once the command line was validated, including
+	// its required flags and flag groups, and the command starts running (see
+	// rootCmd's PersistentPreRunE), so errors returned before that are about
+	// the command line.
+	if cmd == nil || !cmd.SilenceUsage {
+		return exitUsage
+	}
+
+	var ue *usageError
+	if errors.As(err, &ue) {
+		return exitUsage
+	}
+
+	var ae *authError
+	if errors.As(err, &ae) {
+		return exitAuth
+	}
+
+	var be *genai.BlockedError
+	if errors.As(err, &be) {
+		return exitBlocked
+	}
+
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) {
+		if isAuthAPIError(apiErr) {
+			return exitAuth
+		}
+		return exitAPI
+	}
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) {
+		if gErr.Code == http.StatusUnauthorized || gErr.Code == http.StatusForbidden {
+			return exitAuth
+		}
+		return exitAPI
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return exitAPI
+	}
+	return exitError
+}
+
+// isTransientError reports whether err is likely to go away if the request is
+// sent again: a network error, or the API being overloaded or failing.
+func isTransientError(err error) bool {
+	code := 0
+	var apiErr *apierror.APIError
+	var gErr *googleapi.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &apiErr):
+		code = apiErr.HTTPCode()
+	case errors.As(err, &gErr):
+		code = gErr.Code
+	case errors.As(err, &netErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isContextLengthError reports whether err is the API rejecting a prompt with
+// more tokens than the model's input token limit. There's no specific reason
+// for it in the error details, so it's recognized by its message.
+func isContextLengthError(err error) bool {
+	code := 0
+	var apiErr *apierror.APIError
+	var gErr *googleapi.Error
+	switch {
+	case errors.As(err, &apiErr):
+		code = apiErr.HTTPCode()
+	case errors.As(err, &gErr):
+		code = gErr.Code
+	}
+	return code == http.StatusBadRequest && strings.Contains(strings.ToLower(err.Error()), "exceeds the maximum number of tokens")
+}
+
+// isAuthAPIError reports whether err is the API rejecting the credentials
+// it was called with. An invalid API key is reported as a bad request, with
+// the reason telling it apart.
+func isAuthAPIError(err *apierror.APIError) bool {
+	switch err.HTTPCode() {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	}
+	return err.Reason() == "API_KEY_INVALID"
+}
@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"github.com/spf13/cobra"
+	"google.golang.org/api/googleapi"
+)
+
+func TestExitCode(t *testing.T) {
+	running := &cobra.Command{SilenceUsage: true}
+	apiErr := func(code int, reason string) error {
+		gErr := &googleapi.Error{Code: code}
+		if reason != "" {
+			gErr.Body = fmt.Sprintf(`{"error": {"code": %d, "details": [{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": %q}]}}`, code, reason)
+		}
+		ae, ok := apierror.FromError(gErr)
+		if !ok {
+			t.Fatalf("can't make APIError from %v", gErr)
+		}
+		return fmt.Errorf("error sending request: %w", ae)
+	}
+
+	var tests = []struct {
+		name string
+		cmd  *cobra.Command
+		err  error
+		want int
+	}{
+		{"ok", running, nil, exitOK},
+		{"generic", running, errors.New("other error"), exitError},
+		{"interrupted", running, requestError(canceledContext(), running, errors.New("canceled")), exitError},
+		{"usage", &cobra.Command{}, errors.New("unknown flag: --bogus"), exitUsage},
+		{"bad flag value", running, fmt.Errorf("wrapped: %w", &usageError{errors.New("--top-k must be positive, got 0")}), exitUsage},
+		{"no API key", running, fmt.Errorf("wrapped: %w", &authError{errors.New("Unable to obtain API key")}), exitAuth},
+		{"forbidden", running, apiErr(http.StatusForbidden, ""), exitAuth},
+		{"invalid API key", running, apiErr(http.StatusBadRequest, "API_KEY_INVALID"), exitAuth},
+		{"bad request", running, apiErr(http.StatusBadRequest, ""), exitAPI},
+		{"server error", running, &googleapi.Error{Code: http.StatusInternalServerError}, exitAPI},
+		{"network", running, &net.OpError{Op: "dial", Err: errors.New("connection refused")}, exitAPI},
+		{"timeout", running, fmt.Errorf("error: %w", &annotatedError{msg: "request timed out", err: context.DeadlineExceeded}), exitAPI},
+		{"blocked", running, fmt.Errorf("error: %w", blockedError(&genai.BlockedError{PromptFeedback: &genai.PromptFeedback{BlockReason: genai.BlockReasonSafety}})), exitBlocked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.cmd, tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExitCodeFlagErrors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	for _, args := range [][]string{
+		{"run"},
+		{"prompt", "--stream", "--no-stream", "hi"},
+	} {
+		// Earlier test runs of the command leave its usage silenced.
+		if c, _, err := rootCmd.Find(args); err == nil {
+			c.SilenceUsage = false
+		}
+		var out strings.Builder
+		rootCmd.SetOut(&out)
+		rootCmd.SetErr(&out)
+		rootCmd.SetArgs(args)
+		cmd, err := rootCmd.ExecuteC()
+		if got := exitCode(cmd, err); got != exitUsage {
+			t.Errorf("%q: got exit code %d for error %v, want %d", args, got, err, exitUsage)
+		}
+		if !strings.Contains(out.String(), "Usage:") {
+			t.Errorf("%q: got output %q, want the usage text", args, out.String())
+		}
+		rootCmd.SetOut(nil)
+		rootCmd.SetErr(nil)
+		rootCmd.SetArgs(nil)
+		resetFlags(rootCmd)
+	}
+}
+
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
@@ -0,0 +1,199 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/spf13/cobra"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List recent prompts",
+	Long:  strings.TrimSpace(historyUsage),
+	Args:  cobra.ExactArgs(0),
+	RunE:  runHistoryCmd,
+}
+
+var historyClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Clear the prompt history",
+	Args:  cobra.ExactArgs(0),
+	RunE:  runHistoryClearCmd,
+}
+
+var historyUsage = `
+List the most recent prompts sent with the prompt command, oldest first, with
+the time they were sent. The last prompt can be sent again with "prompt --last";
+it's sent as it was resolved before, so standard input, files and URLs in it
+aren't read again (except for prompts with more than 1 MiB of content, which
+only keep their arguments).
+
+The history is kept in ~/.config/gemini-cli/history, and is limited to the
+last 100 prompts. "history clear" deletes it.
+`
+
+// historySize is the maximal number of prompts kept in the history.
+const historySize = 100
+
+// historyEntry is a prompt recorded in the history: the arguments of the
+// prompt command, the prompt parts they were resolved to and the time it was
+// run. prompt --last sends the parts, so that standard input, files and URLs
+// in the arguments aren't read again. Entries of older versions, or with
+// parts larger than maxHistoryPartsBytes, don't have parts.
+type historyEntry struct {
+	Time  time.Time  `json:"time"`
+	Args  []string   `json:"args"`
+	Parts []partJSON `json:"parts,omitempty"`
+}
+
+// maxHistoryPartsBytes is the maximal size of the text and data of the prompt
+// parts kept in a history entry.
+const maxHistoryPartsBytes = 1 << 20
+
+// newHistoryEntry returns the history entry for a prompt sent now, with
+// arguments args resolved to parts.
+func newHistoryEntry(args []string, parts []genai.Part) historyEntry {
+	e := historyEntry{Time: time.Now(), Args: args}
+	size := 0
+	for _, part := range parts {
+		pj := partToJSON(part)
+		if pj.Text != nil {
+			size += len(*pj.Text)
+		}
+		if pj.InlineData != nil {
+			size += len(pj.InlineData.Data)
+		}
+		e.Parts = append(e.Parts, pj)
+	}
+	if size > maxHistoryPartsBytes {
+		e.Parts = nil
+	}
+	return e
+}
+
+// promptParts returns the recorded prompt parts of e, or nil if it has none;
+// they're then built from its arguments again, unless one of them is '-',
+// since standard input can't be read again.
+func (e historyEntry) promptParts() ([]genai.Part, error) {
+	if len(e.Parts) == 0 {
+		if slices.Contains(e.Args, "-") {
+			return nil, errors.New("the last prompt was read from standard input, which isn't kept in the history; send it again without --last")
+		}
+		return nil, nil
+	}
+	var parts []genai.Part
+	for i, pj := range e.Parts {
+		part, err := partFromJSON(pj)
+		if err != nil {
+			return nil, fmt.Errorf("history entry: parts[%d]: %w", i, err)
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyClearCmd)
+}
+
+func runHistoryCmd(cmd *cobra.Command, args []string) error {
+	path, err := historyFilePath()
+	if err != nil {
+		return err
+	}
+	entries, err := readHistory(path)
+	if err != nil {
+		return err
+	}
+
+	for i, e := range entries {
+		var quoted []string
+		for _, arg := range e.Args {
+			quoted = append(quoted, fmt.Sprintf("%q", arg))
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%d\t%s\t%s\n", i+1, e.Time.Local().Format(time.DateTime), strings.Join(quoted, " "))
+	}
+	return nil
+}
+
+func runHistoryClearCmd(cmd *cobra.Command, args []string) error {
+	path, err := historyFilePath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// historyFilePath returns the path of the prompt history file.
+func historyFilePath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".config", "gemini-cli", "history"), nil
+}
+
+// readHistory reads the history file at path, with one JSON-encoded entry per
+// line. A missing file is an empty history.
+func readHistory(path string) ([]historyEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	// Prompts can be long; allow lines beyond the default limit of 64 KiB.
+	scanner.Buffer(nil, 16*1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		var e historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("history file %s, line %d: %w", path, lineNum, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// appendHistory adds e to the end of the history file at path, dropping the
+// oldest entries beyond historySize.
+func appendHistory(path string, e historyEntry) error {
+	entries, err := readHistory(path)
+	if err != nil {
+		return err
+	}
+	entries = append(entries, e)
+	if len(entries) > historySize {
+		entries = entries[len(entries)-historySize:]
+	}
+
+	var sb strings.Builder
+	enc := json.NewEncoder(&sb)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0600)
+}
@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gemini-cli", "history")
+
+	// A missing history file is an empty history.
+	entries, err := readHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d entries in new history, want none", len(entries))
+	}
+
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	var want []historyEntry
+	for i := 0; i < historySize+5; i++ {
+		e := historyEntry{
+			Time: start.Add(time.Duration(i) * time.Minute),
+			Args: []string{fmt.Sprintf("prompt %d", i), "with\nnewline"},
+		}
+		if err := appendHistory(path, e); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, e)
+	}
+
+	// Only the last historySize entries are kept.
+	entries, err = readHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want[len(want)-historySize:], entries); diff != "" {
+		t.Errorf("history mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := os.WriteFile(path, []byte("not json\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readHistory(path); err == nil {
+		t.Errorf("got no error for malformed history, want error")
+	}
+}
+
+func TestPromptLast(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	var gotBody string
+	fakeBackend(t, func(path string, body string) string {
+		gotBody = body
+		return `{"candidates": [{"content": {"role": "model", "parts": [{"text": "hi"}]}, "finishReason": 1}]}`
+	})
+
+	// The prompt is sent again as it was resolved, without reading stdin or
+	// the file again.
+	promptPath := filepath.Join(t.TempDir(), "prompt.txt")
+	if err := os.WriteFile(promptPath, []byte("from the file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rootCmd.SetIn(strings.NewReader("from stdin"))
+	executeCommand(t, "prompt", "--no-stream", "-", "@"+promptPath)
+	rootCmd.SetIn(nil)
+	if err := os.Remove(promptPath); err != nil {
+		t.Fatal(err)
+	}
+	gotBody = ""
+	executeCommand(t, "prompt", "--no-stream", "--last")
+	if !strings.Contains(gotBody, "from stdin") || !strings.Contains(gotBody, "from the file") {
+		t.Errorf("got request %s, want the last prompt", gotBody)
+	}
+
+	// Entries without parts are sent again from their arguments, which can't
+	// be done for stdin.
+	historyPath := filepath.Join(home, ".config", "gemini-cli", "history")
+	for _, args := range [][]string{{"from args"}, {"-"}} {
+		if err := appendHistory(historyPath, historyEntry{Time: time.Now(), Args: args}); err != nil {
+			t.Fatal(err)
+		}
+		gotBody = ""
+		_, err := executeCommandErr("prompt", "--no-stream", "--last")
+		if args[0] == "-" {
+			if err == nil || !strings.Contains(err.Error(), "standard input") {
+				t.Errorf("%q: got error %v, want an error about standard input", args, err)
+			}
+		} else if err != nil || !strings.Contains(gotBody, "from args") {
+			t.Errorf("%q: got (%v, request %s), want the prompt of the arguments", args, err, gotBody)
+		}
+	}
+
+	bigArgs := []string{strings.Repeat("x", maxHistoryPartsBytes+1)}
+	if e := newHistoryEntry(bigArgs, []genai.Part{genai.Text(bigArgs[0])}); e.Parts != nil {
+		t.Errorf("got %d parts for a prompt larger than maxHistoryPartsBytes, want none", len(e.Parts))
+	}
+}
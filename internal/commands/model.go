@@ -0,0 +1,193 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/spf13/cobra"
+)
+
+// addModelFlags adds the flags that configure a generative model to cmd. The
+// flags are applied to a model with configureModel.
+func addModelFlags(cmd *cobra.Command) {
+	// --system and --system-file share their list of values, so that the
+	// parts of the system instruction keep the order of the command line.
+	var systemSources []systemSource
+	cmd.Flags().VarP(&systemSourcesValue{sources: &systemSources}, "system", "s", "set a system instruction for the model; can be repeated, and combined with --system-file")
+	cmd.Flags().Var(&systemSourcesValue{sources: &systemSources, fromFile: true}, "system-file", "read a system instruction for the model from a file; can be repeated, and combined with --system")
+	cmd.Flags().String("safety", "default", safetyFlagUsage)
+	cmd.Flags().StringArray("safety-category", nil, "set the safety level of a single harm category, as CATEGORY=LEVEL (e.g. HARASSMENT=low), on top of --safety; can be repeated (see the safety command for the names)")
+	cmd.Flags().Bool("raw", false, "turn off all safety filtering of the prompt and response; same as --safety none, and overrides --safety and --safety-category")
+
+	// The generation parameters are only set on the model if the user provided
+	// them explicitly, keeping the model's defaults otherwise.
+	cmd.Flags().Float32("temperature", 0, "temperature setting for the model, in the range [0.0, 2.0]")
+	setFlagAliases(cmd, map[string]string{"temp": "temperature", "no-safety": "raw"})
+	cmd.Flags().Float32("top-p", 0, "top-p (nucleus sampling) setting for the model, in the range [0.0, 1.0]")
+	cmd.Flags().Int32("top-k", 0, "top-k setting for the model; must be positive")
+	cmd.Flags().Int32("max-tokens", 0, "maximum number of tokens in the response; must be positive")
+	cmd.Flags().StringArray("stop", nil, fmt.Sprintf("stop generating the response at this sequence, which isn't included in it; can be repeated, up to %d times", maxStopSequences))
+}
+
+// systemSource is a part of the system instruction: the value of --system, or
+// the path given with --system-file.
+type systemSource struct {
+	value    string
+	fromFile bool
+}
+
+// systemSourcesValue is the flag value of --system (or, with fromFile, of
+// --system-file). The values of both flags are added to the same sources, in
+// the order they're given.
+type systemSourcesValue struct {
+	sources  *[]systemSource
+	fromFile bool
+}
+
+func (v *systemSourcesValue) Set(s string) error {
+	return v.Append(s)
+}
+
+func (v *systemSourcesValue) Type() string {
+	return "stringArray"
+}
+
+func (v *systemSourcesValue) String() string {
+	values := v.GetSlice()
+	if len(values) == 0 {
+		// An empty default isn't shown in the help.
+		return ""
+	}
+	return "[" + strings.Join(values, ",") + "]"
+}
+
+// Append, Replace and GetSlice implement pflag.SliceValue; they only affect
+// and return the values of v's own flag.
+
+func (v *systemSourcesValue) Append(s string) error {
+	*v.sources = append(*v.sources, systemSource{value: s, fromFile: v.fromFile})
+	return nil
+}
+
+func (v *systemSourcesValue) Replace(values []string) error {
+	*v.sources = slices.DeleteFunc(*v.sources, func(src systemSource) bool {
+		return src.fromFile == v.fromFile
+	})
+	for _, s := range values {
+		v.Append(s)
+	}
+	return nil
+}
+
+func (v *systemSourcesValue) GetSlice() []string {
+	var values []string
+	for _, src := range *v.sources {
+		if src.fromFile == v.fromFile {
+			values = append(values, src.value)
+		}
+	}
+	return values
+}
+
+// preRunModel returns the PreRunE of a command with the flags of
+// addModelFlags: it checks the --safety flag, and the flags in enums as
+// checkEnumFlags does.
+func preRunModel(enums map[string][]string) func(cmd *cobra.Command, args []string) error {
+	checkEnums := checkEnumFlags(enums)
+	return func(cmd *cobra.Command, args []string) error {
+		if _, err := safetySettingsForLevel(mustGetStringFlag(cmd, "safety")); err != nil {
+			return err
+		}
+		return checkEnums(cmd, args)
+	}
+}
+
+// maxStopSequences is the maximal number of stop sequences the API accepts.
+const maxStopSequences = 5
+
+// configureModel applies the flags added by addModelFlags to model.
+func configureModel(cmd *cobra.Command, model *genai.GenerativeModel) error {
+	safetyLevel := mustGetStringFlag(cmd, "safety")
+	categoryLevels := mustGetStringArrayFlag(cmd, "safety-category")
+	if mustGetBoolFlag(cmd, "raw") {
+		safetyLevel = "none"
+		categoryLevels = nil
+	}
+	safetySettings, err := safetySettingsForLevel(safetyLevel)
+	if err != nil {
+		return err
+	}
+	safetySettings, err = applySafetyCategories(safetySettings, categoryLevels)
+	if err != nil {
+		return err
+	}
+	model.SafetySettings = safetySettings
+	if safetyFilteringOff(safetySettings) {
+		log.Println("NOTICE: safety filtering is off")
+	}
+
+	if sources := *cmd.Flags().Lookup("system").Value.(*systemSourcesValue).sources; len(sources) > 0 {
+		// Each source is a part of the system instruction. Sources that are
+		// empty or can't be read are skipped, as long as some are left.
+		var parts []genai.Part
+		for _, src := range sources {
+			text := src.value
+			if src.fromFile {
+				b, err := os.ReadFile(src.value)
+				if err != nil {
+					log.Printf("WARNING: skipping system instruction file: %v", err)
+					continue
+				}
+				text = string(b)
+			}
+			if strings.TrimSpace(text) != "" {
+				parts = append(parts, genai.Text(text))
+			}
+		}
+		if len(parts) == 0 {
+			return errors.New("no system instruction: the values of --system and --system-file are all empty or unreadable")
+		}
+		model.SystemInstruction = &genai.Content{Parts: parts}
+	}
+
+	if flagIsSet(cmd, "temperature") {
+		temp := mustGetFloat32Flag(cmd, "temperature")
+		if temp < 0.0 || temp > 2.0 {
+			return &usageError{fmt.Errorf("--temperature must be in the range [0.0, 2.0], got %v", temp)}
+		}
+		model.SetTemperature(temp)
+	}
+	if cmd.Flags().Changed("top-p") {
+		topP := mustGetFloat32Flag(cmd, "top-p")
+		if topP < 0.0 || topP > 1.0 {
+			return &usageError{fmt.Errorf("--top-p must be in the range [0.0, 1.0], got %v", topP)}
+		}
+		model.SetTopP(topP)
+	}
+	if cmd.Flags().Changed("top-k") {
+		topK := mustGetInt32Flag(cmd, "top-k")
+		if topK < 1 {
+			return &usageError{fmt.Errorf("--top-k must be positive, got %v", topK)}
+		}
+		model.SetTopK(topK)
+	}
+	if cmd.Flags().Changed("max-tokens") {
+		maxTokens := mustGetInt32Flag(cmd, "max-tokens")
+		if maxTokens < 1 {
+			return &usageError{fmt.Errorf("--max-tokens must be positive, got %v", maxTokens)}
+		}
+		model.SetMaxOutputTokens(maxTokens)
+	}
+	if stops := mustGetStringArrayFlag(cmd, "stop"); len(stops) > 0 {
+		if len(stops) > maxStopSequences {
+			return &usageError{fmt.Errorf("--stop can be given at most %d times, got %d", maxStopSequences, len(stops))}
+		}
+		model.StopSequences = stops
+	}
+	return nil
+}
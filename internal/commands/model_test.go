@@ -0,0 +1,249 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/cobra"
+)
+
+func TestConfigureModelSafety(t *testing.T) {
+	// By default, no safety settings are sent, so the API's defaults apply.
+	for _, cmd := range []*cobra.Command{promptCmd, templateCmd, chatCmd} {
+		model := &genai.GenerativeModel{}
+		if err := configureModel(cmd, model); err != nil {
+			t.Fatalf("%s: %v", cmd.Name(), err)
+		}
+		if model.SafetySettings != nil {
+			t.Errorf("%s: got safety settings %v by default, want none", cmd.Name(), model.SafetySettings)
+		}
+	}
+
+	var want []*genai.SafetySetting
+	for _, category := range harmCategories {
+		want = append(want, &genai.SafetySetting{Category: category, Threshold: genai.HarmBlockNone})
+	}
+	if len(want) != 4 {
+		t.Fatalf("got %d harm categories, want 4", len(want))
+	}
+
+	for _, args := range [][]string{
+		{"--raw"},
+		{"--no-safety"},
+		{"--safety", "none"},
+		{"--safety", "high", "--raw"},
+	} {
+		cmd := parseFlagsCmd(t, addModelFlags, args...)
+		model := &genai.GenerativeModel{}
+		if err := configureModel(cmd, model); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if diff := cmp.Diff(want, model.SafetySettings); diff != "" {
+			t.Errorf("%v: safety settings mismatch (-want +got):\n%s", args, diff)
+		}
+	}
+}
+
+func TestConfigureModelSafetyCategories(t *testing.T) {
+	var tests = []struct {
+		args []string
+		want []*genai.SafetySetting
+	}{
+		{
+			[]string{"--safety-category", "HARASSMENT=low"},
+			[]*genai.SafetySetting{{Category: genai.HarmCategoryHarassment, Threshold: genai.HarmBlockLowAndAbove}},
+		},
+		{
+			[]string{"--safety", "high", "--safety-category", "harm_category_hate_speech=NONE", "--safety-category", "dangerous_content=default"},
+			[]*genai.SafetySetting{
+				{Category: genai.HarmCategoryHarassment, Threshold: genai.HarmBlockOnlyHigh},
+				{Category: genai.HarmCategorySexuallyExplicit, Threshold: genai.HarmBlockOnlyHigh},
+				{Category: genai.HarmCategoryHateSpeech, Threshold: genai.HarmBlockNone},
+			},
+		},
+		{
+			// The last value for a category wins.
+			[]string{"--safety-category", "HARASSMENT=low", "--safety-category", "HARASSMENT=medium"},
+			[]*genai.SafetySetting{{Category: genai.HarmCategoryHarassment, Threshold: genai.HarmBlockMediumAndAbove}},
+		},
+	}
+	for _, tt := range tests {
+		cmd := parseFlagsCmd(t, addModelFlags, tt.args...)
+		model := &genai.GenerativeModel{}
+		if err := configureModel(cmd, model); err != nil {
+			t.Fatalf("%v: %v", tt.args, err)
+		}
+		if diff := cmp.Diff(tt.want, model.SafetySettings); diff != "" {
+			t.Errorf("%v: safety settings mismatch (-want +got):\n%s", tt.args, diff)
+		}
+	}
+
+	for _, tt := range []struct {
+		value   string
+		wantErr string
+	}{
+		{"HARASSMENT", "expect CATEGORY=LEVEL"},
+		{"VIOLENCE=low", "invalid harm category"},
+		{"HARASSMENT=strict", "invalid safety level"},
+	} {
+		cmd := parseFlagsCmd(t, addModelFlags, "--safety-category", tt.value)
+		err := configureModel(cmd, &genai.GenerativeModel{})
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got error %v, want it to contain %q", tt.value, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPreRunModelSafety(t *testing.T) {
+	// --safety is checked before the command runs, keeping its values
+	// case-insensitive.
+	for _, cmd := range []*cobra.Command{promptCmd, templateCmd, chatCmd} {
+		t.Run(cmd.Name(), func(t *testing.T) {
+			t.Cleanup(func() { resetFlags(cmd) })
+			if err := cmd.Flags().Set("safety", " High"); err != nil {
+				t.Fatal(err)
+			}
+			if err := cmd.PreRunE(cmd, nil); err != nil {
+				t.Errorf("got error %v for --safety ' High', want none", err)
+			}
+			if err := cmd.Flags().Set("safety", "extreme"); err != nil {
+				t.Fatal(err)
+			}
+			err := cmd.PreRunE(cmd, nil)
+			if err == nil || !strings.Contains(err.Error(), `invalid --safety value "extreme"`) {
+				t.Errorf("got error %v, want invalid --safety value", err)
+			}
+			if got := exitCode(&cobra.Command{SilenceUsage: true}, err); got != exitUsage {
+				t.Errorf("got exit code %d, want %d", got, exitUsage)
+			}
+		})
+	}
+}
+
+func TestConfigureModelFlags(t *testing.T) {
+	model := &genai.GenerativeModel{}
+	if err := configureModel(parseFlagsCmd(t, addModelFlags), model); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(genai.GenerationConfig{}, model.GenerationConfig); diff != "" {
+		t.Errorf("generation config set without flags (-want +got):\n%s", diff)
+	}
+
+	model = &genai.GenerativeModel{}
+	cmd := parseFlagsCmd(t, addModelFlags, "--temp", "0.5", "--top-p", "0.9", "--top-k", "20", "--max-tokens", "100", "--stop", "END", "--stop", "\n\n")
+	if err := configureModel(cmd, model); err != nil {
+		t.Fatal(err)
+	}
+	temp, topP, topK, maxTokens := float32(0.5), float32(0.9), int32(20), int32(100)
+	wantConfig := genai.GenerationConfig{
+		Temperature:     &temp,
+		TopP:            &topP,
+		TopK:            &topK,
+		MaxOutputTokens: &maxTokens,
+		StopSequences:   []string{"END", "\n\n"},
+	}
+	if diff := cmp.Diff(wantConfig, model.GenerationConfig); diff != "" {
+		t.Errorf("generation config mismatch (-want +got):\n%s", diff)
+	}
+
+	model = &genai.GenerativeModel{}
+	if err := configureModel(parseFlagsCmd(t, addModelFlags, "-s", "answer in spanish"), model); err != nil {
+		t.Fatal(err)
+	}
+	wantInstruction := &genai.Content{Parts: []genai.Part{genai.Text("answer in spanish")}}
+	if diff := cmp.Diff(wantInstruction, model.SystemInstruction); diff != "" {
+		t.Errorf("system instruction mismatch (-want +got):\n%s", diff)
+	}
+
+	sysFile := filepath.Join(t.TempDir(), "system.txt")
+	if err := os.WriteFile(sysFile, []byte("answer in spanish"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	model = &genai.GenerativeModel{}
+	if err := configureModel(parseFlagsCmd(t, addModelFlags, "--system-file", sysFile), model); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(wantInstruction, model.SystemInstruction); diff != "" {
+		t.Errorf("system instruction from file mismatch (-want +got):\n%s", diff)
+	}
+
+	// Several sources are combined into parts, in the order given; files
+	// that can't be read are skipped if there are others.
+	model = &genai.GenerativeModel{}
+	cmd = parseFlagsCmd(t, addModelFlags, "-s", "be brief", "--system-file", sysFile, "--system-file", filepath.Join(t.TempDir(), "missing.txt"), "--system", "use metric units")
+	if err := configureModel(cmd, model); err != nil {
+		t.Fatal(err)
+	}
+	wantInstruction = &genai.Content{Parts: []genai.Part{genai.Text("be brief"), genai.Text("answer in spanish"), genai.Text("use metric units")}}
+	if diff := cmp.Diff(wantInstruction, model.SystemInstruction); diff != "" {
+		t.Errorf("combined system instruction mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, args := range [][]string{
+		{"--temperature", "2.1"},
+		{"--top-p", "-0.1"},
+		{"--top-k", "0"},
+		{"--max-tokens", "0"},
+		{"--safety", "bogus"},
+		{"--stop", "1", "--stop", "2", "--stop", "3", "--stop", "4", "--stop", "5", "--stop", "6"},
+		{"--system-file", filepath.Join(t.TempDir(), "missing.txt")},
+		{"--system", " ", "--system-file", filepath.Join(t.TempDir(), "missing.txt")},
+	} {
+		if err := configureModel(parseFlagsCmd(t, addModelFlags, args...), &genai.GenerativeModel{}); err == nil {
+			t.Errorf("%v: got no error, want error", args)
+		}
+	}
+}
+
+func TestNumericFlagErrors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeBackend(t, func(path string, body string) string {
+		t.Errorf("unexpected request %s: %s", path, body)
+		return `{}`
+	})
+
+	for _, args := range [][]string{
+		{"--temp", "warm"},
+		{"--temp", "2.5"},
+		{"--temperature", "-1"},
+		{"--top-p", "1.5"},
+		{"--top-k", "1.5"},
+		{"--max-tokens", "0"},
+		{"--candidates", "0"},
+		{"--cache-responses", "--cache-ttl", "-1h"},
+		{"--stop", "1", "--stop", "2", "--stop", "3", "--stop", "4", "--stop", "5", "--stop", "6"},
+	} {
+		// Usage is silenced once a command starts running; start from the
+		// state of a fresh run.
+		promptCmd.SilenceUsage = false
+		_, err := executeCommandErr(append([]string{"prompt", "--no-stream", "hi"}, args...)...)
+		if err == nil {
+			t.Errorf("%v: got no error, want error", args)
+			continue
+		}
+		if got := exitCode(promptCmd, err); got != exitUsage {
+			t.Errorf("%v: got exit code %d for error %v, want %d", args, got, err, exitUsage)
+		}
+	}
+
+	// Other commands check their numbers the same way.
+	for _, args := range [][]string{
+		{"cache", "create", "--file", "x", "--ttl", "0"},
+		{"embed", "content", "--dimensions", "0", "hi"},
+		{"embed", "db", filepath.Join(t.TempDir(), "x.db"), "--files-stdin", "--chunk-size", "-1"},
+	} {
+		cmd, _, err := rootCmd.Find(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd.SilenceUsage = false
+		_, err = executeCommandErr(args...)
+		if got := exitCode(cmd, err); got != exitUsage {
+			t.Errorf("%v: got exit code %d for error %v, want %d", args, got, err, exitUsage)
+		}
+	}
+}
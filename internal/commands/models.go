@@ -1,13 +1,12 @@
 package commands
 
 import (
-	"context"
+	"encoding/json"
 	"fmt"
-	"log"
-	"os"
 	"strings"
 	"text/tabwriter"
 
+	"github.com/google/generative-ai-go/genai"
 	"github.com/spf13/cobra"
 	"google.golang.org/api/iterator"
 )
@@ -17,12 +16,39 @@ var modelsCmd = &cobra.Command{
 	Short: "List supported Gemini models",
 	Args:  cobra.ExactArgs(0),
 	Long:  strings.TrimSpace(modelsUsage),
-	Run:   runModelsCmd,
+	RunE:  runModelsCmd,
 }
 
+var modelsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List supported Gemini models",
+	Args:  cobra.ExactArgs(0),
+	Long:  strings.TrimSpace(modelsUsage),
+	RunE:  runModelsCmd,
+}
+
+var modelsInfoCmd = &cobra.Command{
+	Use:   "info <model name>",
+	Short: "Show the details of a single model",
+	Args:  cobra.ExactArgs(1),
+	Long:  strings.TrimSpace(modelsInfoUsage),
+	RunE:  runModelsInfoCmd,
+}
+
+var modelsInfoUsage = `
+Show the details of a single model: its token limits, the API methods it
+supports and the defaults and ranges of its generation parameters. The name
+may be given with or without the 'models/' prefix.
+
+Parameters that the model doesn't support are reported as such; for example,
+a model without a top-K default doesn't accept --top-k.
+
+With --json, the model is emitted as a JSON object.
+`
+
 var modelsUsage = `
 List the Gemini models supported by Google AI, along with some details
-about each model.
+about each model. 'models' and 'models list' are equivalent.
 
 Many of these may start with a 'models/' prefix; when you pass a model name to
 the '--model' flag of other commands, you may omit the prefix for brevity.
@@ -31,27 +57,84 @@ Explanation of some non-obvious columns in the output:
 
 * Max In: the maximal number of input tokens supported by the model
 * Max Out: the maximal number of output tokens supported by the model
+* Methods: the API methods the model supports (e.g. generateContent for
+  models usable with the prompt command, embedContent for embedding models)
+
+With --json, each model is emitted as a JSON object on a separate line.
 `
 
 func init() {
 	rootCmd.AddCommand(modelsCmd)
+	modelsCmd.AddCommand(modelsListCmd)
+	modelsCmd.AddCommand(modelsInfoCmd)
+
+	modelsCmd.PersistentFlags().Bool("json", false, "emit model information as JSON")
 
-	modelsCmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+	hideModelFlag := func(command *cobra.Command, strings []string) {
 		command.Flags().MarkHidden("model")
-		command.Parent().HelpFunc()(command, strings)
-	})
+		command.Root().HelpFunc()(command, strings)
+	}
+	modelsCmd.SetHelpFunc(hideModelFlag)
+	modelsListCmd.SetHelpFunc(hideModelFlag)
+	modelsInfoCmd.SetHelpFunc(hideModelFlag)
+	modelsInfoCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return completeModelNames(cmd, args, toComplete)
+	}
+}
+
+// modelInfoJSON is the JSON-serializable form of genai.ModelInfo.
+type modelInfoJSON struct {
+	Name                       string   `json:"name"`
+	DisplayName                string   `json:"displayName"`
+	Version                    string   `json:"version"`
+	Description                string   `json:"description"`
+	InputTokenLimit            int32    `json:"inputTokenLimit"`
+	OutputTokenLimit           int32    `json:"outputTokenLimit"`
+	SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+	Temperature                float32  `json:"temperature"`
+	MaxTemperature             *float32 `json:"maxTemperature,omitempty"`
+	TopP                       float32  `json:"topP"`
+	TopK                       int32    `json:"topK"`
 }
 
-func runModelsCmd(cmd *cobra.Command, args []string) {
-	ctx := context.Background()
-	client, err := newGenaiClient(ctx, cmd)
+func newModelInfoJSON(mi *genai.ModelInfo) modelInfoJSON {
+	return modelInfoJSON{
+		Name:                       mi.Name,
+		DisplayName:                mi.DisplayName,
+		Version:                    mi.Version,
+		Description:                mi.Description,
+		InputTokenLimit:            mi.InputTokenLimit,
+		OutputTokenLimit:           mi.OutputTokenLimit,
+		SupportedGenerationMethods: mi.SupportedGenerationMethods,
+		Temperature:                mi.Temperature,
+		MaxTemperature:             mi.MaxTemperature,
+		TopP:                       mi.TopP,
+		TopK:                       mi.TopK,
+	}
+}
+
+func runModelsCmd(cmd *cobra.Command, args []string) error {
+	ctx, stop := newCommandContext()
+	defer stop()
+	ctx, cancel := withRequestTimeout(ctx, cmd)
+	defer cancel()
+	client, err := newClient(ctx, cmd)
 	if err != nil {
-		log.Fatal()
+		return err
 	}
+	defer client.Close()
 
-	w := tabwriter.NewWriter(os.Stdout, 6, 16, 1, '\t', 0)
-	fmt.Fprintf(w, "%-32s\tVersion\tMax In\tMax Out\tDescription\n", "Name")
-	fmt.Fprintf(w, "\n")
+	jsonOutput := mustGetBoolFlag(cmd, "json")
+	enc := json.NewEncoder(cmd.OutOrStdout())
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 6, 16, 1, '\t', 0)
+	if !jsonOutput {
+		fmt.Fprintf(w, "%-32s\tDisplay Name\tVersion\tMax In\tMax Out\tMethods\tDescription\n", "Name")
+		fmt.Fprintf(w, "\n")
+	}
 
 	iter := client.ListModels(ctx)
 	for {
@@ -60,12 +143,61 @@ func runModelsCmd(cmd *cobra.Command, args []string) {
 			break
 		}
 		if err != nil {
-			panic(err)
+			return requestError(ctx, cmd, err)
 		}
 
-		fmt.Fprintf(w, "%-32s\t%s\t%v\t%v\t%s\n", mi.Name, mi.Version, mi.InputTokenLimit, mi.OutputTokenLimit, mi.Description)
+		if jsonOutput {
+			if err := enc.Encode(newModelInfoJSON(mi)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fmt.Fprintf(w, "%-32s\t%s\t%s\t%v\t%v\t%s\t%s\n", mi.Name, mi.DisplayName, mi.Version, mi.InputTokenLimit, mi.OutputTokenLimit, strings.Join(mi.SupportedGenerationMethods, ","), mi.Description)
 		w.Flush()
+	}
+	return nil
+}
+
+func runModelsInfoCmd(cmd *cobra.Command, args []string) error {
+	ctx, stop := newCommandContext()
+	defer stop()
+	ctx, cancel := withRequestTimeout(ctx, cmd)
+	defer cancel()
+	client, err := newClient(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	mi, err := client.GenerativeModel(args[0]).Info(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting model info: %w", requestError(ctx, cmd, err))
+	}
 
-		//fmt.Println(mi.Name, mi.Version, mi.Description, mi.InputTokenLimit, mi.OutputTokenLimit)
+	if mustGetBoolFlag(cmd, "json") {
+		return json.NewEncoder(cmd.OutOrStdout()).Encode(newModelInfoJSON(mi))
 	}
+
+	temperature := fmt.Sprintf("%v (default)", mi.Temperature)
+	if mi.MaxTemperature != nil {
+		temperature += fmt.Sprintf(", range 0 to %v", *mi.MaxTemperature)
+	}
+	topK := "not supported"
+	if mi.TopK > 0 {
+		topK = fmt.Sprintf("%v (default)", mi.TopK)
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", mi.Name)
+	fmt.Fprintf(w, "Display Name:\t%s\n", mi.DisplayName)
+	fmt.Fprintf(w, "Version:\t%s\n", mi.Version)
+	fmt.Fprintf(w, "Description:\t%s\n", mi.Description)
+	fmt.Fprintf(w, "Max In:\t%v tokens\n", mi.InputTokenLimit)
+	fmt.Fprintf(w, "Max Out:\t%v tokens\n", mi.OutputTokenLimit)
+	fmt.Fprintf(w, "Methods:\t%s\n", strings.Join(mi.SupportedGenerationMethods, ", "))
+	fmt.Fprintf(w, "Temperature:\t%s\n", temperature)
+	fmt.Fprintf(w, "Top P:\t%v (default)\n", mi.TopP)
+	fmt.Fprintf(w, "Top K:\t%s\n", topK)
+	return w.Flush()
 }
@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestModelsInfo(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeBackend(t, func(path string, body string) string {
+		switch path {
+		case "/v1beta/models/gemini-1.5-flash":
+			return `{"name": "models/gemini-1.5-flash", "displayName": "Gemini 1.5 Flash", "version": "001",
+				"inputTokenLimit": 1000000, "outputTokenLimit": 8192,
+				"supportedGenerationMethods": ["generateContent", "countTokens"],
+				"temperature": 1, "maxTemperature": 2, "topP": 0.95, "topK": 64}`
+		case "/v1beta/models/text-embedding-004":
+			return `{"name": "models/text-embedding-004", "inputTokenLimit": 2048, "outputTokenLimit": 1,
+				"supportedGenerationMethods": ["embedContent"]}`
+		}
+		t.Errorf("unexpected request %s: %s", path, body)
+		return `{}`
+	})
+
+	got := executeCommand(t, "models", "info", "gemini-1.5-flash")
+	for _, want := range []string{
+		"Name:          models/gemini-1.5-flash\n",
+		"Max In:        1000000 tokens\n",
+		"Methods:       generateContent, countTokens\n",
+		"Temperature:   1 (default), range 0 to 2\n",
+		"Top P:         0.95 (default)\n",
+		"Top K:         64 (default)\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("models info: got output\n%s\nwant it to contain %q", got, want)
+		}
+	}
+
+	got = executeCommand(t, "models", "info", "models/text-embedding-004")
+	if want := "Top K:         not supported\n"; !strings.Contains(got, want) {
+		t.Errorf("models info: got output\n%s\nwant it to contain %q", got, want)
+	}
+
+	got = executeCommand(t, "models", "info", "gemini-1.5-flash", "--json")
+	if want := `"maxTemperature":2,"topP":0.95,"topK":64}`; !strings.Contains(got, want) {
+		t.Errorf("models info --json: got output %s, want it to contain %s", got, want)
+	}
+}
@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+)
+
+// progressBarWidth is the number of characters in the bar drawn by progress.
+const progressBarWidth = 30
+
+// progress reports how many of a total number of items were processed so
+// far. On a terminal it draws a progress bar that's updated in place;
+// otherwise (e.g. when stderr is redirected to a file) it logs a line for each
+// update.
+type progress struct {
+	w     io.Writer
+	tty   bool
+	what  string
+	done  int
+	total int
+}
+
+// newProgress creates a progress reporting on stderr about total items,
+// described by what (e.g. "Embedded").
+func newProgress(what string, total int) *progress {
+	fd := os.Stderr.Fd()
+	return &progress{
+		w:     os.Stderr,
+		tty:   isatty.IsTerminal(fd) || isatty.IsCygwinTerminal(fd),
+		what:  what,
+		total: total,
+	}
+}
+
+// add records that n more items were processed, and reports the progress.
+func (p *progress) add(n int) {
+	p.done += n
+	if !p.tty {
+		log.Printf("%s %d / %d", p.what, p.done, p.total)
+		return
+	}
+
+	filled := progressBarWidth
+	if p.total > 0 {
+		filled = progressBarWidth * p.done / p.total
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	fmt.Fprintf(p.w, "\r%s [%s] %d / %d", p.what, bar, p.done, p.total)
+	if p.done >= p.total {
+		fmt.Fprintln(p.w)
+	}
+}
@@ -0,0 +1,24 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProgressBar(t *testing.T) {
+	var sb strings.Builder
+	p := &progress{w: &sb, tty: true, what: "Embedded", total: 4}
+
+	p.add(1)
+	want := "\rEmbedded [=======                       ] 1 / 4"
+	if got := sb.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	sb.Reset()
+	p.add(3)
+	want = "\rEmbedded [==============================] 4 / 4\n"
+	if got := sb.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,235 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+// batchResult is the output of prompt --batch for a single prompt, emitted as
+// a line of JSON.
+type batchResult struct {
+	Prompt   string `json:"prompt"`
+	Response string `json:"response,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// batchNDJSONResult is the output of prompt --batch --format ndjson for a
+// single prompt. Index is the line number of the prompt in the input, since
+// the results are emitted in the order the prompts finish.
+type batchNDJSONResult struct {
+	Index  int     `json:"index"`
+	Input  string  `json:"input"`
+	Output string  `json:"output"`
+	Error  *string `json:"error"`
+}
+
+// batchPrompt is a prompt read by readBatchPrompts, with the 1-based number of
+// its line in the input.
+type batchPrompt struct {
+	line int
+	text string
+}
+
+// runPromptBatch implements prompt --batch: it reads prompts from stdin, one
+// per line, sends each to the model and writes a batchResult for each, in
+// the order of the prompts, or a batchNDJSONResult for each as soon as it's
+// done with --format ndjson. Prompts that fail are reported in their results;
+// the returned error only says how many failed.
+func runPromptBatch(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return &usageError{errors.New("--batch reads the prompts from stdin and doesn't take prompt arguments")}
+	}
+	concurrency := mustGetIntFlag(cmd, "concurrency")
+	if concurrency < 1 {
+		return &usageError{fmt.Errorf("--concurrency must be positive, got %v", concurrency)}
+	}
+	format := mustGetStringFlag(cmd, "format")
+
+	prompts, err := readBatchPrompts(cmd.InOrStdin())
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := newCommandContext()
+	defer stop()
+	client, err := newClient(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	modelName := mustGetStringFlag(cmd, "model")
+	model := client.GenerativeModel(modelName)
+	if err := configureModel(cmd, model); err != nil {
+		return err
+	}
+	if err := configureResponse(cmd, model); err != nil {
+		return err
+	}
+	applyCachedContent(cmd, model)
+
+	w := cmd.OutOrStdout()
+	if outPath := mustGetStringFlag(cmd, "output"); outPath != "" {
+		f, err := os.Create(outPath)
+		if err != nil {
+			return fmt.Errorf("error creating output file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if format == "ndjson" {
+		return sendBatchPromptsNDJSON(ctx, cmd, model, modelName, prompts, concurrency, w)
+	}
+
+	// Each prompt's result is only written once the results of all the
+	// prompts before it were, so the output is in the order of the input even
+	// though prompts finish out of order. The results are written by a
+	// goroutine of their own, since g.Go blocks while concurrency prompts are
+	// in flight; if writing fails, the prompts that are left are canceled.
+	results := make([]batchResult, len(prompts))
+	done := make([]chan struct{}, len(prompts))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	numFailed := 0
+	writeErr := make(chan error, 1)
+	go func() {
+		enc := json.NewEncoder(w)
+		for i := range results {
+			<-done[i]
+			if results[i].Error != "" {
+				numFailed++
+			}
+			if err := enc.Encode(results[i]); err != nil {
+				cancel()
+				writeErr <- err
+				return
+			}
+		}
+		writeErr <- nil
+	}()
+
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for i, prompt := range prompts {
+		g.Go(func() error {
+			defer close(done[i])
+			results[i] = batchResult{Prompt: prompt.text}
+			text, err := sendBatchPrompt(ctx, cmd, model, modelName, prompt.text)
+			if err != nil {
+				results[i].Error = err.Error()
+			} else {
+				results[i].Response = text
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	if err := <-writeErr; err != nil {
+		return err
+	}
+	if numFailed > 0 {
+		return fmt.Errorf("%d of %d prompts failed", numFailed, len(prompts))
+	}
+	return nil
+}
+
+// sendBatchPromptsNDJSON sends prompts to model, up to concurrency at a time,
+// and writes a batchNDJSONResult for each to w as soon as it's done.
+func sendBatchPromptsNDJSON(ctx context.Context, cmd *cobra.Command, model *genai.GenerativeModel, modelName string, prompts []batchPrompt, concurrency int, w io.Writer) error {
+	// mu guards the writes to enc and numFailed.
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	numFailed := 0
+
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for _, prompt := range prompts {
+		g.Go(func() error {
+			result := batchNDJSONResult{Index: prompt.line, Input: prompt.text}
+			text, err := sendBatchPrompt(ctx, cmd, model, modelName, prompt.text)
+			if err != nil {
+				msg := err.Error()
+				result.Error = &msg
+			} else {
+				result.Output = text
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				numFailed++
+			}
+			return enc.Encode(result)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	if numFailed > 0 {
+		return fmt.Errorf("%d of %d prompts failed", numFailed, len(prompts))
+	}
+	return nil
+}
+
+// readBatchPrompts reads prompts from r, one per line; blank lines are
+// skipped.
+func readBatchPrompts(r io.Reader) ([]batchPrompt, error) {
+	var prompts []batchPrompt
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if prompt := strings.TrimSpace(scanner.Text()); prompt != "" {
+			prompts = append(prompts, batchPrompt{line: line, text: prompt})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading prompts from stdin: %w", err)
+	}
+	return prompts, nil
+}
+
+// sendBatchPrompt sends prompt to model, wrapped with the --prompt-prefix
+// and --prompt-suffix of cmd and within its --timeout, and returns the text
+// of the response.
+func sendBatchPrompt(ctx context.Context, cmd *cobra.Command, model *genai.GenerativeModel, modelName string, prompt string) (string, error) {
+	ctx, cancel := withRequestTimeout(ctx, cmd)
+	defer cancel()
+
+	parts := wrapPromptText([]genai.Part{genai.Text(prompt)}, mustGetStringFlag(cmd, "prompt-prefix"), mustGetStringFlag(cmd, "prompt-suffix"))
+	logRequest(model, modelName, len(parts))
+	start := time.Now()
+	resp, err := model.GenerateContent(ctx, parts...)
+	logResponse(start)
+	if err != nil {
+		return "", requestError(ctx, cmd, err)
+	}
+	if len(resp.Candidates) < 1 || resp.Candidates[0].Content == nil {
+		return "", errors.New("empty response from model")
+	}
+	c := resp.Candidates[0]
+	if err := finishReasonError(c); err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, part := range c.Content.Parts {
+		printPart(&sb, part, nil)
+	}
+	return sb.String(), nil
+}
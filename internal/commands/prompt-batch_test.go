@@ -0,0 +1,132 @@
+package commands
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadBatchPrompts(t *testing.T) {
+	got, err := readBatchPrompts(strings.NewReader("first prompt\n\n  second prompt  \r\nthird"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []batchPrompt{{1, "first prompt"}, {3, "second prompt"}, {4, "third"}}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(batchPrompt{})); diff != "" {
+		t.Errorf("prompts mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPromptBatch(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeBackendHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(string(body), "fail") {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error": {"code": 400, "message": "bad prompt", "status": "INVALID_ARGUMENT"}}`)
+			return
+		}
+		// The first prompt finishes last.
+		if strings.Contains(string(body), "first") {
+			time.Sleep(50 * time.Millisecond)
+		}
+		io.WriteString(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "ok"}]}, "finishReason": 1}]}`)
+	}))
+
+	rootCmd.SetIn(strings.NewReader("first\nplease fail\nthird\n"))
+	defer rootCmd.SetIn(nil)
+	out, err := executeCommandErr("prompt", "--batch", "--concurrency", "3")
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 prompts failed") {
+		t.Errorf("got error %v, want 1 of 3 prompts failed", err)
+	}
+
+	var results []batchResult
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var r batchResult
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		if r.Error != "" {
+			if !strings.Contains(r.Error, "bad prompt") {
+				t.Errorf("got error %q, want the API's error", r.Error)
+			}
+			r.Error = "failed"
+		}
+		results = append(results, r)
+	}
+	want := []batchResult{
+		{Prompt: "first", Response: "ok"},
+		{Prompt: "please fail", Error: "failed"},
+		{Prompt: "third", Response: "ok"},
+	}
+	if diff := cmp.Diff(want, results); diff != "" {
+		t.Errorf("results mismatch (-want +got):\n%s", diff)
+	}
+
+	// Flags that print more than the response can't be used with --batch.
+	for _, flag := range []string{"--echo", "--usage", "--show-safety", "--output-dir=out"} {
+		_, err := executeCommandErr("prompt", "--batch", flag)
+		if err == nil || !strings.Contains(err.Error(), "none of the others can be") {
+			t.Errorf("%s: got error %v, want the flags to be mutually exclusive", flag, err)
+		}
+	}
+}
+
+func TestPromptBatchNDJSON(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeBackendHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(string(body), "fail") {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error": {"code": 400, "message": "bad prompt", "status": "INVALID_ARGUMENT"}}`)
+			return
+		}
+		io.WriteString(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "ok"}]}, "finishReason": 1}]}`)
+	}))
+
+	rootCmd.SetIn(strings.NewReader("first\n\nplease fail\nthird\n"))
+	defer rootCmd.SetIn(nil)
+	out, err := executeCommandErr("prompt", "--batch", "--format", "ndjson", "--concurrency", "3")
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 prompts failed") {
+		t.Errorf("got error %v, want 1 of 3 prompts failed", err)
+	}
+
+	var results []batchNDJSONResult
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var r batchNDJSONResult
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		if r.Error != nil {
+			if !strings.Contains(*r.Error, "bad prompt") {
+				t.Errorf("got error %q, want the API's error", *r.Error)
+			}
+			r.Error = nil
+		} else if !strings.Contains(line, `"error":null`) {
+			t.Errorf("%q: want a null error", line)
+		}
+		results = append(results, r)
+	}
+	// The results are emitted as the prompts finish; sort them for comparing.
+	slices.SortFunc(results, func(a, b batchNDJSONResult) int { return a.Index - b.Index })
+	want := []batchNDJSONResult{
+		{Index: 1, Input: "first", Output: "ok"},
+		{Index: 3, Input: "please fail"},
+		{Index: 4, Input: "third", Output: "ok"},
+	}
+	if diff := cmp.Diff(want, results); diff != "" {
+		t.Errorf("results mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := executeCommandErr("prompt", "--format", "ndjson", "hi"); err == nil || !strings.Contains(err.Error(), "only supported with --batch") {
+		t.Errorf("got error %v, want --format is only supported with --batch", err)
+	}
+}
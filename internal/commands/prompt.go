@@ -1,19 +1,27 @@
 package commands
 
 import (
+	"bufio"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
-	"strconv"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/google/generative-ai-go/genai"
+	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
 	"google.golang.org/api/iterator"
 )
@@ -21,10 +29,14 @@ import (
 var promptCmd = &cobra.Command{
 	Use:     "prompt <prompt or '-'>...",
 	Aliases: []string{"p", "ask"},
-	Args:    cobra.MinimumNArgs(1),
+	Args:    cobra.ArbitraryArgs,
 	Short:   "Send a prompt to a Gemini model",
 	Long:    strings.TrimSpace(promptUsage),
-	Run:     runPromptCmd,
+	PreRunE: preRunModel(map[string][]string{
+		"stream-retry": streamRetryPolicies,
+		"format":       {"jsonl", "ndjson"},
+	}),
+	RunE: runPromptCmd,
 }
 
 var promptUsage = `
@@ -32,144 +44,759 @@ Send a prompt to the LLM. The prompt can be provided as a sequence of parts,
 each one a command-line argument.
 
 The arguments are sent as a sequence to the model in the order provided.
-If --system is provided, it's prepended to the other arguments. An argument
-can be some quoted text, a name of an image file on the local filesystem or
-a URL pointing directly to an image file online. A special argument with
+If --system is provided, it's sent to the model as a system instruction,
+separately from the prompt. --system and --system-file (which reads the
+instruction from a file) can be repeated and combined; the instruction then
+has a part for each of them, in the order given. An argument
+can be some quoted text, a name of an image or PDF file on the local filesystem
+or a URL pointing directly to an image or PDF file online (downloads are
+limited by --max-download-bytes and --download-timeout). An image (or other
+file) can also be given inline as a base64 data URL, like
+'data:image/png;base64,iVBORw0K...'; its MIME type has to be one the models
+accept. A special argument with
 the value '-' instructs the tool to read this prompt part from standard input.
-It can only appear once in a single invocation.
+It can only appear once in a single invocation; without any arguments, a
+prompt piped to standard input is read. An argument of the form
+'@path' is replaced by the text of the file at path; unlike a plain file name,
+the file is always sent as text, whatever its type.
 
 If you're providing multi-modal prompts (e.g. with images), make sure to
 select an appropriate model like gemini-pro-vision
 (see https://ai.google.dev/models/gemini for a list of model names).
+
+With --json, the full response structure (candidates with their finish reason
+and safety ratings, prompt feedback and token usage) is emitted as JSON instead
+of the response text. When streaming, each chunk is emitted as a separate JSON
+object on its own line (JSON Lines).
+
+With --candidates N, the model is asked for N alternative responses. Without
+--json, they are printed one after another, each preceded by a
+"--- candidate N ---" line, or separated by the line set with
+--candidate-separator; this needs --no-stream.
+
+The parts of a response (e.g. text around the result of code execution) are
+printed on lines of their own; --parts-separator sets another string to write
+between them. Streamed parts are pieces of the same text, so by default
+nothing is written between them.
+
+With --response-mime-type application/json, the model is asked to respond with
+JSON, optionally following the JSON schema in the file passed to
+--response-schema. The response is checked to be valid JSON before it's
+printed, so it isn't streamed.
+
+With --echo, the text of the prompt (as assembled from the arguments, files,
+standard input or template) is printed before the response, after a
+"--- prompt ---" line and followed by a "--- response ---" line. Images and
+other files in the prompt are shown by their MIME type and size. With --json,
+the prompt is in the "prompt" field of the JSON object (of the first one, when
+streaming) instead.
+
+Parts of the response with binary data, like images generated by models that
+can create them, aren't written to the output; they're shown by their MIME
+type and size instead. With --output-dir <dir>, they're saved to files in the
+directory (response-1.png, response-2.png etc., skipping files that exist),
+and the path of each is printed to stderr. With --json, they're part of the
+JSON output.
+
+With --usage, the number of tokens used by the prompt and the response is
+printed to stderr once the response is done, e.g.
+"tokens: prompt=120 output=340 total=460".
+
+With --cached-content <name>, the content cached under that name by 'cache
+create' is sent along with the prompt, before it; --model has to be the model
+it was cached for. The tokens taken from the cache are listed as "cached=N" by
+--usage.
+
+With --show-safety, the safety ratings of the response (the probability of
+each harm category) are printed to stderr once it's done, e.g.
+"safety: HARASSMENT=NEGLIGIBLE DANGEROUS_CONTENT=LOW". If the prompt or the
+response was blocked, the ratings that caused it are printed. With --json,
+the ratings are part of the JSON output instead.
+
+If a streamed response fails midway because of a transient error (e.g. the
+connection dropped or the service is overloaded), --stream-retry restart
+sends the request again and streams the new response, and --stream-retry
+fallback sends it again without streaming. The retried response continues
+where the printed output stopped, rather than being printed again from the
+start; if it doesn't match the output so far, it's printed in full on a new
+line. The retry happens once, within the same --timeout.
+
+With --cache-responses, responses are stored in a local cache
+(~/.config/gemini-cli/response-cache.db) by a hash of the model, its
+configuration and the prompt; sending the same request again within
+--cache-ttl prints the stored response instead of calling the API, which
+saves time and quota while iterating on a prompt. Responses are cached only if
+they finished normally, and aren't streamed. 'cache clear' empties the cache.
+
+--prompt-prefix and --prompt-suffix wrap the text of the prompt, as assembled
+from the arguments and standard input, between the given strings, each on a
+line of its own; this is a lighter alternative to templates for one-off
+framing. Images and other files that aren't text are left out of the wrapping.
+With --batch, each prompt is wrapped.
+
+Prompts are recorded in a history, listed by the history command; --last sends
+the last one again.
+
+With --session <file>, the prompt is sent as the next message of the chat
+session saved in the file, so the model sees the earlier turns (as in the chat
+command); the reply is printed and the session, with the new turn, is saved
+back to the file. A file that doesn't exist starts a new session. This allows
+multi-turn conversations from scripts, one prompt invocation per turn.
+
+With --batch, the prompts are read from standard input, one per line, and sent
+to the model one by one (up to --concurrency at a time). The output has a JSON
+object for each prompt, on its own line and in the order of the input, with
+the prompt and either its "response" or an "error". Batch prompts aren't
+recorded in the history, and --batch can't be combined with --echo, --usage,
+--show-safety or --output-dir.
+
+With --batch --format ndjson, each prompt's object is instead printed as soon
+as the prompt is done, so the objects can be out of order; each has the
+"index" of the prompt (its line number in the input), the "input", the
+"output", and an "error" that's null unless the prompt failed:
+
+  {"index":3,"input":"...","output":"...","error":null}
 `
 
 func init() {
 	rootCmd.AddCommand(promptCmd)
 
-	promptCmd.Flags().StringP("system", "s", "", "set a system prompt")
-	promptCmd.Flags().Bool("stream", true, "stream the response from the model")
+	addGenerateFlags(promptCmd)
+	addModelFlags(promptCmd)
+	promptCmd.Flags().Bool("last", false, "send the last prompt in the history again (see the history command)")
+	promptCmd.Flags().String("prompt-prefix", "", "text to put before the text of the prompt, on a line of its own")
+	promptCmd.Flags().String("prompt-suffix", "", "text to put after the text of the prompt, on a line of its own")
+	promptCmd.Flags().String("session", "", "continue the chat session saved in this file (created if it doesn't exist), and save the new turn to it")
+	promptCmd.Flags().Bool("batch", false, "read prompts from stdin, one per line, and emit each with its response as JSON Lines")
+	promptCmd.Flags().Int("concurrency", 1, "with --batch, the maximal number of prompts to send in parallel")
+	promptCmd.Flags().String("format", "jsonl", `with --batch, the output format: "jsonl" (results in the order of the input) or "ndjson" (results with their line numbers, as they finish)`)
+	for _, flag := range []string{"last", "json", "candidates", "cache-responses", "echo", "usage", "show-safety", "output-dir"} {
+		promptCmd.MarkFlagsMutuallyExclusive("batch", flag)
+	}
+	for _, flag := range []string{"batch", "json", "candidates", "candidate-separator", "output", "response-mime-type", "stream-retry", "cache-responses"} {
+		promptCmd.MarkFlagsMutuallyExclusive("session", flag)
+	}
+}
+
+// addGenerateFlags adds the flags used by generateContent to cmd.
+func addGenerateFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("stream", true, "stream the response from the model")
+	cmd.Flags().Bool("no-stream", false, "don't stream the response; same as --stream=false")
+	cmd.MarkFlagsMutuallyExclusive("stream", "no-stream")
+	cmd.Flags().Bool("json", false, "emit the full model response as JSON (one object per chunk when streaming)")
+	cmd.Flags().StringP("output", "o", "", "write the response to this file instead of stdout")
+	cmd.Flags().String("output-dir", "", "save parts of the response with binary data (e.g. images) to files in this directory")
+	cmd.Flags().Int32("candidates", 1, "number of response candidates to request from the model")
+	cmd.Flags().String("response-mime-type", "", "MIME type of the response, e.g. application/json for JSON output")
+	cmd.Flags().String("response-schema", "", "path to a JSON schema file the response must follow; needs --response-mime-type application/json")
+	cmd.Flags().String("cached-content", "", "name of cached content (see the cache command) to send along with the prompt; needs the --model it was created for")
+	cmd.Flags().Bool("echo", false, "print the prompt before the response (in a \"prompt\" field with --json)")
+	cmd.Flags().Bool("usage", false, "print the number of tokens used by the request to stderr")
+	cmd.Flags().Bool("show-safety", false, "print the safety ratings of the response to stderr")
+	addDownloadFlags(cmd)
+	cmd.Flags().Bool("cache-responses", false, "return a stored response for a request that was sent before, and store new responses (see 'cache clear')")
+	cmd.Flags().Duration("cache-ttl", 24*time.Hour, "with --cache-responses, how long stored responses are used; 0 means forever")
+	cmd.Flags().String("stream-retry", "off", `what to do when a streamed response fails midway with a transient error: "off" (fail), "restart" (stream it again) or "fallback" (get it again without streaming)`)
+	addOutputFlags(cmd)
+}
 
-	// The temperature setting is a string because we want to set it only if
-	// the user provided it explicitly, keeping the model's default otherwise.
-	promptCmd.Flags().String("temp", "", "temperature setting for the model")
+// addDownloadFlags adds the flags that limit the downloads of URLs given in
+// the prompt (see downloadLimitsFromFlags) to cmd.
+func addDownloadFlags(cmd *cobra.Command) {
+	cmd.Flags().Int64("max-download-bytes", 20<<20, "maximal size of the content of a URL given in the prompt; 0 means no limit")
+	cmd.Flags().Duration("download-timeout", 30*time.Second, "timeout for downloading the content of a URL given in the prompt; 0 means no timeout")
 }
 
-func runPromptCmd(cmd *cobra.Command, args []string) {
-	// Build up parts of prompt.
+// addOutputFlags adds the flags that control how the text of responses is
+// printed (see newPartPrinter and candidateHeader) to cmd.
+func addOutputFlags(cmd *cobra.Command) {
+	cmd.Flags().String("parts-separator", "", `string to write between the parts of a response; by default nothing when streaming (parts are pieces of the text then) and a newline otherwise`)
+	cmd.Flags().String("candidate-separator", "", `with several candidates, a line to write between them instead of the "--- candidate N ---" lines before each`)
+}
+
+// newPartPrinter returns the partPrinter for the --parts-separator flag of
+// cmd, saving binary parts with saver. The default separator depends on
+// whether the response is streamed.
+func newPartPrinter(cmd *cobra.Command, stream bool, saver *blobSaver) *partPrinter {
+	sep := "\n"
+	if stream {
+		sep = ""
+	}
+	if cmd.Flags().Changed("parts-separator") {
+		sep = mustGetStringFlag(cmd, "parts-separator")
+	}
+	return &partPrinter{saver: saver, sep: sep}
+}
+
+func runPromptCmd(cmd *cobra.Command, args []string) error {
+	if mustGetBoolFlag(cmd, "batch") {
+		return runPromptBatch(cmd, args)
+	}
+	if cmd.Flags().Changed("format") {
+		return &usageError{errors.New("--format is only supported with --batch")}
+	}
+
+	historyPath, historyErr := historyFilePath()
+
 	var promptParts []genai.Part
+	if mustGetBoolFlag(cmd, "last") {
+		if len(args) > 0 {
+			return &usageError{errors.New("--last doesn't take prompt arguments")}
+		}
+		if historyErr != nil {
+			return historyErr
+		}
+		entries, err := readHistory(historyPath)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return errors.New("no prompts in the history")
+		}
+		last := entries[len(entries)-1]
+		if promptParts, err = last.promptParts(); err != nil {
+			return err
+		}
+		args = last.Args
+	} else if len(args) == 0 {
+		var err error
+		if args, err = defaultPromptArgs(cmd); err != nil {
+			return err
+		}
+	}
+
+	if promptParts == nil {
+		var err error
+		if promptParts, err = buildPromptParts(cmd, args); err != nil {
+			return err
+		}
+	}
+
+	// A prompt is recorded even if sending it fails, so it can be retried
+	// with --last.
+	if historyErr == nil {
+		historyErr = appendHistory(historyPath, newHistoryEntry(args, promptParts))
+	}
+	promptParts = wrapPromptText(promptParts, mustGetStringFlag(cmd, "prompt-prefix"), mustGetStringFlag(cmd, "prompt-suffix"))
+	if historyErr != nil {
+		log.Printf("WARNING: unable to record prompt in history: %v", historyErr)
+	}
+
+	if sessionPath := mustGetStringFlag(cmd, "session"); sessionPath != "" {
+		return sendSessionPrompt(cmd, sessionPath, promptParts)
+	}
+	return generateContent(cmd, promptParts)
+}
+
+// sendPrompt builds a prompt from args and sends it to the model.
+func sendPrompt(cmd *cobra.Command, args []string) error {
+	promptParts, err := buildPromptParts(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	return generateContent(cmd, promptParts)
+}
+
+// generateContent sends promptParts to the model configured by the flags of
+// cmd, and writes the response to stdout or to the file named by --output.
+func generateContent(cmd *cobra.Command, promptParts []genai.Part) (err error) {
+	ctx, stop := newCommandContext()
+	defer stop()
+	ctx, cancel := withRequestTimeout(ctx, cmd)
+	defer cancel()
+	client, err := newClient(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	model := client.GenerativeModel(mustGetStringFlag(cmd, "model"))
+
+	if err := configureModel(cmd, model); err != nil {
+		return err
+	}
+	applyCachedContent(cmd, model)
+
+	jsonOutput := mustGetBoolFlag(cmd, "json")
+	stream := mustGetBoolFlag(cmd, "stream") && !mustGetBoolFlag(cmd, "no-stream")
+
+	if cmd.Flags().Changed("candidates") {
+		numCandidates := mustGetInt32Flag(cmd, "candidates")
+		if numCandidates < 1 {
+			return &usageError{fmt.Errorf("--candidates must be positive, got %v", numCandidates)}
+		}
+		// Streamed text of several candidates would be interleaved, so only
+		// allow that with --json, which keeps the candidates apart.
+		if numCandidates > 1 && stream && !jsonOutput {
+			return &usageError{errors.New("--candidates greater than 1 requires --no-stream or --json")}
+		}
+		model.SetCandidateCount(numCandidates)
+	}
+
+	if err := configureResponse(cmd, model); err != nil {
+		return err
+	}
+
+	retryPolicy := mustGetStringFlag(cmd, "stream-retry")
+	if retryPolicy != "off" && jsonOutput {
+		return &usageError{errors.New("--stream-retry isn't supported with --json")}
+	}
+
+	// JSON responses are checked for validity before being printed, so they
+	// have to be received in full rather than streamed.
+	validateJSON := model.ResponseMIMEType == "application/json" && !jsonOutput
+	if validateJSON {
+		stream = false
+	}
+
+	var cache *responseCache
+	var cacheKey string
+	cacheTTL := mustGetDurationFlag(cmd, "cache-ttl")
+	if mustGetBoolFlag(cmd, "cache-responses") {
+		if cacheTTL < 0 {
+			return &usageError{fmt.Errorf("--cache-ttl can't be negative, got %v", cacheTTL)}
+		}
+		// Responses are stored once they're complete, so they aren't streamed.
+		stream = false
+		cachePath, err := responseCachePath()
+		if err != nil {
+			return err
+		}
+		if cache, err = openResponseCache(cachePath); err != nil {
+			return err
+		}
+		defer cache.Close()
+		if cacheKey, err = responseCacheKey(mustGetStringFlag(cmd, "model"), model, promptParts); err != nil {
+			return err
+		}
+	} else if cmd.Flags().Changed("cache-ttl") {
+		return &usageError{errors.New("--cache-ttl is only supported with --cache-responses")}
+	}
+
+	w := cmd.OutOrStdout()
+	if outPath := mustGetStringFlag(cmd, "output"); outPath != "" {
+		f, err := os.Create(outPath)
+		if err != nil {
+			return fmt.Errorf("error creating output file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	// Output is buffered for efficiency; streamResponse flushes it after each
+	// chunk, so streamed responses still show up as they arrive.
+	bw := bufio.NewWriter(w)
+	defer func() {
+		if flushErr := bw.Flush(); err == nil {
+			err = flushErr
+		}
+	}()
+
+	showUsage := mustGetBoolFlag(cmd, "usage")
+	// The JSON output has the safety ratings already.
+	showSafety := mustGetBoolFlag(cmd, "show-safety") && !jsonOutput
+	// With --quiet, the response isn't followed by a newline of our own.
+	newline := !mustGetBoolFlag(cmd, "quiet")
+	pp := newPartPrinter(cmd, stream, newBlobSaver(mustGetStringFlag(cmd, "output-dir")))
+	candidateSep := mustGetStringFlag(cmd, "candidate-separator")
+
+	// With --json, the prompt is echoed in the JSON of the response instead.
+	var echo string
+	if mustGetBoolFlag(cmd, "echo") {
+		echo = promptText(promptParts)
+		if !jsonOutput {
+			printEcho(bw, echo)
+		}
+	}
+
+	if estimatePromptTokens(promptParts) >= promptSizeCheckTokens {
+		warnIfPromptTooLong(ctx, model, promptParts)
+	}
+
+	logRequest(model, mustGetStringFlag(cmd, "model"), len(promptParts))
+	start := time.Now()
+	if stream {
+		var summary *streamSummary
+		if jsonOutput || retryPolicy == "off" {
+			summary, err = streamResponse(ctx, model, promptParts, bw, jsonOutput, newline, echo, pp)
+		} else {
+			summary, err = streamWithRetry(ctx, model, promptParts, bw, newline, retryPolicy, pp)
+		}
+		logResponse(start)
+		if showUsage {
+			printUsage(cmd.ErrOrStderr(), summary.usage)
+		}
+		if showSafety {
+			if !printBlockedSafetyRatings(cmd.ErrOrStderr(), err) {
+				printSafetyRatings(cmd.ErrOrStderr(), "safety", summary.safetyRatings)
+			}
+		}
+		return requestError(ctx, cmd, err)
+	}
+
+	var resp *genai.GenerateContentResponse
+	if cache != nil {
+		if resp, err = cache.get(cacheKey, cacheTTL); err != nil {
+			return err
+		}
+		if resp != nil {
+			verboseLog.Debug("using cached response", "key", cacheKey)
+		}
+	}
+	if resp == nil {
+		resp, err = model.GenerateContent(ctx, promptParts...)
+		logResponse(start)
+		if err != nil {
+			if showSafety {
+				printBlockedSafetyRatings(cmd.ErrOrStderr(), err)
+			}
+			return requestError(ctx, cmd, err)
+		}
+		if cache != nil && cacheableResponse(resp) {
+			if err := cache.put(cacheKey, resp); err != nil {
+				log.Printf("WARNING: %v", err)
+			}
+		}
+	}
+	if showUsage {
+		defer printUsage(cmd.ErrOrStderr(), resp.UsageMetadata)
+	}
+	if showSafety {
+		defer printCandidatesSafetyRatings(cmd.ErrOrStderr(), resp.Candidates)
+	}
+	if jsonOutput {
+		return emitResponseJSON(bw, resp, echo)
+	}
+	if len(resp.Candidates) < 1 {
+		fmt.Fprintln(bw, "<empty response from model>")
+	}
+	var finishErr error
+	for i, c := range resp.Candidates {
+		fmt.Fprint(bw, candidateHeader(i, len(resp.Candidates), candidateSep))
+		if err := finishReasonError(c); err != nil && finishErr == nil {
+			finishErr = err
+		}
+		if c.Content == nil {
+			fmt.Fprintln(bw, "<empty response from model>")
+		} else if validateJSON {
+			// A response that didn't finish normally isn't complete JSON; the
+			// finish reason explains the problem better than a parse error.
+			if finishErr != nil {
+				return finishErr
+			}
+			var sb strings.Builder
+			for _, part := range c.Content.Parts {
+				fmt.Fprint(&sb, part)
+			}
+			if !json.Valid([]byte(sb.String())) {
+				return fmt.Errorf("model response isn't valid JSON: %s", sb.String())
+			}
+			fmt.Fprint(bw, sb.String())
+			if newline {
+				fmt.Fprintln(bw)
+			}
+		} else {
+			pp.reset()
+			for _, part := range c.Content.Parts {
+				if err := pp.print(bw, part); err != nil {
+					return err
+				}
+			}
+			if newline {
+				fmt.Fprintln(bw)
+			}
+		}
+	}
+	return finishErr
+}
 
-	if sysPrompt := mustGetStringFlag(cmd, "system"); sysPrompt != "" {
-		promptParts = append(promptParts, genai.Text(sysPrompt))
+// configureResponse sets the format of the responses of model from the
+// --response-mime-type and --response-schema flags of cmd.
+func configureResponse(cmd *cobra.Command, model *genai.GenerativeModel) error {
+	model.ResponseMIMEType = mustGetStringFlag(cmd, "response-mime-type")
+	if schemaPath := mustGetStringFlag(cmd, "response-schema"); schemaPath != "" {
+		if model.ResponseMIMEType != "application/json" {
+			return &usageError{errors.New("--response-schema requires --response-mime-type application/json")}
+		}
+		b, err := os.ReadFile(schemaPath)
+		if err != nil {
+			return fmt.Errorf("error reading response schema: %w", err)
+		}
+		if model.ResponseSchema, err = parseSchema(b); err != nil {
+			return fmt.Errorf("%s: %w", schemaPath, err)
+		}
 	}
+	return nil
+}
+
+// streamSummary has the metadata of a streamed response, which comes with its
+// chunks rather than with the text.
+type streamSummary struct {
+	// usage is the token usage of the request, sent with the last chunk (nil if
+	// none was received).
+	usage *genai.UsageMetadata
+
+	// safetyRatings are the safety ratings of the first candidate, from the
+	// last chunk that had any.
+	safetyRatings []*genai.SafetyRating
+}
+
+// streamResponse sends parts to model and writes the response to w as it's
+// streamed back: the text of the first candidate, followed by a newline if
+// newline is set, or each chunk as JSON if jsonOutput is set; the JSON of the
+// first chunk has echo as its prompt, if it isn't empty. w is flushed after
+// each chunk. The finish reason comes
+// with the last chunk; a response that didn't finish normally is reported
+// with an error once all of it was written. The metadata of the response is
+// returned in a streamSummary, which is never nil.
+func streamResponse(ctx context.Context, model *genai.GenerativeModel, parts []genai.Part, w *bufio.Writer, jsonOutput bool, newline bool, echo string, pp *partPrinter) (*streamSummary, error) {
+	var finishErr error
+	summary := &streamSummary{}
+	iter := model.GenerateContentStream(ctx, parts...)
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return summary, err
+		}
+		if resp.UsageMetadata != nil {
+			summary.usage = resp.UsageMetadata
+		}
+		if len(resp.Candidates) > 0 && len(resp.Candidates[0].SafetyRatings) > 0 {
+			summary.safetyRatings = resp.Candidates[0].SafetyRatings
+		}
+		if jsonOutput {
+			// The prompt is only echoed with the first chunk.
+			if err := emitResponseJSON(w, resp, echo); err != nil {
+				return summary, err
+			}
+			echo = ""
+			if err := w.Flush(); err != nil {
+				return summary, err
+			}
+			continue
+		}
+		if len(resp.Candidates) < 1 {
+			fmt.Fprintln(w, "<empty response from model>")
+		} else {
+			c := resp.Candidates[0]
+			if c.Content != nil {
+				for _, part := range c.Content.Parts {
+					if err := pp.print(w, part); err != nil {
+						return summary, err
+					}
+				}
+			} else {
+				fmt.Fprintln(w, "<empty response from model>")
+			}
+			if err := finishReasonError(c); err != nil {
+				finishErr = err
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return summary, err
+		}
+	}
+	if !jsonOutput && newline {
+		fmt.Fprintln(w)
+	}
+	return summary, finishErr
+}
+
+// buildPromptParts builds the prompt parts from the command-line arguments
+// args. Each argument is either some text, a path to a file, a URL, '-' (for
+// reading from standard input) or '@' followed by the path of a file to read
+// the text of the prompt from.
+func buildPromptParts(cmd *cobra.Command, args []string) ([]genai.Part, error) {
+	var promptParts []genai.Part
 
 	seenStdin := false
 	for _, arg := range args {
 		if arg == "-" {
 			if seenStdin {
-				log.Fatal("expect a single '-' in list of prompts")
+				return nil, errors.New("expect a single '-' in list of prompts")
 			}
 
 			b, err := io.ReadAll(cmd.InOrStdin())
 			if err != nil {
-				log.Fatal("error reading content from stdin:", err)
+				return nil, fmt.Errorf("error reading content from stdin: %w", err)
 			}
-			promptParts = append(promptParts, genai.Text(string(b)))
 			seenStdin = true
+			// An empty part would be rejected by the API; empty stdin (e.g. an
+			// empty pipe) is only an error if nothing else was given.
+			if len(strings.TrimSpace(string(b))) == 0 {
+				continue
+			}
+			promptParts = append(promptParts, genai.Text(string(b)))
+		} else if path, ok := promptFileArg(arg); ok {
+			text, err := readPromptFile(path)
+			if err != nil {
+				return nil, err
+			}
+			promptParts = append(promptParts, genai.Text(text))
+		} else if argIsDataURL(arg) {
+			part, err := getPartFromDataURL(arg)
+			if err != nil {
+				return nil, err
+			}
+			promptParts = append(promptParts, part)
 		} else if argLooksLikeURL(arg) {
-			part, err := getPartFromURL(arg)
+			part, err := getPartFromURL(arg, downloadLimitsFromFlags(cmd))
 			if err != nil {
-				log.Fatal(err)
+				return nil, err
 			}
 			promptParts = append(promptParts, part)
 		} else if argLooksLikeFilename(arg) {
 			part, err := getPartFromFile(arg)
 			if err != nil {
-				log.Fatal(err)
+				return nil, err
 			}
 			promptParts = append(promptParts, part)
 		} else {
 			promptParts = append(promptParts, genai.Text(arg))
 		}
 	}
+	if len(promptParts) == 0 && seenStdin {
+		return nil, errors.New("empty prompt: nothing was read from standard input")
+	}
+	return promptParts, nil
+}
 
-	ctx := context.Background()
-	client, err := newGenaiClient(ctx, cmd)
-	if err != nil {
-		log.Fatal(err)
+// wrapPromptText puts prefix before the text of the prompt parts, and suffix
+// after it, each separated from the text by a newline. They're added to the
+// first and the last text parts; other parts (e.g. images) are left as they
+// are. If there are no text parts, prefix and suffix are parts of their own,
+// before and after the others. Empty prefix and suffix aren't added.
+func wrapPromptText(parts []genai.Part, prefix string, suffix string) []genai.Part {
+	if prefix == "" && suffix == "" {
+		return parts
+	}
+	first, last := -1, -1
+	for i, part := range parts {
+		if _, ok := part.(genai.Text); ok {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
 	}
-	defer client.Close()
 
-	model := client.GenerativeModel(mustGetStringFlag(cmd, "model"))
+	wrapped := slices.Clone(parts)
+	if first < 0 {
+		if prefix != "" {
+			wrapped = slices.Insert(wrapped, 0, genai.Part(genai.Text(prefix)))
+		}
+		if suffix != "" {
+			wrapped = append(wrapped, genai.Text(suffix))
+		}
+		return wrapped
+	}
+	if prefix != "" {
+		wrapped[first] = genai.Text(prefix + "\n" + string(wrapped[first].(genai.Text)))
+	}
+	if suffix != "" {
+		wrapped[last] = genai.Text(string(wrapped[last].(genai.Text)) + "\n" + suffix)
+	}
+	return wrapped
+}
 
-	if tempValue := mustGetStringFlag(cmd, "temp"); tempValue != "" {
-		f, err := strconv.ParseFloat(tempValue, 32)
-		if err != nil {
-			log.Fatalf("problem parsing --temp value: %v", err)
+// promptSizeCheckTokens is the estimated number of tokens in a prompt from
+// which warnIfPromptTooLong checks it against the model's input token limit
+// before sending it. It's below the smallest limit of the Gemini models, and
+// keeps the extra requests of the check away from usual prompts.
+const promptSizeCheckTokens = 20000
+
+// estimatePromptTokens returns an approximation of the number of tokens in the
+// text parts of parts, with estimateTokens.
+func estimatePromptTokens(parts []genai.Part) int {
+	tokens := 0
+	for _, part := range parts {
+		if text, ok := part.(genai.Text); ok {
+			tokens += estimateTokens(string(text))
 		}
-		model.SetTemperature(float32(f))
 	}
+	return tokens
+}
 
-	model.SafetySettings = []*genai.SafetySetting{
-		{
-			Category:  genai.HarmCategoryDangerousContent,
-			Threshold: genai.HarmBlockNone,
-		},
-		{
-			Category:  genai.HarmCategoryHarassment,
-			Threshold: genai.HarmBlockNone,
-		},
-		{
-			Category:  genai.HarmCategoryHateSpeech,
-			Threshold: genai.HarmBlockNone,
-		},
-		{
-			Category:  genai.HarmCategorySexuallyExplicit,
-			Threshold: genai.HarmBlockNone,
-		},
+// warnIfPromptTooLong counts the tokens of parts with the API, and warns if
+// there are more than the input token limit of model. The prompt is sent
+// anyway; this only explains the error the API is going to return. If the
+// tokens or the limit can't be found out, there's no warning.
+func warnIfPromptTooLong(ctx context.Context, model *genai.GenerativeModel, parts []genai.Part) {
+	info, err := model.Info(ctx)
+	if err != nil || info.InputTokenLimit <= 0 {
+		return
+	}
+	resp, err := model.CountTokens(ctx, parts...)
+	if err != nil {
+		return
 	}
+	if resp.TotalTokens > info.InputTokenLimit {
+		log.Printf("WARNING: the prompt has %d tokens, more than the %d input tokens %s accepts; see 'gemini-cli models' for models with a larger limit", resp.TotalTokens, info.InputTokenLimit, strings.TrimPrefix(info.Name, "models/"))
+	}
+}
 
-	if stream := mustGetBoolFlag(cmd, "stream"); stream {
-		iter := model.GenerateContentStream(ctx, promptParts...)
-		for {
-			resp, err := iter.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				log.Fatal(err)
-			}
-			if len(resp.Candidates) < 1 {
-				fmt.Println("<empty response from model>")
-			} else {
-				c := resp.Candidates[0]
-				if c.Content != nil {
-					for _, part := range c.Content.Parts {
-						fmt.Print(part)
-					}
-				} else {
-					fmt.Println("<empty response from model>")
-				}
-			}
-		}
-		fmt.Println()
-	} else {
-		resp, err := model.GenerateContent(ctx, promptParts...)
-		if err != nil {
-			log.Fatal(err)
-		}
-		if len(resp.Candidates) < 1 {
-			fmt.Println("<empty response from model>")
-		} else {
-			c := resp.Candidates[0]
-			if c.Content != nil {
-				for _, part := range c.Content.Parts {
-					fmt.Println(part)
-				}
-			} else {
-				fmt.Println("<empty response from model>")
-			}
+// promptText returns the text of the prompt parts for --echo, one part per
+// line. Parts that aren't text (e.g. images) are shown by their MIME type and
+// size.
+func promptText(parts []genai.Part) string {
+	var lines []string
+	for _, part := range parts {
+		switch p := part.(type) {
+		case genai.Text:
+			lines = append(lines, string(p))
+		case genai.Blob:
+			lines = append(lines, fmt.Sprintf("<%s, %d bytes>", p.MIMEType, len(p.Data)))
+		default:
+			lines = append(lines, fmt.Sprint(p))
 		}
 	}
+	return strings.Join(lines, "\n")
+}
+
+// printEcho writes the prompt text for --echo to w, delimited from the
+// response that follows it.
+func printEcho(w io.Writer, prompt string) {
+	fmt.Fprintln(w, "--- prompt ---")
+	fmt.Fprintln(w, prompt)
+	fmt.Fprintln(w, "--- response ---")
+}
+
+// defaultPromptArgs returns the arguments to use for a prompt given without
+// any: "-", to read a prompt piped to standard input. If stdin is a terminal,
+// there's no prompt to read and an error is returned.
+func defaultPromptArgs(cmd *cobra.Command) ([]string, error) {
+	if stdinIsTerminal(cmd.InOrStdin()) {
+		return nil, errors.New("no prompt given; pass it as arguments, or pipe it to standard input")
+	}
+	return []string{"-"}, nil
+}
+
+// stdinIsTerminal reports whether r, the standard input of a command, is a
+// terminal rather than a pipe or a file. It's a variable so that tests can
+// fake a terminal.
+var stdinIsTerminal = func(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	return ok && (isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd()))
+}
+
+// promptFileArg says if the command-line argument arg has the form @path,
+// naming a file to read prompt text from, and returns the path if so.
+func promptFileArg(arg string) (string, bool) {
+	path, ok := strings.CutPrefix(arg, "@")
+	return path, ok && path != ""
+}
+
+// readPromptFile reads the text of a prompt from the file at path.
+func readPromptFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading prompt file: %w", err)
+	}
+	return string(b), nil
 }
 
 // argLooksLikeFilename says if command-line argument looks like a filename,
@@ -188,41 +815,188 @@ func argLooksLikeURL(arg string) bool {
 	return true
 }
 
+// blobMIMETypes lists the MIME types of binary data the models accept as
+// prompt parts. Text types (text/*) are sent as text parts instead.
+var blobMIMETypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/webp":      true,
+	"image/heic":      true,
+	"image/heif":      true,
+	"application/pdf": true,
+	"audio/wav":       true,
+	"audio/mp3":       true,
+	"audio/mpeg":      true,
+	"audio/aiff":      true,
+	"audio/aac":       true,
+	"audio/ogg":       true,
+	"audio/flac":      true,
+	"video/mp4":       true,
+	"video/mpeg":      true,
+	"video/quicktime": true,
+	"video/webm":      true,
+}
+
+// baseMIMEType strips parameters like "; charset=utf-8" from mimeType.
+func baseMIMEType(mimeType string) string {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	return strings.ToLower(strings.TrimSpace(mimeType))
+}
+
+func isSupportedMIMEType(mimeType string) bool {
+	return blobMIMETypes[mimeType] || strings.HasPrefix(mimeType, "text/")
+}
+
+// partFromData creates a prompt part from data. declaredType is the MIME type
+// that data is supposed to have (from a file extension or a Content-Type
+// header); if it's empty or not supported, the type is detected from the
+// contents of data instead. Data of unsupported types is an error.
+func partFromData(data []byte, declaredType string) (genai.Part, error) {
+	mimeType := baseMIMEType(declaredType)
+	if !isSupportedMIMEType(mimeType) {
+		mimeType = baseMIMEType(http.DetectContentType(data))
+	}
+
+	switch {
+	case strings.HasPrefix(mimeType, "text/"):
+		return genai.Text(string(data)), nil
+	case blobMIMETypes[mimeType]:
+		return genai.Blob{MIMEType: mimeType, Data: data}, nil
+	default:
+		if declaredType != "" {
+			return nil, fmt.Errorf("unsupported MIME type %v (detected as %v)", baseMIMEType(declaredType), mimeType)
+		}
+		return nil, fmt.Errorf("unsupported MIME type %v", mimeType)
+	}
+}
+
+// getPartFromFile reads the file at path into a prompt part. Its MIME type is
+// determined from the file extension, or from its contents if the extension
+// doesn't indicate a supported type.
 func getPartFromFile(path string) (genai.Part, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	ext := filepath.Ext(path)
-	switch strings.TrimSpace(ext) {
-	case ".jpg", ".jpeg":
-		return genai.ImageData("jpeg", b), nil
-	case ".png":
-		return genai.ImageData("png", b), nil
-	default:
-		// Otherwise treat file as text
-		return genai.Text(string(b)), err
+	part, err := partFromData(b, mime.TypeByExtension(filepath.Ext(path)))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
 	}
+	return part, nil
+}
+
+// argIsDataURL says if the command-line argument arg is a data URL
+// ("data:<MIME type>;base64,<data>").
+func argIsDataURL(arg string) bool {
+	return len(arg) >= 5 && strings.EqualFold(arg[:5], "data:")
 }
 
-func getPartFromURL(url string) (genai.Part, error) {
-	resp, err := http.Get(url)
+// getPartFromDataURL decodes the base64 data URL dataURL into a prompt part
+// with the MIME type declared in the URL, which has to be supported.
+func getPartFromDataURL(dataURL string) (genai.Part, error) {
+	header, payload, ok := strings.Cut(dataURL[len("data:"):], ",")
+	if !ok {
+		return nil, errors.New("invalid data URL: expect data:<MIME type>;base64,<data>")
+	}
+	mimeType, isBase64 := strings.CutSuffix(header, ";base64")
+	if !isBase64 {
+		return nil, errors.New("invalid data URL: only base64 data URLs are supported (data:<MIME type>;base64,<data>)")
+	}
+	mimeType = baseMIMEType(mimeType)
+	if mimeType == "" {
+		return nil, errors.New("invalid data URL: no MIME type")
+	}
+	if !isSupportedMIMEType(mimeType) {
+		return nil, fmt.Errorf("data URL: unsupported MIME type %v", mimeType)
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch image from url: %w", err)
+		return nil, fmt.Errorf("data URL: invalid base64 data: %w", err)
 	}
-	defer resp.Body.Close()
+	if len(data) == 0 {
+		return nil, errors.New("data URL: no data")
+	}
+	return partFromData(data, mimeType)
+}
+
+// downloadLimits limits the downloads of getPartFromURL.
+type downloadLimits struct {
+	// maxBytes is the maximal size of the downloaded content; 0 means no
+	// limit.
+	maxBytes int64
+
+	// timeout is the timeout for the whole download, including redirects and
+	// reading the content; 0 means no timeout.
+	timeout time.Duration
+}
+
+// downloadLimitsFromFlags returns the limits set with the flags added by
+// addDownloadFlags.
+func downloadLimitsFromFlags(cmd *cobra.Command) downloadLimits {
+	return downloadLimits{
+		maxBytes: mustGetInt64Flag(cmd, "max-download-bytes"),
+		timeout:  mustGetDurationFlag(cmd, "download-timeout"),
+	}
+}
+
+// maxDownloadRedirects is the maximal number of redirects getPartFromURL
+// follows.
+const maxDownloadRedirects = 5
 
-	urlData, err := io.ReadAll(resp.Body)
+// getPartFromURL fetches url into a prompt part, within limits. Its MIME type
+// is taken from the Content-Type header of the response, or detected from the
+// contents if the header doesn't indicate a supported type.
+func getPartFromURL(url string, limits downloadLimits) (genai.Part, error) {
+	client := &http.Client{
+		Timeout: limits.timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxDownloadRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxDownloadRedirects)
+			}
+			return nil
+		},
+	}
+	timeoutError := func(err error) error {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() && limits.timeout > 0 {
+			return fmt.Errorf("download of %v timed out after %v (see --download-timeout)", url, limits.timeout)
+		}
+		return err
+	}
+
+	resp, err := client.Get(url)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read image bytes: %w", err)
+		return nil, fmt.Errorf("failed to fetch data from url: %w", timeoutError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch data from url: %v", resp.Status)
 	}
 
-	mimeType := resp.Header.Get("Content-Type")
-	parts := strings.Split(mimeType, "/")
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid mime type %v", mimeType)
+	tooLarge := func() error {
+		return fmt.Errorf("content of %v is larger than %d bytes (see --max-download-bytes)", url, limits.maxBytes)
+	}
+	var body io.Reader = resp.Body
+	if limits.maxBytes > 0 {
+		if resp.ContentLength > limits.maxBytes {
+			return nil, tooLarge()
+		}
+		// Read one byte more than allowed to find out if there's more.
+		body = io.LimitReader(resp.Body, limits.maxBytes+1)
+	}
+	urlData, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data bytes: %w", timeoutError(err))
+	}
+	if limits.maxBytes > 0 && int64(len(urlData)) > limits.maxBytes {
+		return nil, tooLarge()
 	}
 
-	return genai.ImageData(parts[1], urlData), nil
+	part, err := partFromData(urlData, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", url, err)
+	}
+	return part, nil
 }
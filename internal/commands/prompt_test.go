@@ -0,0 +1,440 @@
+package commands
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/cobra"
+)
+
+// Minimal file contents that http.DetectContentType recognizes.
+var (
+	pngData  = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	jpegData = []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00")
+	pdfData  = []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	textData = []byte("the capital of Freedonia is Marxville\n")
+	binData  = []byte("\x00\x01\x02\x03\x04\x05")
+)
+
+func TestGetPartFromFile(t *testing.T) {
+	dir := t.TempDir()
+
+	var tests = []struct {
+		name string
+		data []byte
+		want genai.Part
+	}{
+		{"image.png", pngData, genai.Blob{MIMEType: "image/png", Data: pngData}},
+		{"image.jpg", jpegData, genai.Blob{MIMEType: "image/jpeg", Data: jpegData}},
+		{"image.jpeg", jpegData, genai.Blob{MIMEType: "image/jpeg", Data: jpegData}},
+		{"doc.pdf", pdfData, genai.Blob{MIMEType: "application/pdf", Data: pdfData}},
+		{"notes.txt", textData, genai.Text(textData)},
+		// Without a known extension, the type is detected from the contents.
+		{"notes", textData, genai.Text(textData)},
+		{"image", pngData, genai.Blob{MIMEType: "image/png", Data: pngData}},
+		{"doc.unknownext", pdfData, genai.Blob{MIMEType: "application/pdf", Data: pdfData}},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(path, tt.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := getPartFromFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%s: part mismatch (-want +got):\n%s", tt.name, diff)
+		}
+	}
+
+	binPath := filepath.Join(dir, "data.bin")
+	if err := os.WriteFile(binPath, binData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getPartFromFile(binPath); err == nil {
+		t.Errorf("got no error for binary file, want error")
+	}
+}
+
+func TestGetPartFromDataURL(t *testing.T) {
+	encode := base64.StdEncoding.EncodeToString
+	var tests = []struct {
+		url  string
+		want genai.Part
+	}{
+		{"data:image/png;base64," + encode(pngData), genai.Blob{MIMEType: "image/png", Data: pngData}},
+		{"DATA:Application/PDF;base64," + encode(pdfData), genai.Blob{MIMEType: "application/pdf", Data: pdfData}},
+		// The declared type is used even if the data looks like another one.
+		{"data:image/jpeg;base64," + encode(pngData), genai.Blob{MIMEType: "image/jpeg", Data: pngData}},
+		{"data:text/plain;charset=utf-8;base64," + encode(textData), genai.Text(textData)},
+	}
+	for _, tt := range tests {
+		if !argIsDataURL(tt.url) {
+			t.Errorf("%.30s: not recognized as a data URL", tt.url)
+		}
+		got, err := getPartFromDataURL(tt.url)
+		if err != nil {
+			t.Fatalf("%.30s: %v", tt.url, err)
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%.30s: part mismatch (-want +got):\n%s", tt.url, diff)
+		}
+	}
+
+	for _, bad := range []string{
+		"data:image/png;base64",
+		"data:image/png," + encode(pngData),
+		"data:;base64," + encode(pngData),
+		"data:application/zip;base64," + encode(binData),
+		"data:image/png;base64,not base64!",
+		"data:image/png;base64,",
+	} {
+		if _, err := getPartFromDataURL(bad); err == nil {
+			t.Errorf("%q: got no error, want error", bad)
+		}
+	}
+
+	cmd := &cobra.Command{}
+	got, err := buildPromptParts(cmd, []string{"describe this", "data:image/png;base64," + encode(pngData)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []genai.Part{genai.Text("describe this"), genai.Blob{MIMEType: "image/png", Data: pngData}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parts mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGetPartFromURL(t *testing.T) {
+	files := map[string]struct {
+		contentType string
+		data        []byte
+	}{
+		"/image.png": {"image/png", pngData},
+		"/photo":     {"image/jpeg", jpegData},
+		"/doc":       {"application/pdf", pdfData},
+		"/text":      {"text/plain; charset=utf-8", textData},
+		"/sniffed":   {"application/octet-stream", pdfData},
+		"/binary":    {"application/octet-stream", binData},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", f.contentType)
+		w.Write(f.data)
+	}))
+	defer srv.Close()
+
+	var tests = []struct {
+		path string
+		want genai.Part
+	}{
+		{"/image.png", genai.Blob{MIMEType: "image/png", Data: pngData}},
+		{"/photo", genai.Blob{MIMEType: "image/jpeg", Data: jpegData}},
+		{"/doc", genai.Blob{MIMEType: "application/pdf", Data: pdfData}},
+		{"/text", genai.Text(textData)},
+		{"/sniffed", genai.Blob{MIMEType: "application/pdf", Data: pdfData}},
+	}
+
+	for _, tt := range tests {
+		got, err := getPartFromURL(srv.URL+tt.path, downloadLimits{})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%s: part mismatch (-want +got):\n%s", tt.path, diff)
+		}
+	}
+
+	for _, path := range []string{"/binary", "/missing"} {
+		if _, err := getPartFromURL(srv.URL+path, downloadLimits{}); err == nil {
+			t.Errorf("%s: got no error, want error", path)
+		}
+	}
+}
+
+func TestGetPartFromURLLimits(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(textData)
+	})
+	// Without a Content-Length, the size is only known by reading the content.
+	mux.HandleFunc("/chunked", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		for range 4 {
+			w.Write(textData)
+			w.(http.Flusher).Flush()
+		}
+	})
+	// /redirect/N redirects N times before getting to /text.
+	mux.HandleFunc("/redirect/{n}", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.PathValue("n"))
+		if n == 0 {
+			http.Redirect(w, r, "/text", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/redirect/%d", n-1), http.StatusFound)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var tests = []struct {
+		path    string
+		limits  downloadLimits
+		wantErr string
+	}{
+		{"/text", downloadLimits{maxBytes: int64(len(textData))}, ""},
+		{"/text", downloadLimits{maxBytes: int64(len(textData)) - 1}, "larger than"},
+		{"/chunked", downloadLimits{maxBytes: int64(len(textData)) * 4}, ""},
+		{"/chunked", downloadLimits{maxBytes: int64(len(textData)) * 2}, "larger than"},
+		{"/redirect/3", downloadLimits{}, ""},
+		{"/redirect/10", downloadLimits{}, "redirects"},
+		{"/slow", downloadLimits{timeout: 50 * time.Millisecond}, "timed out"},
+	}
+	for _, tt := range tests {
+		_, err := getPartFromURL(srv.URL+tt.path, tt.limits)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s %+v: got error %v", tt.path, tt.limits, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s %+v: got error %v, want it to contain %q", tt.path, tt.limits, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCountTokURL(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(pngData)
+	}))
+	defer srv.Close()
+	var gotBody string
+	fakeBackend(t, func(path string, body string) string {
+		gotBody = body
+		return `{"totalTokens": 258}`
+	})
+
+	if got, want := executeCommand(t, "counttok", srv.URL+"/a.png"), "258\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+	if !strings.Contains(gotBody, base64.StdEncoding.EncodeToString(pngData)) {
+		t.Errorf("got request %s, want it to contain the downloaded image", gotBody)
+	}
+
+	_, err := executeCommandErr("counttok", "--max-download-bytes", "4", srv.URL+"/a.png")
+	if err == nil || !strings.Contains(err.Error(), "larger than 4 bytes") {
+		t.Errorf("got error %v, want the download to be limited", err)
+	}
+}
+
+func TestBuildPromptPartsPromptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prompt.txt")
+	if err := os.WriteFile(path, []byte("summarize the following"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := &cobra.Command{}
+	cmd.SetIn(strings.NewReader("text from stdin"))
+	got, err := buildPromptParts(cmd, []string{"@" + path, "-", "be brief", "@"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []genai.Part{
+		genai.Text("summarize the following"),
+		genai.Text("text from stdin"),
+		genai.Text("be brief"),
+		genai.Text("@"),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parts mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := buildPromptParts(cmd, []string{"@" + filepath.Join(t.TempDir(), "missing.txt")}); err == nil {
+		t.Errorf("got no error for missing prompt file, want error")
+	}
+}
+
+func TestBuildPromptPartsEmptyStdin(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.SetIn(strings.NewReader(" \n"))
+	got, err := buildPromptParts(cmd, []string{"-", "be brief"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]genai.Part{genai.Text("be brief")}, got); diff != "" {
+		t.Errorf("parts mismatch (-want +got):\n%s", diff)
+	}
+
+	cmd.SetIn(strings.NewReader(""))
+	if _, err := buildPromptParts(cmd, []string{"-"}); err == nil || !strings.Contains(err.Error(), "empty prompt") {
+		t.Errorf("got error %v, want empty prompt error", err)
+	}
+}
+
+func TestPromptFromStdin(t *testing.T) {
+	defer func(f func(io.Reader) bool) { stdinIsTerminal = f }(stdinIsTerminal)
+
+	// On a terminal, there's no prompt to read without arguments.
+	stdinIsTerminal = func(io.Reader) bool { return true }
+	cmd := &cobra.Command{}
+	cmd.SetIn(strings.NewReader("ignored"))
+	if _, err := defaultPromptArgs(cmd); err == nil || !strings.Contains(err.Error(), "no prompt given") {
+		t.Errorf("terminal: got error %v, want no prompt error", err)
+	}
+
+	stdinIsTerminal = func(io.Reader) bool { return false }
+	var tests = []struct {
+		name    string
+		stdin   string
+		want    []genai.Part
+		wantErr string
+	}{
+		{"piped", "what genus do cats belong to?\n", []genai.Part{genai.Text("what genus do cats belong to?\n")}, ""},
+		{"empty pipe", "", nil, "empty prompt"},
+	}
+	for _, tt := range tests {
+		cmd := &cobra.Command{}
+		cmd.SetIn(strings.NewReader(tt.stdin))
+		args, err := defaultPromptArgs(cmd)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, err := buildPromptParts(cmd, args)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%s: parts mismatch (-want +got):\n%s", tt.name, diff)
+		}
+	}
+}
+
+func TestPromptText(t *testing.T) {
+	parts := []genai.Part{genai.Text("describe this:"), genai.Blob{MIMEType: "image/png", Data: pngData}, genai.Text("be brief")}
+	want := "describe this:\n<image/png, 16 bytes>\nbe brief"
+	if got := promptText(parts); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPromptEcho(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeBackend(t, func(path string, body string) string {
+		if !strings.HasSuffix(path, ":generateContent") {
+			t.Errorf("unexpected request %s: %s", path, body)
+			return `{}`
+		}
+		return `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Paris."}]}, "finishReason": 1}]}`
+	})
+
+	got := executeCommand(t, "prompt", "--no-stream", "--echo", "capital of France?", "be brief")
+	want := "--- prompt ---\ncapital of France?\nbe brief\n--- response ---\nParis.\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	got = executeCommand(t, "prompt", "--no-stream", "--echo", "--json", "capital of France?")
+	wantPrefix := `{"prompt":"capital of France?","candidates":[{"index":0,"parts":[{"text":"Paris."}]`
+	if !strings.HasPrefix(got, wantPrefix) {
+		t.Errorf("got %q, want it to start with %q", got, wantPrefix)
+	}
+}
+
+func TestPromptTooLong(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var numCounts int
+	fakeBackendHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/models/gemini-1.5-flash"):
+			io.WriteString(w, `{"name": "models/gemini-1.5-flash", "inputTokenLimit": 20000}`)
+		case strings.HasSuffix(r.URL.Path, ":countTokens"):
+			numCounts++
+			io.WriteString(w, `{"totalTokens": 25000}`)
+		case strings.HasSuffix(r.URL.Path, ":generateContent"):
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error": {"code": 400, "message": "The input token count (25000) exceeds the maximum number of tokens allowed (20000).", "status": "INVALID_ARGUMENT"}}`)
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	var logs strings.Builder
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	_, err := executeCommandErr("prompt", "--no-stream", strings.Repeat("word ", 20000))
+	if err == nil || !strings.Contains(err.Error(), "the prompt is longer than the model accepts") || !strings.Contains(err.Error(), "counttok") {
+		t.Errorf("got error %v, want the prompt to be too long", err)
+	}
+	if want := "WARNING: the prompt has 25000 tokens, more than the 20000 input tokens gemini-1.5-flash accepts"; !strings.Contains(logs.String(), want) {
+		t.Errorf("got logs %q, want them to contain %q", logs.String(), want)
+	}
+
+	// Short prompts aren't checked before they're sent.
+	executeCommandErr("prompt", "--no-stream", "hello")
+	if numCounts != 1 {
+		t.Errorf("got %d token counts, want 1", numCounts)
+	}
+}
+
+func TestWrapPromptText(t *testing.T) {
+	image := genai.Blob{MIMEType: "image/png", Data: pngData}
+	var tests = []struct {
+		parts          []genai.Part
+		prefix, suffix string
+		want           []genai.Part
+	}{
+		{[]genai.Part{genai.Text("a poem")}, "", "", []genai.Part{genai.Text("a poem")}},
+		{[]genai.Part{genai.Text("a poem")}, "Translate:", "Answer in French.", []genai.Part{genai.Text("Translate:\na poem\nAnswer in French.")}},
+		{[]genai.Part{genai.Text("a poem")}, "", "Be brief.", []genai.Part{genai.Text("a poem\nBe brief.")}},
+		{
+			[]genai.Part{image, genai.Text("this"), genai.Text("and that"), image},
+			"Compare:", "Be brief.",
+			[]genai.Part{image, genai.Text("Compare:\nthis"), genai.Text("and that\nBe brief."), image},
+		},
+		{[]genai.Part{image}, "Describe:", "Be brief.", []genai.Part{genai.Text("Describe:"), image, genai.Text("Be brief.")}},
+	}
+	for _, tt := range tests {
+		parts := slices.Clone(tt.parts)
+		got := wrapPromptText(parts, tt.prefix, tt.suffix)
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%q, %q: parts mismatch (-want +got):\n%s", tt.prefix, tt.suffix, diff)
+		}
+		if diff := cmp.Diff(tt.parts, parts); diff != "" {
+			t.Errorf("%q, %q: the given parts changed (-want +got):\n%s", tt.prefix, tt.suffix, diff)
+		}
+	}
+}
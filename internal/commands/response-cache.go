@@ -0,0 +1,206 @@
+package commands
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// responseCacheTable is the name of the table in which prompt caches the
+// responses of the model with --cache-responses, by the hash of the request
+// they were generated for.
+const responseCacheTable = "gemini_cli_response_cache"
+
+// responseCachePath returns the path of the DB of the response cache.
+func responseCachePath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".config", "gemini-cli", "response-cache.db"), nil
+}
+
+// responseCacheKey returns the key of the response of model, named modelName,
+// to the prompt parts. It's a hash of everything sent with the request: the
+// model name, its configuration and the parts.
+func responseCacheKey(modelName string, model *genai.GenerativeModel, parts []genai.Part) (string, error) {
+	var systemParts []partJSON
+	if si := model.SystemInstruction; si != nil {
+		for _, part := range si.Parts {
+			systemParts = append(systemParts, partToJSON(part))
+		}
+	}
+	var promptParts []partJSON
+	for _, part := range parts {
+		promptParts = append(promptParts, partToJSON(part))
+	}
+	b, err := json.Marshal(struct {
+		Model             string
+		GenerationConfig  genai.GenerationConfig
+		SafetySettings    []*genai.SafetySetting
+		Tools             []*genai.Tool
+		ToolConfig        *genai.ToolConfig
+		SystemInstruction []partJSON
+		CachedContent     string
+		Parts             []partJSON
+	}{
+		Model:             strings.TrimPrefix(modelName, "models/"),
+		GenerationConfig:  model.GenerationConfig,
+		SafetySettings:    model.SafetySettings,
+		Tools:             model.Tools,
+		ToolConfig:        model.ToolConfig,
+		SystemInstruction: systemParts,
+		CachedContent:     model.CachedContentName,
+		Parts:             promptParts,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error hashing request: %w", err)
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// responseCache is a cache of model responses, stored in responseCacheTable
+// of a DB. The responses are stored as their JSON (see emitResponseJSON),
+// with the time they were stored.
+type responseCache struct {
+	db *sql.DB
+}
+
+// openResponseCache opens the response cache in the DB at path, creating the
+// DB and its table if they don't exist yet.
+func openResponseCache(path string) (*responseCache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("unable to create response cache: %w", err)
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open response cache at %v: %w", path, err)
+	}
+	_, err = db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (key TEXT PRIMARY KEY, response TEXT, created INTEGER)`, responseCacheTable))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to create table '%v' in response cache: %w", responseCacheTable, err)
+	}
+	return &responseCache{db: db}, nil
+}
+
+func (c *responseCache) Close() error {
+	return c.db.Close()
+}
+
+// get returns the cached response for key, or nil if there's none that was
+// stored less than ttl ago. A ttl of 0 means cached responses don't expire.
+func (c *responseCache) get(key string, ttl time.Duration) (*genai.GenerateContentResponse, error) {
+	var data string
+	var created int64
+	err := c.db.QueryRow(fmt.Sprintf(`SELECT response, created FROM %s WHERE key = ?`, responseCacheTable), key).Scan(&data, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading response cache: %w", err)
+	}
+	if ttl > 0 && time.Since(time.Unix(created, 0)) >= ttl {
+		return nil, nil
+	}
+
+	var rj responseJSON
+	if err := json.Unmarshal([]byte(data), &rj); err != nil {
+		return nil, fmt.Errorf("error reading response cache: %w", err)
+	}
+	return responseFromJSON(rj)
+}
+
+// put caches resp as the response for key.
+func (c *responseCache) put(key string, resp *genai.GenerateContentResponse) error {
+	var sb strings.Builder
+	if err := emitResponseJSON(&sb, resp, ""); err != nil {
+		return err
+	}
+	_, err := c.db.Exec(fmt.Sprintf(`INSERT OR REPLACE INTO %s VALUES (?, ?, ?)`, responseCacheTable), key, sb.String(), time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("unable to write response cache: %w", err)
+	}
+	return nil
+}
+
+// clear deletes all the cached responses, and returns how many there were.
+func (c *responseCache) clear() (int64, error) {
+	res, err := c.db.Exec(fmt.Sprintf(`DELETE FROM %s`, responseCacheTable))
+	if err != nil {
+		return 0, fmt.Errorf("unable to clear response cache: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// responseFromJSON converts rj, as written by emitResponseJSON, back to a
+// genai.GenerateContentResponse. Only responses that finished normally are
+// cached, so the prompt feedback (which is for blocked prompts) isn't
+// restored.
+func responseFromJSON(rj responseJSON) (*genai.GenerateContentResponse, error) {
+	resp := &genai.GenerateContentResponse{}
+	for _, cj := range rj.Candidates {
+		c := &genai.Candidate{Index: cj.Index, TokenCount: cj.TokenCount}
+		if len(cj.Parts) > 0 {
+			c.Content = &genai.Content{Role: "model"}
+			for _, pj := range cj.Parts {
+				part, err := partFromJSON(pj)
+				if err != nil {
+					return nil, fmt.Errorf("invalid cached response: %w", err)
+				}
+				c.Content.Parts = append(c.Content.Parts, part)
+			}
+		}
+		if cj.FinishReason != "" {
+			reason, ok := parseEnumName(cj.FinishReason, "FinishReason", []genai.FinishReason{
+				genai.FinishReasonStop, genai.FinishReasonMaxTokens, genai.FinishReasonSafety, genai.FinishReasonRecitation, genai.FinishReasonOther,
+			})
+			if !ok {
+				return nil, fmt.Errorf("invalid cached response: unknown finish reason %q", cj.FinishReason)
+			}
+			c.FinishReason = reason
+		}
+		for _, rj := range cj.SafetyRatings {
+			category, ok := parseHarmCategory(rj.Category)
+			if !ok {
+				return nil, fmt.Errorf("invalid cached response: unknown harm category %q", rj.Category)
+			}
+			probability, _ := parseEnumName(rj.Probability, "HarmProbability", []genai.HarmProbability{
+				genai.HarmProbabilityNegligible, genai.HarmProbabilityLow, genai.HarmProbabilityMedium, genai.HarmProbabilityHigh,
+			})
+			c.SafetyRatings = append(c.SafetyRatings, &genai.SafetyRating{Category: category, Probability: probability, Blocked: rj.Blocked})
+		}
+		resp.Candidates = append(resp.Candidates, c)
+	}
+	if um := rj.UsageMetadata; um != nil {
+		resp.UsageMetadata = &genai.UsageMetadata{
+			PromptTokenCount:     um.PromptTokenCount,
+			CandidatesTokenCount: um.CandidatesTokenCount,
+			TotalTokenCount:      um.TotalTokenCount,
+		}
+	}
+	return resp, nil
+}
+
+// cacheableResponse reports whether resp can be cached: it has candidates,
+// and they all finished normally.
+func cacheableResponse(resp *genai.GenerateContentResponse) bool {
+	if len(resp.Candidates) == 0 {
+		return false
+	}
+	for _, c := range resp.Candidates {
+		if finishReasonError(c) != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/option"
+)
+
+func TestResponseCache(t *testing.T) {
+	cache, err := openResponseCache(filepath.Join(t.TempDir(), "sub", "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	resp := &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{
+			Content:      &genai.Content{Role: "model", Parts: []genai.Part{genai.Text("hello"), genai.Blob{MIMEType: "image/png", Data: []byte{1, 2, 3}}}},
+			FinishReason: genai.FinishReasonStop,
+			SafetyRatings: []*genai.SafetyRating{
+				{Category: genai.HarmCategoryHarassment, Probability: genai.HarmProbabilityLow},
+			},
+		}},
+		UsageMetadata: &genai.UsageMetadata{PromptTokenCount: 3, CandidatesTokenCount: 5, TotalTokenCount: 8},
+	}
+	if err := cache.put("k", resp); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := cache.get("k", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(resp, got); diff != "" {
+		t.Errorf("cached response mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, tt := range []struct {
+		key string
+		ttl time.Duration
+	}{{"other", time.Hour}, {"k", time.Nanosecond}} {
+		if got, err := cache.get(tt.key, tt.ttl); err != nil || got != nil {
+			t.Errorf("get(%q, %v) = %v, %v; want nil, nil", tt.key, tt.ttl, got, err)
+		}
+	}
+
+	if n, err := cache.clear(); err != nil || n != 1 {
+		t.Errorf("clear() = %v, %v; want 1, nil", n, err)
+	}
+	if got, err := cache.get("k", 0); err != nil || got != nil {
+		t.Errorf("get after clear = %v, %v; want nil, nil", got, err)
+	}
+}
+
+func TestResponseCacheKey(t *testing.T) {
+	client, err := genai.NewClient(context.Background(), option.WithAPIKey("fake-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	key := func(modelName string, temperature float32, prompt string) string {
+		model := client.GenerativeModel(modelName)
+		model.SetTemperature(temperature)
+		k, err := responseCacheKey(modelName, model, []genai.Part{genai.Text(prompt)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	base := key("gemini-1.5-flash", 0.5, "hi")
+	if k := key("models/gemini-1.5-flash", 0.5, "hi"); k != base {
+		t.Errorf("key with models/ prefix differs")
+	}
+	for _, k := range []string{key("gemini-1.5-pro", 0.5, "hi"), key("gemini-1.5-flash", 0.6, "hi"), key("gemini-1.5-flash", 0.5, "hi!")} {
+		if k == base {
+			t.Errorf("got the same key for different requests")
+		}
+	}
+}
+
+func TestPromptCacheResponses(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	calls := 0
+	fakeBackend(t, func(path string, body string) string {
+		calls++
+		return `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Felis"}]}, "finishReason": 1}]}`
+	})
+
+	for i, tt := range []struct {
+		args      []string
+		wantCalls int
+	}{
+		{[]string{"--cache-responses"}, 1},
+		{[]string{"--cache-responses"}, 1},
+		{[]string{"--cache-responses", "--temperature", "0.9"}, 2},
+		{[]string{"--no-stream"}, 3},
+		{[]string{"--cache-responses", "--cache-ttl", "1ns"}, 4},
+	} {
+		args := append([]string{"prompt", "what genus do cats belong to?"}, tt.args...)
+		if got, want := executeCommand(t, args...), "Felis\n"; got != want {
+			t.Errorf("#%d: got output %q, want %q", i, got, want)
+		}
+		if calls != tt.wantCalls {
+			t.Errorf("#%d: got %d calls to the API, want %d", i, calls, tt.wantCalls)
+		}
+	}
+
+	executeCommand(t, "cache", "clear")
+	executeCommand(t, "prompt", "what genus do cats belong to?", "--cache-responses")
+	if calls != 5 {
+		t.Errorf("got %d calls to the API after cache clear, want 5", calls)
+	}
+
+	if _, err := executeCommandErr("prompt", "hi", "--cache-ttl", "1h"); err == nil {
+		t.Errorf("got no error for --cache-ttl without --cache-responses")
+	}
+}
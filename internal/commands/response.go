@@ -0,0 +1,396 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// The types in this file mirror the structure of genai.GenerateContentResponse
+// for emitting it as JSON with --json. The genai types don't carry JSON tags,
+// and their enums would be encoded as plain numbers; here enums are encoded
+// by name, in the same format the REST API uses (e.g. "MAX_TOKENS").
+
+type responseJSON struct {
+	// Prompt is the text of the prompt, with --echo.
+	Prompt         string              `json:"prompt,omitempty"`
+	Candidates     []candidateJSON     `json:"candidates"`
+	PromptFeedback *promptFeedbackJSON `json:"promptFeedback,omitempty"`
+	UsageMetadata  *usageMetadataJSON  `json:"usageMetadata,omitempty"`
+}
+
+type candidateJSON struct {
+	Index         int32              `json:"index"`
+	Parts         []partJSON         `json:"parts"`
+	FinishReason  string             `json:"finishReason,omitempty"`
+	SafetyRatings []safetyRatingJSON `json:"safetyRatings,omitempty"`
+	TokenCount    int32              `json:"tokenCount,omitempty"`
+}
+
+type partJSON struct {
+	Text             *string               `json:"text,omitempty"`
+	InlineData       *blobJSON             `json:"inlineData,omitempty"`
+	FunctionCall     *functionCallJSON     `json:"functionCall,omitempty"`
+	FunctionResponse *functionResponseJSON `json:"functionResponse,omitempty"`
+}
+
+type blobJSON struct {
+	MIMEType string `json:"mimeType"`
+	// Data is encoded by encoding/json as base64.
+	Data []byte `json:"data"`
+}
+
+type functionCallJSON struct {
+	Name string         `json:"name"`
+	Args map[string]any `json:"args"`
+}
+
+type functionResponseJSON struct {
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+type safetyRatingJSON struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability"`
+	Blocked     bool   `json:"blocked,omitempty"`
+}
+
+type promptFeedbackJSON struct {
+	BlockReason   string             `json:"blockReason,omitempty"`
+	SafetyRatings []safetyRatingJSON `json:"safetyRatings,omitempty"`
+}
+
+type usageMetadataJSON struct {
+	PromptTokenCount     int32 `json:"promptTokenCount"`
+	CandidatesTokenCount int32 `json:"candidatesTokenCount"`
+	TotalTokenCount      int32 `json:"totalTokenCount"`
+}
+
+// emitResponseJSON writes resp to w as a single line of JSON. If prompt isn't
+// empty, it's included in the "prompt" field.
+func emitResponseJSON(w io.Writer, resp *genai.GenerateContentResponse, prompt string) error {
+	out := responseJSON{Prompt: prompt, Candidates: []candidateJSON{}}
+
+	for _, c := range resp.Candidates {
+		cj := candidateJSON{
+			Index:         c.Index,
+			Parts:         []partJSON{},
+			SafetyRatings: safetyRatingsToJSON(c.SafetyRatings),
+			TokenCount:    c.TokenCount,
+		}
+		if c.FinishReason != genai.FinishReasonUnspecified {
+			cj.FinishReason = enumName(c.FinishReason, "FinishReason")
+		}
+		if c.Content != nil {
+			for _, part := range c.Content.Parts {
+				cj.Parts = append(cj.Parts, partToJSON(part))
+			}
+		}
+		out.Candidates = append(out.Candidates, cj)
+	}
+
+	if pf := resp.PromptFeedback; pf != nil {
+		out.PromptFeedback = &promptFeedbackJSON{
+			SafetyRatings: safetyRatingsToJSON(pf.SafetyRatings),
+		}
+		if pf.BlockReason != genai.BlockReasonUnspecified {
+			out.PromptFeedback.BlockReason = enumName(pf.BlockReason, "BlockReason")
+		}
+	}
+
+	if um := resp.UsageMetadata; um != nil {
+		out.UsageMetadata = &usageMetadataJSON{
+			PromptTokenCount:     um.PromptTokenCount,
+			CandidatesTokenCount: um.CandidatesTokenCount,
+			TotalTokenCount:      um.TotalTokenCount,
+		}
+	}
+
+	return json.NewEncoder(w).Encode(out)
+}
+
+func partToJSON(part genai.Part) partJSON {
+	switch p := part.(type) {
+	case genai.Text:
+		s := string(p)
+		return partJSON{Text: &s}
+	case genai.Blob:
+		return partJSON{InlineData: &blobJSON{MIMEType: p.MIMEType, Data: p.Data}}
+	case genai.FunctionCall:
+		return partJSON{FunctionCall: &functionCallJSON{Name: p.Name, Args: p.Args}}
+	case genai.FunctionResponse:
+		return partJSON{FunctionResponse: &functionResponseJSON{Name: p.Name, Response: p.Response}}
+	default:
+		s := fmt.Sprint(p)
+		return partJSON{Text: &s}
+	}
+}
+
+func safetyRatingsToJSON(ratings []*genai.SafetyRating) []safetyRatingJSON {
+	var result []safetyRatingJSON
+	for _, r := range ratings {
+		result = append(result, safetyRatingJSON{
+			Category:    harmCategoryName(r.Category),
+			Probability: enumName(r.Probability, "HarmProbability"),
+			Blocked:     r.Blocked,
+		})
+	}
+	return result
+}
+
+// enumName converts the name of a genai enum value to the format used by the
+// REST API; for example genai.FinishReasonMaxTokens (with prefix
+// "FinishReason") becomes "MAX_TOKENS".
+func enumName(v fmt.Stringer, prefix string) string {
+	name := strings.TrimPrefix(v.String(), prefix)
+
+	var sb strings.Builder
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			sb.WriteRune('_')
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+	return sb.String()
+}
+
+// printUsage writes a one-line summary of the token usage um to w. The
+// tokens of the prompt that came from cached content are only listed if
+// there are any.
+func printUsage(w io.Writer, um *genai.UsageMetadata) {
+	if um == nil {
+		fmt.Fprintln(w, "tokens: no usage data in response")
+		return
+	}
+	cached := ""
+	if um.CachedContentTokenCount > 0 {
+		cached = fmt.Sprintf(" cached=%d", um.CachedContentTokenCount)
+	}
+	fmt.Fprintf(w, "tokens: prompt=%d%s output=%d total=%d\n", um.PromptTokenCount, cached, um.CandidatesTokenCount, um.TotalTokenCount)
+}
+
+// printSafetyRatings writes the safety ratings to w on one line, starting
+// with label, e.g. "safety: HARASSMENT=NEGLIGIBLE HATE_SPEECH=LOW". Ratings
+// that blocked the content are marked with "(blocked)".
+func printSafetyRatings(w io.Writer, label string, ratings []*genai.SafetyRating) {
+	if len(ratings) == 0 {
+		fmt.Fprintf(w, "%s: no safety ratings in response\n", label)
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(label + ":")
+	for _, r := range ratings {
+		fmt.Fprintf(&sb, " %s=%s", harmCategoryName(r.Category), enumName(r.Probability, "HarmProbability"))
+		if r.Blocked {
+			sb.WriteString("(blocked)")
+		}
+	}
+	fmt.Fprintln(w, sb.String())
+}
+
+// printCandidatesSafetyRatings writes the safety ratings of each of
+// candidates to w with printSafetyRatings; with several candidates, each line
+// says which candidate it's for.
+func printCandidatesSafetyRatings(w io.Writer, candidates []*genai.Candidate) {
+	for i, c := range candidates {
+		label := "safety"
+		if len(candidates) > 1 {
+			label = fmt.Sprintf("safety (candidate %d)", i+1)
+		}
+		printSafetyRatings(w, label, c.SafetyRatings)
+	}
+}
+
+// printBlockedSafetyRatings writes the safety ratings that made the prompt or
+// response blocked to w, if err is a *genai.BlockedError. It reports whether
+// err was one.
+func printBlockedSafetyRatings(w io.Writer, err error) bool {
+	var be *genai.BlockedError
+	if !errors.As(err, &be) {
+		return false
+	}
+	if be.PromptFeedback != nil {
+		printSafetyRatings(w, "safety (prompt)", be.PromptFeedback.SafetyRatings)
+	} else if be.Candidate != nil {
+		printSafetyRatings(w, "safety", be.Candidate.SafetyRatings)
+	}
+	return true
+}
+
+// blockedError turns a *genai.BlockedError in err into an error that says what
+// was blocked and why, e.g. "prompt blocked: SAFETY". Other errors are
+// returned unchanged.
+func blockedError(err error) error {
+	var be *genai.BlockedError
+	if !errors.As(err, &be) {
+		return err
+	}
+	if be.PromptFeedback != nil {
+		return &annotatedError{msg: "prompt blocked: " + enumName(be.PromptFeedback.BlockReason, "BlockReason"), err: be}
+	}
+	if be.Candidate != nil {
+		return &annotatedError{msg: "response blocked: " + enumName(be.Candidate.FinishReason, "FinishReason"), err: be}
+	}
+	return err
+}
+
+// finishReasonError returns an error if the model stopped generating c for
+// any reason other than reaching a natural stopping point, e.g. "response
+// truncated: MAX_TOKENS".
+func finishReasonError(c *genai.Candidate) error {
+	switch c.FinishReason {
+	case genai.FinishReasonUnspecified, genai.FinishReasonStop:
+		return nil
+	case genai.FinishReasonMaxTokens:
+		return fmt.Errorf("response truncated: %s", enumName(c.FinishReason, "FinishReason"))
+	default:
+		return fmt.Errorf("response stopped: %s", enumName(c.FinishReason, "FinishReason"))
+	}
+}
+
+// printPart writes the response part to w. Parts with binary data (e.g.
+// generated images) aren't written to w: saver saves them to files, with a
+// note on stderr, or they're shown by their MIME type and size if saver is
+// nil.
+func printPart(w io.Writer, part genai.Part, saver *blobSaver) error {
+	blob, ok := part.(genai.Blob)
+	if !ok {
+		_, err := fmt.Fprint(w, part)
+		return err
+	}
+	if saver == nil {
+		_, err := fmt.Fprintf(w, "<%s, %d bytes; not shown>", blob.MIMEType, len(blob.Data))
+		return err
+	}
+	path, err := saver.save(blob)
+	if err != nil {
+		return err
+	}
+	log.Printf("saved %s response part (%d bytes) to %s", blob.MIMEType, len(blob.Data), path)
+	return nil
+}
+
+// partPrinter prints the parts of a response with printPart, writing sep
+// between them. Parts saved to files by saver aren't written out, so they
+// aren't separated either.
+type partPrinter struct {
+	saver *blobSaver
+	sep   string
+	// wrote is set once a part was written out since the last reset.
+	wrote bool
+}
+
+// print writes part to w, after the separator if a part was written before.
+func (p *partPrinter) print(w io.Writer, part genai.Part) error {
+	if _, ok := part.(genai.Blob); ok && p.saver != nil {
+		return printPart(w, part, p.saver)
+	}
+	if p.wrote && p.sep != "" {
+		if _, err := io.WriteString(w, p.sep); err != nil {
+			return err
+		}
+	}
+	p.wrote = true
+	return printPart(w, part, p.saver)
+}
+
+// reset starts a new sequence of parts, e.g. of another candidate, which
+// isn't preceded by the separator.
+func (p *partPrinter) reset() {
+	p.wrote = false
+}
+
+// candidateHeader returns what's written before the i-th (0-based) of n
+// candidates of a response: nothing if there's a single one; otherwise sep on
+// a line of its own between candidates if sep isn't empty, or a
+// "--- candidate N ---" line before each.
+func candidateHeader(i int, n int, sep string) string {
+	switch {
+	case n < 2:
+		return ""
+	case sep == "":
+		return fmt.Sprintf("--- candidate %d ---\n", i+1)
+	case i == 0:
+		return ""
+	default:
+		return sep + "\n"
+	}
+}
+
+// blobSaver saves the binary parts of responses to files in a directory, as
+// response-1.png, response-2.wav etc.
+type blobSaver struct {
+	dir string
+	// n is the number of the last file saved.
+	n int
+}
+
+// newBlobSaver returns a blobSaver for dir, or nil if dir is empty.
+func newBlobSaver(dir string) *blobSaver {
+	if dir == "" {
+		return nil
+	}
+	return &blobSaver{dir: dir}
+}
+
+// blobExtensions has the file extensions of common MIME types of responses,
+// which mime.ExtensionsByType doesn't pick consistently.
+var blobExtensions = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/webp":      ".webp",
+	"image/gif":       ".gif",
+	"audio/wav":       ".wav",
+	"audio/mpeg":      ".mp3",
+	"audio/ogg":       ".ogg",
+	"video/mp4":       ".mp4",
+	"application/pdf": ".pdf",
+}
+
+// blobExtension returns the file extension to save data of mimeType with.
+func blobExtension(mimeType string) string {
+	mimeType = baseMIMEType(mimeType)
+	if ext, ok := blobExtensions[mimeType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// save writes the data of blob to the next file of the directory that
+// doesn't exist yet, and returns its path.
+func (s *blobSaver) save(blob genai.Blob) (string, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating output directory: %w", err)
+	}
+	for {
+		s.n++
+		path := filepath.Join(s.dir, fmt.Sprintf("response-%d%s", s.n, blobExtension(blob.MIMEType)))
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		} else if err != nil {
+			return "", fmt.Errorf("error saving response part: %w", err)
+		}
+		_, err = f.Write(blob.Data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", fmt.Errorf("error saving response part: %w", err)
+		}
+		return path, nil
+	}
+}
@@ -0,0 +1,246 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestBlockedError(t *testing.T) {
+	var tests = []struct {
+		err  error
+		want string
+	}{
+		{&genai.BlockedError{PromptFeedback: &genai.PromptFeedback{BlockReason: genai.BlockReasonSafety}}, "prompt blocked: SAFETY"},
+		{&genai.BlockedError{Candidate: &genai.Candidate{FinishReason: genai.FinishReasonRecitation}}, "response blocked: RECITATION"},
+		{fmt.Errorf("wrapped: %w", &genai.BlockedError{Candidate: &genai.Candidate{FinishReason: genai.FinishReasonSafety}}), "response blocked: SAFETY"},
+		{errors.New("other error"), "other error"},
+	}
+
+	for _, tt := range tests {
+		got := blockedError(tt.err).Error()
+		if got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestFinishReasonError(t *testing.T) {
+	var tests = []struct {
+		reason genai.FinishReason
+		want   string
+	}{
+		{genai.FinishReasonUnspecified, ""},
+		{genai.FinishReasonStop, ""},
+		{genai.FinishReasonMaxTokens, "response truncated: MAX_TOKENS"},
+		{genai.FinishReasonOther, "response stopped: OTHER"},
+	}
+
+	for _, tt := range tests {
+		err := finishReasonError(&genai.Candidate{FinishReason: tt.reason})
+		var got string
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.reason, got, tt.want)
+		}
+	}
+}
+
+func TestPrintUsage(t *testing.T) {
+	var sb strings.Builder
+	printUsage(&sb, &genai.UsageMetadata{PromptTokenCount: 120, CandidatesTokenCount: 340, TotalTokenCount: 460})
+	if got, want := sb.String(), "tokens: prompt=120 output=340 total=460\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	sb.Reset()
+	printUsage(&sb, &genai.UsageMetadata{PromptTokenCount: 40010, CachedContentTokenCount: 40000, CandidatesTokenCount: 20, TotalTokenCount: 40030})
+	if got, want := sb.String(), "tokens: prompt=40010 cached=40000 output=20 total=40030\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	sb.Reset()
+	printUsage(&sb, nil)
+	if got := sb.String(); !strings.HasPrefix(got, "tokens: ") {
+		t.Errorf("got %q, want a tokens: line", got)
+	}
+}
+
+func TestPrintSafetyRatings(t *testing.T) {
+	ratings := []*genai.SafetyRating{
+		{Category: genai.HarmCategoryHarassment, Probability: genai.HarmProbabilityNegligible},
+		{Category: genai.HarmCategoryDangerousContent, Probability: genai.HarmProbabilityHigh, Blocked: true},
+	}
+
+	var sb strings.Builder
+	printSafetyRatings(&sb, "safety", ratings)
+	if got, want := sb.String(), "safety: HARASSMENT=NEGLIGIBLE DANGEROUS_CONTENT=HIGH(blocked)\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	sb.Reset()
+	printCandidatesSafetyRatings(&sb, []*genai.Candidate{{SafetyRatings: ratings[:1]}, {}})
+	want := "safety (candidate 1): HARASSMENT=NEGLIGIBLE\nsafety (candidate 2): no safety ratings in response\n"
+	if got := sb.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	sb.Reset()
+	err := fmt.Errorf("wrapped: %w", &genai.BlockedError{PromptFeedback: &genai.PromptFeedback{SafetyRatings: ratings[1:]}})
+	if !printBlockedSafetyRatings(&sb, err) {
+		t.Errorf("got false for a blocked prompt, want true")
+	}
+	if got, want := sb.String(), "safety (prompt): DANGEROUS_CONTENT=HIGH(blocked)\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if printBlockedSafetyRatings(&sb, errors.New("other")) {
+		t.Errorf("got true for another error, want false")
+	}
+}
+
+func TestPrintPart(t *testing.T) {
+	var sb strings.Builder
+	for _, part := range []genai.Part{genai.Text("here it is: "), genai.Blob{MIMEType: "image/png", Data: pngData}} {
+		if err := printPart(&sb, part, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := sb.String(), fmt.Sprintf("here it is: <image/png, %d bytes; not shown>", len(pngData)); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// With a saver, blobs are saved to files that don't exist yet.
+	dir := filepath.Join(t.TempDir(), "out")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "response-1.png"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sb.Reset()
+	saver := newBlobSaver(dir)
+	for _, part := range []genai.Part{genai.Blob{MIMEType: "image/png", Data: pngData}, genai.Text("and"), genai.Blob{MIMEType: "audio/wav", Data: []byte("RIFF")}} {
+		if err := printPart(&sb, part, saver); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := sb.String(), "and"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for name, want := range map[string][]byte{"response-1.png": []byte("old"), "response-2.png": pngData, "response-3.wav": []byte("RIFF")} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	if newBlobSaver("") != nil {
+		t.Errorf("got a saver without a directory, want nil")
+	}
+	for mimeType, want := range map[string]string{"image/jpeg": ".jpg", "application/x-unknown": ".bin", "image/png; foo=bar": ".png"} {
+		if got := blobExtension(mimeType); got != want {
+			t.Errorf("%s: got extension %q, want %q", mimeType, got, want)
+		}
+	}
+}
+
+func TestPromptOutputDir(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeBackend(t, func(path string, body string) string {
+		return fmt.Sprintf(`{"candidates": [{"content": {"role": "model", "parts": [{"text": "A cat:"}, {"inlineData": {"mimeType": "image/png", "data": %q}}]}, "finishReason": 1}]}`,
+			base64.StdEncoding.EncodeToString(pngData))
+	})
+
+	dir := t.TempDir()
+	if got, want := executeCommand(t, "prompt", "--no-stream", "--output-dir", dir, "draw a cat"), "A cat:\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "response-1.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, pngData) {
+		t.Errorf("got saved data %q, want %q", got, pngData)
+	}
+}
+
+func TestPartPrinter(t *testing.T) {
+	var sb strings.Builder
+	pp := &partPrinter{saver: newBlobSaver(t.TempDir()), sep: "|"}
+	for _, part := range []genai.Part{genai.Blob{MIMEType: "image/png", Data: pngData}, genai.Text("a"), genai.Blob{MIMEType: "image/png", Data: pngData}, genai.Text("b")} {
+		if err := pp.print(&sb, part); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pp.reset()
+	if err := pp.print(&sb, genai.Text("c")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sb.String(), "a|bc"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var headers []string
+	for i := range 3 {
+		headers = append(headers, candidateHeader(i, 3, ""), candidateHeader(i, 3, "==="))
+	}
+	want := []string{"--- candidate 1 ---\n", "", "--- candidate 2 ---\n", "===\n", "--- candidate 3 ---\n", "===\n"}
+	if !slices.Equal(headers, want) {
+		t.Errorf("got headers %q, want %q", headers, want)
+	}
+	if got := candidateHeader(0, 1, "==="); got != "" {
+		t.Errorf("got header %q for a single candidate, want none", got)
+	}
+}
+
+func TestPromptPartsSeparator(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeBackend(t, func(path string, body string) string {
+		candidate := `{"content": {"role": "model", "parts": [{"text": "one"}, {"text": "two"}]}, "finishReason": 1}`
+		return `{"candidates": [` + candidate + `, ` + candidate + `]}`
+	})
+	templatesPath := filepath.Join(t.TempDir(), "templates")
+	if err := os.WriteFile(templatesPath, []byte("greet:say %s\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The templates loaded by the template command stay in memory.
+	t.Cleanup(func() { clear(templates) })
+	reqPath := filepath.Join(t.TempDir(), "request.json")
+	if err := os.WriteFile(reqPath, []byte(`{"contents": [{"parts": [{"text": "hi"}]}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Streamed parts are pieces of the same text, so they aren't separated by
+	// default.
+	if pp := newPartPrinter(promptCmd, true, nil); pp.sep != "" {
+		t.Errorf("got default separator %q when streaming, want none", pp.sep)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"prompt", "--no-stream", "hi"}, "--- candidate 1 ---\none\ntwo\n--- candidate 2 ---\none\ntwo\n"},
+		{[]string{"prompt", "--no-stream", "--parts-separator", "", "--candidate-separator", "===", "hi"}, "onetwo\n===\nonetwo\n"},
+		{[]string{"template", "--templates-file", templatesPath, "-u", "greet", "--no-stream", "--parts-separator", ", ", "hi"}, "--- candidate 1 ---\none, two\n--- candidate 2 ---\none, two\n"},
+		{[]string{"run", "--request", reqPath, "--candidate-separator", "***"}, "one\ntwo\n***\none\ntwo\n"},
+	}
+	for _, tt := range tests {
+		if got := executeCommand(t, tt.args...); got != tt.want {
+			t.Errorf("%q: got output %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,9 @@ package commands
 
 import (
 	"fmt"
+	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/eliben/gemini-cli/internal/version"
 	"github.com/spf13/cobra"
@@ -11,36 +14,85 @@ import (
 var rootCmd = &cobra.Command{
 	Use:   "gemini-cli <command>",
 	Short: "Interact with GoogleAI's Gemini LLMs through the command line",
-	Long: `This tool lets you interact with Google's Gemini LLMs from the
-command-line.`,
+	Long:  strings.TrimSpace(rootUsage),
 	CompletionOptions: cobra.CompletionOptions{
 		DisableDefaultCmd: true,
 	},
-	Run: runRootCmd,
+	RunE: runRootCmd,
+
+	// By the time a command runs, its flags and arguments have been validated;
+	// errors reported from here on aren't usage errors, so don't print the
+	// usage text along with them. cobra only checks required flags and flag
+	// groups after this, so check them here first.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := cmd.ValidateRequiredFlags(); err != nil {
+			return &usageError{err}
+		}
+		if err := cmd.ValidateFlagGroups(); err != nil {
+			return &usageError{err}
+		}
+		cmd.SilenceUsage = true
+		if mustGetBoolFlag(cmd, "verbose") {
+			logLevel.Set(slog.LevelDebug)
+		}
+		return applyConfig(cmd)
+	},
 }
 
+var rootUsage = `
+This tool lets you interact with Google's Gemini LLMs from the
+command-line.
+
+Defaults for some flags can be set in the ~/.config/gemini-cli/config.toml
+file; flags passed on the command-line override them. For example:
+
+  # Used by prompt, template, chat and counttok
+  model = "gemini-1.5-pro"
+  temperature = 0.5
+  safety = "default"
+  stream = false
+
+  # Used by the embed commands
+  [embed]
+  model = "text-embedding-004"
+
+  # Short names for models, which --model (and the model settings above)
+  # can refer to
+  [aliases]
+  pro = "gemini-1.5-pro-latest"
+  flash = "gemini-1.5-flash-002"
+`
+
 // Execute adds all child commands to the root command and sets flags
 // appropriately. This is called by main.main(). It only needs to happen once to
-// the rootCmd.
+// the rootCmd. It returns the exit code for the program, which tells apart
+// categories of errors (see exitCode); errors from commands are reported by
+// cobra on stderr.
 func Execute() int {
-	if err := rootCmd.Execute(); err != nil {
-		return 1
-	}
-	return 0
+	cmd, err := rootCmd.ExecuteC()
+	return exitCode(cmd, err)
 }
 
 func init() {
 	rootCmd.PersistentFlags().String("key", "", "API key for Google AI")
 	rootCmd.PersistentFlags().String("model", "gemini-1.5-flash", "Name of model to use; see https://ai.google.dev/models/gemini")
+	rootCmd.RegisterFlagCompletionFunc("model", completeModelNames)
 	rootCmd.PersistentFlags().String("proxy", "", "URL of proxy server to use for the connection")
+	rootCmd.PersistentFlags().String("endpoint", "", `API endpoint to connect to instead of the public Gemini API, or "vertex" for Vertex AI; overrides the GEMINI_CLI_ENDPOINT env var`)
+	rootCmd.PersistentFlags().String("project", "", "Google Cloud project to use Vertex AI in, with --endpoint vertex")
+	rootCmd.PersistentFlags().String("location", "", "Google Cloud location (e.g. us-central1, or global) to use Vertex AI in, with --endpoint vertex")
+	rootCmd.PersistentFlags().String("credentials-file", "", "path to a service account JSON credentials file to authenticate with instead of an API key")
+	rootCmd.PersistentFlags().BoolP("quiet", "q", false, "leave only model output on stdout: don't end responses with a newline, and print status messages to stderr")
+	rootCmd.PersistentFlags().Bool("verbose", false, "log details about requests to the model (configuration, latency) to stderr")
+	rootCmd.PersistentFlags().Duration("timeout", 60*time.Second, "timeout for requests to the model; 0 means no timeout")
 
 	rootCmd.Flags().BoolP("version", "v", false, `print version info and exit`)
 }
 
-func runRootCmd(cmd *cobra.Command, args []string) {
+func runRootCmd(cmd *cobra.Command, args []string) error {
 	if mustGetBoolFlag(cmd, "version") {
-		fmt.Println(version.Version)
-	} else {
-		cmd.Usage()
+		fmt.Fprintln(cmd.OutOrStdout(), version.Version)
+		return nil
 	}
+	return cmd.Usage()
 }
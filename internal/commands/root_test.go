@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// executeCommand runs the root command with args, and returns what it wrote
+// to its output.
+func executeCommand(t *testing.T, args ...string) string {
+	t.Helper()
+	out, err := executeCommandErr(args...)
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return out
+}
+
+// executeCommandErr is like executeCommand, but returns the command's error
+// instead of failing the test.
+func executeCommandErr(args ...string) (string, error) {
+	var out strings.Builder
+	rootCmd.SetOut(&out)
+	rootCmd.SetArgs(args)
+	defer func() {
+		rootCmd.SetOut(nil)
+		rootCmd.SetArgs(nil)
+		resetFlags(rootCmd)
+	}()
+	err := rootCmd.Execute()
+	return out.String(), err
+}
+
+// parseFlagsCmd returns a new command with the flags added by addFlags,
+// parsed from args, for testing the functions that read flags.
+func parseFlagsCmd(t *testing.T, addFlags func(*cobra.Command), args ...string) *cobra.Command {
+	t.Helper()
+	cmd := &cobra.Command{}
+	addFlags(cmd)
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+// resetFlags resets the flags of cmd and its subcommands that were set by
+// running it to their defaults, so they don't leak into the next run.
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if _, ok := f.Annotations[configAnnotation]; ok {
+			delete(f.Annotations, configAnnotation)
+		} else if !f.Changed {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			var def []string
+			if d := strings.Trim(f.DefValue, "[]"); d != "" {
+				def = strings.Split(d, ",")
+			}
+			sv.Replace(def)
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, c := range cmd.Commands() {
+		resetFlags(c)
+	}
+}
+
+func TestCommandOutput(t *testing.T) {
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+	t.Setenv("USERPROFILE", homeDir)
+
+	if got := executeCommand(t, "--version"); got == "" || !strings.HasSuffix(got, "\n") {
+		t.Errorf("--version: got output %q, want a version line", got)
+	}
+
+	templatesPath := filepath.Join(homeDir, "templates")
+	if err := os.WriteFile(templatesPath, []byte("tr: Translate to French: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got := executeCommand(t, "template", "--list", "--templates-file", templatesPath)
+	if want := "tr\t:Translate to French: {}\n"; got != want {
+		t.Errorf("template --list: got output %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,335 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/spf13/cobra"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Send a request given as JSON in the format of the API",
+	Long:  strings.TrimSpace(runUsage),
+	Args:  cobra.ExactArgs(0),
+	RunE:  runRunCmd,
+}
+
+var runUsage = `
+Send a generateContent request that is described in full by a JSON file, in
+the same format as the body of the REST API's request (see
+https://ai.google.dev/api/generate-content). This gives access to API options
+that don't have flags of their own. For example:
+
+  {
+    "model": "gemini-1.5-flash",
+    "systemInstruction": {"parts": [{"text": "Answer in French."}]},
+    "contents": [
+      {"role": "user", "parts": [{"text": "What's the capital of Spain?"}]}
+    ],
+    "generationConfig": {"temperature": 0.2, "maxOutputTokens": 100},
+    "safetySettings": [
+      {"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_ONLY_HIGH"}
+    ]
+  }
+
+The supported fields are model (the --model flag is used if it's missing),
+contents, systemInstruction, generationConfig, safetySettings, tools (with
+functionDeclarations and codeExecution) and toolConfig. Fields that aren't
+known, or that have values of the wrong type, are reported as errors. With
+several contents, the last one is the new message and the ones before it are
+the history of the conversation.
+
+--request - reads the request from standard input. The text of the response is
+printed, or the full response as JSON with --json; as with prompt,
+--parts-separator and --candidate-separator set what is written between its
+parts and candidates.
+`
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+	runCmd.Flags().String("request", "", "path of the JSON file with the request, or '-' for standard input")
+	runCmd.Flags().Bool("json", false, "emit the full model response as JSON")
+	addOutputFlags(runCmd)
+	runCmd.MarkFlagRequired("request")
+}
+
+// runRequest is the JSON of a request of the run command, in the format of
+// the REST API's GenerateContentRequest.
+type runRequest struct {
+	Model             string               `json:"model"`
+	Contents          []contentJSON        `json:"contents"`
+	SystemInstruction *contentJSON         `json:"systemInstruction"`
+	GenerationConfig  *runGenerationConfig `json:"generationConfig"`
+	SafetySettings    []runSafetySetting   `json:"safetySettings"`
+	Tools             []runTool            `json:"tools"`
+	ToolConfig        *runToolConfig       `json:"toolConfig"`
+}
+
+type runGenerationConfig struct {
+	CandidateCount   *int32      `json:"candidateCount"`
+	StopSequences    []string    `json:"stopSequences"`
+	MaxOutputTokens  *int32      `json:"maxOutputTokens"`
+	Temperature      *float32    `json:"temperature"`
+	TopP             *float32    `json:"topP"`
+	TopK             *int32      `json:"topK"`
+	ResponseMIMEType string      `json:"responseMimeType"`
+	ResponseSchema   *jsonSchema `json:"responseSchema"`
+}
+
+type runSafetySetting struct {
+	Category  string `json:"category"`
+	Threshold string `json:"threshold"`
+}
+
+type runTool struct {
+	FunctionDeclarations []functionDeclaration `json:"functionDeclarations"`
+	CodeExecution        *struct{}             `json:"codeExecution"`
+}
+
+type runToolConfig struct {
+	FunctionCallingConfig *struct {
+		Mode                 string   `json:"mode"`
+		AllowedFunctionNames []string `json:"allowedFunctionNames"`
+	} `json:"functionCallingConfig"`
+}
+
+func runRunCmd(cmd *cobra.Command, args []string) error {
+	var data []byte
+	var err error
+	if path := mustGetStringFlag(cmd, "request"); path == "-" {
+		data, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading request: %w", err)
+	}
+	req, err := parseRunRequest(data)
+	if err != nil {
+		return err
+	}
+	if len(req.Contents) == 0 {
+		return errors.New("invalid request: no contents")
+	}
+
+	modelName := strings.TrimPrefix(req.Model, "models/")
+	if modelName == "" {
+		modelName = mustGetStringFlag(cmd, "model")
+	}
+
+	var contents []*genai.Content
+	for i, cj := range req.Contents {
+		content, err := contentFromJSON(cj)
+		if err != nil {
+			return fmt.Errorf("invalid request: contents[%d]: %w", i, err)
+		}
+		contents = append(contents, content)
+	}
+	last := contents[len(contents)-1]
+	if last.Role != "" && last.Role != "user" {
+		return fmt.Errorf("invalid request: the last of the contents has role %q; expect it to be the user's", last.Role)
+	}
+
+	ctx, stop := newCommandContext()
+	defer stop()
+	ctx, cancel := withRequestTimeout(ctx, cmd)
+	defer cancel()
+	client, err := newClient(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	model := client.GenerativeModel(modelName)
+	if err := configureRunModel(model, req); err != nil {
+		return err
+	}
+
+	logRequest(model, modelName, len(last.Parts))
+	start := time.Now()
+	var resp *genai.GenerateContentResponse
+	if len(contents) == 1 {
+		resp, err = model.GenerateContent(ctx, last.Parts...)
+	} else {
+		session := model.StartChat()
+		session.History = contents[:len(contents)-1]
+		resp, err = session.SendMessage(ctx, last.Parts...)
+	}
+	logResponse(start)
+	if err != nil {
+		return requestError(ctx, cmd, err)
+	}
+
+	w := cmd.OutOrStdout()
+	if mustGetBoolFlag(cmd, "json") {
+		return emitResponseJSON(w, resp, "")
+	}
+	if len(resp.Candidates) < 1 {
+		fmt.Fprintln(w, "<empty response from model>")
+	}
+	pp := newPartPrinter(cmd, false, nil)
+	candidateSep := mustGetStringFlag(cmd, "candidate-separator")
+	var finishErr error
+	for i, c := range resp.Candidates {
+		fmt.Fprint(w, candidateHeader(i, len(resp.Candidates), candidateSep))
+		if err := finishReasonError(c); err != nil && finishErr == nil {
+			finishErr = err
+		}
+		if c.Content == nil {
+			fmt.Fprintln(w, "<empty response from model>")
+			continue
+		}
+		pp.reset()
+		for _, part := range c.Content.Parts {
+			if err := pp.print(w, part); err != nil {
+				return err
+			}
+		}
+		if !mustGetBoolFlag(cmd, "quiet") {
+			fmt.Fprintln(w)
+		}
+	}
+	return finishErr
+}
+
+// parseRunRequest parses the JSON of a request of the run command from data.
+// It reports the field of the request that failed to parse, if any.
+func parseRunRequest(data []byte) (*runRequest, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var req runRequest
+	if err := dec.Decode(&req); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		var syntaxErr *json.SyntaxError
+		switch {
+		case errors.As(err, &typeErr):
+			return nil, fmt.Errorf("invalid request: field %s: can't use a JSON %s as %v", typeErr.Field, typeErr.Value, typeErr.Type)
+		case errors.Is(err, io.EOF):
+			return nil, errors.New("invalid request: no JSON object")
+		case errors.As(err, &syntaxErr):
+			line := 1 + bytes.Count(data[:syntaxErr.Offset], []byte("\n"))
+			return nil, fmt.Errorf("invalid request: line %d: %w", line, err)
+		default:
+			return nil, fmt.Errorf("invalid request: %w", err)
+		}
+	}
+	if dec.More() {
+		return nil, errors.New("invalid request: unexpected data after the request object")
+	}
+	return &req, nil
+}
+
+// contentFromJSON converts cj to a genai.Content.
+func contentFromJSON(cj contentJSON) (*genai.Content, error) {
+	content := &genai.Content{Role: cj.Role}
+	for i, pj := range cj.Parts {
+		part, err := partFromJSON(pj)
+		if err != nil {
+			return nil, fmt.Errorf("parts[%d]: %w", i, err)
+		}
+		content.Parts = append(content.Parts, part)
+	}
+	if len(content.Parts) == 0 {
+		return nil, errors.New("no parts")
+	}
+	return content, nil
+}
+
+// configureRunModel applies the configuration of req, apart from its model
+// and contents, to model.
+func configureRunModel(model *genai.GenerativeModel, req *runRequest) error {
+	if req.SystemInstruction != nil {
+		si, err := contentFromJSON(*req.SystemInstruction)
+		if err != nil {
+			return fmt.Errorf("invalid request: systemInstruction: %w", err)
+		}
+		model.SystemInstruction = si
+	}
+
+	if gc := req.GenerationConfig; gc != nil {
+		schema, err := gc.ResponseSchema.toGenai("")
+		if err != nil {
+			return fmt.Errorf("invalid request: generationConfig.responseSchema: %w", err)
+		}
+		model.GenerationConfig = genai.GenerationConfig{
+			CandidateCount:   gc.CandidateCount,
+			StopSequences:    gc.StopSequences,
+			MaxOutputTokens:  gc.MaxOutputTokens,
+			Temperature:      gc.Temperature,
+			TopP:             gc.TopP,
+			TopK:             gc.TopK,
+			ResponseMIMEType: gc.ResponseMIMEType,
+			ResponseSchema:   schema,
+		}
+	}
+
+	for i, ss := range req.SafetySettings {
+		category, ok := parseHarmCategory(ss.Category)
+		if !ok {
+			return fmt.Errorf("invalid request: safetySettings[%d].category: unknown harm category %q", i, ss.Category)
+		}
+		threshold, ok := parseEnumName(ss.Threshold, "Harm", []genai.HarmBlockThreshold{
+			genai.HarmBlockLowAndAbove, genai.HarmBlockMediumAndAbove, genai.HarmBlockOnlyHigh, genai.HarmBlockNone,
+		})
+		if !ok {
+			return fmt.Errorf("invalid request: safetySettings[%d].threshold: unknown threshold %q", i, ss.Threshold)
+		}
+		model.SafetySettings = append(model.SafetySettings, &genai.SafetySetting{Category: category, Threshold: threshold})
+	}
+
+	for i, rt := range req.Tools {
+		tool := &genai.Tool{}
+		for _, fd := range rt.FunctionDeclarations {
+			if fd.Name == "" {
+				return fmt.Errorf("invalid request: tools[%d]: function without a name", i)
+			}
+			params, err := fd.Parameters.toGenai("")
+			if err != nil {
+				return fmt.Errorf("invalid request: tools[%d], function %s: %w", i, fd.Name, err)
+			}
+			tool.FunctionDeclarations = append(tool.FunctionDeclarations, &genai.FunctionDeclaration{
+				Name:        fd.Name,
+				Description: fd.Description,
+				Parameters:  params,
+			})
+		}
+		if rt.CodeExecution != nil {
+			tool.CodeExecution = &genai.CodeExecution{}
+		}
+		model.Tools = append(model.Tools, tool)
+	}
+
+	if tc := req.ToolConfig; tc != nil && tc.FunctionCallingConfig != nil {
+		mode, ok := parseEnumName(tc.FunctionCallingConfig.Mode, "FunctionCalling", []genai.FunctionCallingMode{
+			genai.FunctionCallingAuto, genai.FunctionCallingAny, genai.FunctionCallingNone,
+		})
+		if !ok {
+			return fmt.Errorf("invalid request: toolConfig.functionCallingConfig.mode: unknown mode %q", tc.FunctionCallingConfig.Mode)
+		}
+		model.ToolConfig = &genai.ToolConfig{FunctionCallingConfig: &genai.FunctionCallingConfig{
+			Mode:                 mode,
+			AllowedFunctionNames: tc.FunctionCallingConfig.AllowedFunctionNames,
+		}}
+	}
+	return nil
+}
+
+// parseEnumName returns the value of values whose name in the REST API (see
+// enumName, with prefix) is name, and whether there's one.
+func parseEnumName[T fmt.Stringer](name string, prefix string, values []T) (T, bool) {
+	for _, v := range values {
+		if enumName(v, prefix) == name {
+			return v, true
+		}
+	}
+	var zero T
+	return zero, false
+}
@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseRunRequestErrors(t *testing.T) {
+	var tests = []struct {
+		data    string
+		wantErr string
+	}{
+		{``, "no JSON object"},
+		{`{"contents": [}`, "line 1"},
+		{"{\n\"contents\": []\n,,}", "line 3"},
+		{`{"contents": [], "temperature": 0.5}`, `unknown field "temperature"`},
+		{`{"generationConfig": {"temperature": "hot"}}`, "field generationConfig.temperature: can't use a JSON string as float32"},
+		{`{"contents": [{"parts": [{"text": 42}]}]}`, "text: can't use a JSON number as string"},
+		{`{} {}`, "unexpected data after the request object"},
+	}
+	for _, tt := range tests {
+		_, err := parseRunRequest([]byte(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%q: got error %v, want it to contain %q", tt.data, err, tt.wantErr)
+		}
+	}
+}
+
+func TestConfigureRunModel(t *testing.T) {
+	req, err := parseRunRequest([]byte(`{
+		"systemInstruction": {"parts": [{"text": "be brief"}]},
+		"generationConfig": {"temperature": 0.5, "maxOutputTokens": 10, "responseMimeType": "application/json",
+			"responseSchema": {"type": "ARRAY", "items": {"type": "STRING"}}},
+		"safetySettings": [{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_ONLY_HIGH"}],
+		"tools": [{"functionDeclarations": [{"name": "get_time", "description": "get the time"}]}],
+		"toolConfig": {"functionCallingConfig": {"mode": "ANY"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := &genai.GenerativeModel{}
+	if err := configureRunModel(model, req); err != nil {
+		t.Fatal(err)
+	}
+
+	temperature := float32(0.5)
+	maxTokens := int32(10)
+	want := &genai.GenerativeModel{
+		GenerationConfig: genai.GenerationConfig{
+			Temperature:      &temperature,
+			MaxOutputTokens:  &maxTokens,
+			ResponseMIMEType: "application/json",
+			ResponseSchema:   &genai.Schema{Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}},
+		},
+		SafetySettings:    []*genai.SafetySetting{{Category: genai.HarmCategoryHarassment, Threshold: genai.HarmBlockOnlyHigh}},
+		Tools:             []*genai.Tool{{FunctionDeclarations: []*genai.FunctionDeclaration{{Name: "get_time", Description: "get the time"}}}},
+		ToolConfig:        &genai.ToolConfig{FunctionCallingConfig: &genai.FunctionCallingConfig{Mode: genai.FunctionCallingAny}},
+		SystemInstruction: &genai.Content{Parts: []genai.Part{genai.Text("be brief")}},
+	}
+	if diff := cmp.Diff(want, model, cmp.AllowUnexported(genai.GenerativeModel{})); diff != "" {
+		t.Errorf("model mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, data := range []string{
+		`{"safetySettings": [{"category": "HARM_CATEGORY_VIOLENCE", "threshold": "BLOCK_NONE"}]}`,
+		`{"safetySettings": [{"category": "HARASSMENT", "threshold": "BLOCK_SOME"}]}`,
+		`{"toolConfig": {"functionCallingConfig": {"mode": "SOMETIMES"}}}`,
+	} {
+		req, err := parseRunRequest([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := configureRunModel(&genai.GenerativeModel{}, req); err == nil || !strings.Contains(err.Error(), "invalid request") {
+			t.Errorf("%s: got error %v, want invalid request", data, err)
+		}
+	}
+}
+
+func TestRunCmd(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var gotPath string
+	var gotRequest map[string]any
+	fakeBackend(t, func(path string, body string) string {
+		gotPath = path
+		if err := json.Unmarshal([]byte(body), &gotRequest); err != nil {
+			t.Fatal(err)
+		}
+		return `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Madrid."}]}, "finishReason": 1}]}`
+	})
+
+	reqPath := filepath.Join(t.TempDir(), "request.json")
+	if err := os.WriteFile(reqPath, []byte(`{
+		"model": "models/gemini-1.5-pro",
+		"contents": [{"role": "user", "parts": [{"text": "What's the capital of Spain?"}]}],
+		"generationConfig": {"topK": 3}
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := executeCommand(t, "run", "--request", reqPath); got != "Madrid.\n" {
+		t.Errorf("got output %q, want %q", got, "Madrid.\n")
+	}
+	if !strings.HasSuffix(gotPath, "models/gemini-1.5-pro:generateContent") {
+		t.Errorf("got request to %s, want gemini-1.5-pro:generateContent", gotPath)
+	}
+	if topK := gotRequest["generationConfig"].(map[string]any)["topK"]; topK != 3.0 {
+		t.Errorf("got topK %v in request, want 3", topK)
+	}
+}
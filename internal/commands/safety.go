@@ -0,0 +1,175 @@
+package commands
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/spf13/cobra"
+)
+
+var safetyCmd = &cobra.Command{
+	Use:   "safety",
+	Short: "List the harm categories and safety levels",
+	Long:  strings.TrimSpace(safetyUsage),
+	Args:  cobra.ExactArgs(0),
+	RunE:  runSafetyCmd,
+}
+
+var safetyUsage = `
+List the harm categories that the safety filtering of Gemini models applies
+to, and the levels that --safety accepts, with the API threshold each level
+sets for all the categories. The level of single categories can be changed
+with --safety-category CATEGORY=LEVEL, using the names listed here.
+
+The API rates the probability that a prompt or response is harmful in each
+category as NEGLIGIBLE, LOW, MEDIUM or HIGH; a level blocks content with the
+probability it names and above.
+`
+
+func init() {
+	rootCmd.AddCommand(safetyCmd)
+}
+
+func runSafetyCmd(cmd *cobra.Command, args []string) error {
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Harm categories:")
+	for _, category := range harmCategories {
+		fmt.Fprintf(w, "  %s\n", harmCategoryName(category))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Safety levels:")
+	fmt.Fprintf(w, "  default\t\tthe API's default thresholds\n")
+	for _, level := range safetyLevelNames {
+		threshold := safetyLevels[level]
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", level, enumName(threshold, "Harm"), thresholdDescriptions[threshold])
+	}
+	return w.Flush()
+}
+
+// harmCategories lists the harm categories supported by Gemini models; safety
+// thresholds are applied to each of them.
+var harmCategories = []genai.HarmCategory{
+	genai.HarmCategoryDangerousContent,
+	genai.HarmCategoryHarassment,
+	genai.HarmCategoryHateSpeech,
+	genai.HarmCategorySexuallyExplicit,
+}
+
+// safetyLevels maps values of the --safety flag to the block threshold applied
+// to all harm categories. The level names the lowest probability of harm that
+// gets blocked. "default" isn't listed; it means that no safety settings are
+// sent, and the API's default thresholds apply.
+var safetyLevels = map[string]genai.HarmBlockThreshold{
+	"none":   genai.HarmBlockNone,
+	"low":    genai.HarmBlockLowAndAbove,
+	"medium": genai.HarmBlockMediumAndAbove,
+	"high":   genai.HarmBlockOnlyHigh,
+}
+
+// safetyLevelNames lists the keys of safetyLevels, from the strictest level.
+var safetyLevelNames = []string{"low", "medium", "high", "none"}
+
+// thresholdDescriptions describes what each threshold of safetyLevels blocks.
+var thresholdDescriptions = map[genai.HarmBlockThreshold]string{
+	genai.HarmBlockLowAndAbove:    "block low, medium and high probability of harm",
+	genai.HarmBlockMediumAndAbove: "block medium and high probability of harm",
+	genai.HarmBlockOnlyHigh:       "block only high probability of harm",
+	genai.HarmBlockNone:           "block nothing",
+}
+
+// harmCategoryName returns the name of category as reported in safety
+// ratings, e.g. "HARASSMENT".
+func harmCategoryName(category genai.HarmCategory) string {
+	return enumName(category, "HarmCategory")
+}
+
+const safetyFlagUsage = `safety filtering level: default (the API's default thresholds), low (block low probability of harm and above), medium, high (block only high) or none (no filtering; see also --raw)`
+
+// safetySettingsForLevel returns the safety settings for the given --safety
+// level.
+func safetySettingsForLevel(level string) ([]*genai.SafetySetting, error) {
+	level = strings.ToLower(strings.TrimSpace(level))
+	if level == "default" {
+		return nil, nil
+	}
+
+	threshold, ok := safetyLevels[level]
+	if !ok {
+		return nil, &usageError{fmt.Errorf("invalid --safety value %q; expect one of: default, %s", level, strings.Join(safetyLevelNames, ", "))}
+	}
+
+	var settings []*genai.SafetySetting
+	for _, category := range harmCategories {
+		settings = append(settings, &genai.SafetySetting{
+			Category:  category,
+			Threshold: threshold,
+		})
+	}
+	return settings, nil
+}
+
+// applySafetyCategories applies the --safety-category values in overrides,
+// of the form CATEGORY=LEVEL, to settings: the threshold of each category
+// named in overrides is replaced by the one of LEVEL, a --safety level. The
+// level "default" removes the category from the settings, leaving it at the
+// API's default threshold. The category names are the ones of
+// harmCategoryName, case-insensitive and optionally prefixed with
+// HARM_CATEGORY_.
+func applySafetyCategories(settings []*genai.SafetySetting, overrides []string) ([]*genai.SafetySetting, error) {
+	for _, override := range overrides {
+		name, level, ok := strings.Cut(override, "=")
+		if !ok {
+			return nil, &usageError{fmt.Errorf("invalid --safety-category value %q; expect CATEGORY=LEVEL, e.g. HARASSMENT=high", override)}
+		}
+		category, ok := parseHarmCategory(name)
+		if !ok {
+			var names []string
+			for _, c := range harmCategories {
+				names = append(names, harmCategoryName(c))
+			}
+			return nil, &usageError{fmt.Errorf("invalid harm category %q in --safety-category; expect one of: %s", name, strings.Join(names, ", "))}
+		}
+
+		settings = slices.DeleteFunc(settings, func(s *genai.SafetySetting) bool {
+			return s.Category == category
+		})
+		level = strings.ToLower(strings.TrimSpace(level))
+		if level == "default" {
+			continue
+		}
+		threshold, ok := safetyLevels[level]
+		if !ok {
+			return nil, &usageError{fmt.Errorf("invalid safety level %q in --safety-category; expect none, low, medium, high or default", level)}
+		}
+		settings = append(settings, &genai.SafetySetting{Category: category, Threshold: threshold})
+	}
+	return settings, nil
+}
+
+// parseHarmCategory returns the harm category named name, as described for
+// applySafetyCategories, and whether there is one.
+func parseHarmCategory(name string) (genai.HarmCategory, bool) {
+	name = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "HARM_CATEGORY_")
+	for _, category := range harmCategories {
+		if harmCategoryName(category) == name {
+			return category, true
+		}
+	}
+	return 0, false
+}
+
+// safetyFilteringOff reports whether settings turn off the filtering of all
+// the harm categories.
+func safetyFilteringOff(settings []*genai.SafetySetting) bool {
+	for _, category := range harmCategories {
+		if !slices.ContainsFunc(settings, func(s *genai.SafetySetting) bool {
+			return s.Category == category && s.Threshold == genai.HarmBlockNone
+		}) {
+			return false
+		}
+	}
+	return true
+}
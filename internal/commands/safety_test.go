@@ -0,0 +1,15 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSafetyCmd(t *testing.T) {
+	got := executeCommand(t, "safety")
+	for _, want := range []string{"  HARASSMENT\n", "  SEXUALLY_EXPLICIT\n", "  high     BLOCK_ONLY_HIGH ", "  none     BLOCK_NONE "} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got output:\n%s\nwant it to contain %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// jsonSchema is the subset of JSON Schema that maps onto genai.Schema.
+type jsonSchema struct {
+	Type        string                 `json:"type"`
+	Format      string                 `json:"format"`
+	Description string                 `json:"description"`
+	Nullable    bool                   `json:"nullable"`
+	Enum        []string               `json:"enum"`
+	Items       *jsonSchema            `json:"items"`
+	Properties  map[string]*jsonSchema `json:"properties"`
+	Required    []string               `json:"required"`
+}
+
+var schemaTypes = map[string]genai.Type{
+	"string":  genai.TypeString,
+	"number":  genai.TypeNumber,
+	"integer": genai.TypeInteger,
+	"boolean": genai.TypeBoolean,
+	"array":   genai.TypeArray,
+	"object":  genai.TypeObject,
+}
+
+// parseSchema parses a JSON schema from data into a genai.Schema. Only the
+// keywords genai.Schema supports are accepted: type, format, description,
+// nullable, enum, items, properties and required.
+func parseSchema(data []byte) (*genai.Schema, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var js jsonSchema
+	if err := dec.Decode(&js); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return js.toGenai("")
+}
+
+// toGenai converts js to a genai.Schema; path is js's location in the full
+// schema, for error messages.
+func (js *jsonSchema) toGenai(path string) (*genai.Schema, error) {
+	if js == nil {
+		return nil, nil
+	}
+	t, ok := schemaTypes[strings.ToLower(js.Type)]
+	if !ok {
+		if path == "" {
+			path = "/"
+		}
+		return nil, fmt.Errorf("invalid schema: unknown type %q at %s", js.Type, path)
+	}
+
+	s := &genai.Schema{
+		Type:        t,
+		Format:      js.Format,
+		Description: js.Description,
+		Nullable:    js.Nullable,
+		Enum:        js.Enum,
+		Required:    js.Required,
+	}
+
+	var err error
+	if s.Items, err = js.Items.toGenai(path + "/items"); err != nil {
+		return nil, err
+	}
+	if len(js.Properties) > 0 {
+		s.Properties = make(map[string]*genai.Schema)
+		for name, prop := range js.Properties {
+			if s.Properties[name], err = prop.toGenai(path + "/properties/" + name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseSchema(t *testing.T) {
+	input := `{
+  "type": "object",
+  "description": "a cat",
+  "properties": {
+    "name": {"type": "string"},
+    "age": {"type": "integer", "format": "int32"},
+    "colors": {"type": "array", "items": {"type": "string", "enum": ["black", "white"]}}
+  },
+  "required": ["name"]
+}`
+	got, err := parseSchema([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &genai.Schema{
+		Type:        genai.TypeObject,
+		Description: "a cat",
+		Properties: map[string]*genai.Schema{
+			"name": {Type: genai.TypeString},
+			"age":  {Type: genai.TypeInteger, Format: "int32"},
+			"colors": {
+				Type:  genai.TypeArray,
+				Items: &genai.Schema{Type: genai.TypeString, Enum: []string{"black", "white"}},
+			},
+		},
+		Required: []string{"name"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("schema mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	var tests = []string{
+		`{"type": "object"`,
+		`{"type": "tuple"}`,
+		`{"type": "object", "properties": {"x": {"type": "date"}}}`,
+		`{"type": "string", "minLength": 2}`,
+	}
+
+	for _, input := range tests {
+		if _, err := parseSchema([]byte(input)); err == nil {
+			t.Errorf("%s: got no error, want error", input)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/spf13/cobra"
+	"google.golang.org/api/iterator"
+)
+
+// sessionJSON is the format of the chat session files of prompt --session.
+type sessionJSON struct {
+	History []contentJSON `json:"history"`
+}
+
+type contentJSON struct {
+	Role  string     `json:"role"`
+	Parts []partJSON `json:"parts"`
+}
+
+// readSession reads the chat history from the session file at path. A missing
+// file is a new session, with an empty history.
+func readSession(path string) ([]*genai.Content, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading session: %w", err)
+	}
+
+	var sj sessionJSON
+	if err := json.Unmarshal(b, &sj); err != nil {
+		return nil, fmt.Errorf("session file %s: %w", path, err)
+	}
+	var history []*genai.Content
+	for i, cj := range sj.History {
+		content := &genai.Content{Role: cj.Role}
+		for _, pj := range cj.Parts {
+			part, err := partFromJSON(pj)
+			if err != nil {
+				return nil, fmt.Errorf("session file %s, message %d: %w", path, i+1, err)
+			}
+			content.Parts = append(content.Parts, part)
+		}
+		history = append(history, content)
+	}
+	return history, nil
+}
+
+// writeSession writes history to the session file at path.
+func writeSession(path string, history []*genai.Content) error {
+	sj := sessionJSON{History: []contentJSON{}}
+	for _, content := range history {
+		cj := contentJSON{Role: content.Role, Parts: []partJSON{}}
+		for _, part := range content.Parts {
+			cj.Parts = append(cj.Parts, partToJSON(part))
+		}
+		sj.History = append(sj.History, cj)
+	}
+	b, err := json.MarshalIndent(sj, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0600); err != nil {
+		return fmt.Errorf("error writing session: %w", err)
+	}
+	return nil
+}
+
+// partFromJSON converts a part as encoded by partToJSON back to a
+// genai.Part.
+func partFromJSON(pj partJSON) (genai.Part, error) {
+	switch {
+	case pj.Text != nil:
+		return genai.Text(*pj.Text), nil
+	case pj.InlineData != nil:
+		return genai.Blob{MIMEType: pj.InlineData.MIMEType, Data: pj.InlineData.Data}, nil
+	case pj.FunctionCall != nil:
+		return genai.FunctionCall{Name: pj.FunctionCall.Name, Args: pj.FunctionCall.Args}, nil
+	case pj.FunctionResponse != nil:
+		return genai.FunctionResponse{Name: pj.FunctionResponse.Name, Response: pj.FunctionResponse.Response}, nil
+	default:
+		return nil, errors.New("part without text, inline data, function call or function response")
+	}
+}
+
+// sendSessionPrompt sends promptParts as the next message of the chat
+// session saved in the file at path, prints the reply and saves the session
+// with the new turn. If there's no reply, or it didn't finish normally (see
+// finishReasonError), the session file is left as it was.
+func sendSessionPrompt(cmd *cobra.Command, path string, promptParts []genai.Part) (err error) {
+	history, err := readSession(path)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := newCommandContext()
+	defer stop()
+	ctx, cancel := withRequestTimeout(ctx, cmd)
+	defer cancel()
+	client, err := newClient(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	modelName := mustGetStringFlag(cmd, "model")
+	model := client.GenerativeModel(modelName)
+	if err := configureModel(cmd, model); err != nil {
+		return err
+	}
+	applyCachedContent(cmd, model)
+	session := model.StartChat()
+	session.History = history
+
+	bw := bufio.NewWriter(cmd.OutOrStdout())
+	defer func() {
+		if flushErr := bw.Flush(); err == nil {
+			err = flushErr
+		}
+	}()
+
+	if mustGetBoolFlag(cmd, "echo") {
+		printEcho(bw, promptText(promptParts))
+	}
+	stream := mustGetBoolFlag(cmd, "stream") && !mustGetBoolFlag(cmd, "no-stream")
+	pp := newPartPrinter(cmd, stream, newBlobSaver(mustGetStringFlag(cmd, "output-dir")))
+
+	logRequest(model, modelName, len(promptParts))
+	start := time.Now()
+	var reply *genai.Candidate
+	if stream {
+		// The reply is flushed as it's streamed back.
+		flusher := &flushWriter{w: bw}
+		_, reply, err = streamChatReply(ctx, session, promptParts, flusher, pp)
+	} else {
+		// This is what session.SendMessage does, but the reply received so
+		// far is kept if the stream fails.
+		iter := session.SendMessageStream(ctx, promptParts...)
+		for err == nil {
+			_, err = iter.Next()
+		}
+		if err == iterator.Done {
+			err = nil
+		}
+		if resp := iter.MergedResponse(); resp != nil && len(resp.Candidates) > 0 {
+			reply = resp.Candidates[0]
+		}
+	}
+	logResponse(start)
+
+	// The finish reason comes with the last chunk of the reply, so a reply
+	// that was cut short is reported as such even if the stream fails after
+	// it.
+	var finishErr error
+	if reply != nil {
+		finishErr = finishReasonError(reply)
+	}
+	if err != nil && finishErr == nil {
+		return requestError(ctx, cmd, err)
+	}
+	if !stream {
+		if reply == nil || reply.Content == nil {
+			fmt.Fprintln(bw, "<empty response from model>")
+		} else {
+			for _, part := range reply.Content.Parts {
+				if err := pp.print(bw, part); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if !mustGetBoolFlag(cmd, "quiet") {
+		fmt.Fprintln(bw)
+	}
+	// A reply that was cut short isn't saved, so the session can go on from
+	// the turn before it.
+	if finishErr != nil {
+		return finishErr
+	}
+	return writeSession(path, session.History)
+}
+
+// flushWriter writes to w, flushing it after each write.
+type flushWriter struct {
+	w *bufio.Writer
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, fw.w.Flush()
+}
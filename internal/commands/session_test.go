@@ -0,0 +1,129 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSessionRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions", "chat.json")
+
+	// A missing file is a new session.
+	history, err := readSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Errorf("got %d messages for a new session, want none", len(history))
+	}
+
+	want := []*genai.Content{
+		{Role: "user", Parts: []genai.Part{genai.Text("describe this"), genai.Blob{MIMEType: "image/png", Data: pngData}}},
+		{Role: "model", Parts: []genai.Part{genai.Text("a puppy")}},
+	}
+	if err := writeSession(path, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("history mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPromptSessionRequest(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var request struct {
+		Contents []contentJSON `json:"contents"`
+	}
+	// Chat messages are sent with streamGenerateContent, even with --no-stream.
+	fakeBackend(t, func(path string, body string) string {
+		if !strings.HasSuffix(path, ":streamGenerateContent") {
+			t.Errorf("unexpected request %s: %s", path, body)
+			return `[]`
+		}
+		if err := json.Unmarshal([]byte(body), &request); err != nil {
+			t.Fatal(err)
+		}
+		// The reply is cut short, which fails the command with the same error
+		// whether or not the end of the stream is read cleanly.
+		return `[{"candidates": [{"content": {"role": "model", "parts": [{"text": "Madrid."}]}, "finishReason": 2}]}]`
+	})
+
+	sessionPath := filepath.Join(t.TempDir(), "chat.json")
+	if err := writeSession(sessionPath, []*genai.Content{
+		{Role: "user", Parts: []genai.Part{genai.Text("What's the capital of France?")}},
+		{Role: "model", Parts: []genai.Part{genai.Text("Paris.")}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Only the request is checked here: the earlier turns of the session are
+	// sent before the new message.
+	_, err := executeCommandErr("prompt", "--no-stream", "--session", sessionPath, "And of Spain?")
+	if err == nil || err.Error() != "response truncated: MAX_TOKENS" {
+		t.Errorf("got error %v, want the truncated reply reported", err)
+	}
+
+	var texts []string
+	for _, c := range request.Contents {
+		texts = append(texts, c.Role+": "+*c.Parts[0].Text)
+	}
+	want := []string{"user: What's the capital of France?", "model: Paris.", "user: And of Spain?"}
+	if diff := cmp.Diff(want, texts); diff != "" {
+		t.Errorf("request mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPromptSessionPartsSeparator(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeBackend(t, func(path string, body string) string {
+		// As in TestPromptSessionRequest, the reply is cut short so that the
+		// error doesn't depend on how the end of the stream is read.
+		return `[{"candidates": [{"content": {"role": "model", "parts": [{"text": "one"}, {"text": "two"}]}, "finishReason": 2}]}]`
+	})
+
+	sessionPath := filepath.Join(t.TempDir(), "chat.json")
+	out, err := executeCommandErr("prompt", "--session", sessionPath, "--parts-separator", "|", "hi")
+	if out != "one|two\n" {
+		t.Errorf("got output %q, want the parts separated by %q", out, "|")
+	}
+	if err == nil || err.Error() != "response truncated: MAX_TOKENS" {
+		t.Errorf("got error %v, want the truncated reply reported", err)
+	}
+
+	_, err = executeCommandErr("prompt", "--session", sessionPath, "--candidate-separator", "===", "hi")
+	if err == nil || !strings.Contains(err.Error(), "[session candidate-separator]") {
+		t.Errorf("got error %v for --candidate-separator with --session, want a flag conflict", err)
+	}
+}
+
+func TestPromptSessionFinishReason(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeBackend(t, func(path string, body string) string {
+		return `[{"candidates": [{"content": {"role": "model", "parts": [{"text": "Once upon"}]}, "finishReason": 2}]}]`
+	})
+
+	for _, args := range [][]string{{"--no-stream"}, nil} {
+		sessionPath := filepath.Join(t.TempDir(), "chat.json")
+		out, err := executeCommandErr(append([]string{"prompt", "--session", sessionPath, "tell me a story"}, args...)...)
+		if out != "Once upon\n" {
+			t.Errorf("%q: got output %q, want the truncated reply", args, out)
+		}
+		if err == nil || err.Error() != "response truncated: MAX_TOKENS" {
+			t.Errorf("%q: got error %v, want the truncated reply reported", args, err)
+		}
+		if _, err := os.Stat(sessionPath); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%q: got session file for a truncated reply (%v), want none", args, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// streamRetryPolicies lists the values of --stream-retry: what to do when a
+// response stream fails with a transient error (e.g. a dropped connection).
+//
+//   - "off": report the error.
+//   - "restart": send the request again, streaming the new response.
+//   - "fallback": send the request again without streaming.
+//
+// Either way, the retried response isn't printed again from the start: the
+// part that matches what was already printed is skipped.
+var streamRetryPolicies = []string{"off", "restart", "fallback"}
+
+// streamWithRetry streams the text of the response to parts to w like
+// streamResponse does (without JSON), and retries it once according to
+// policy, one of streamRetryPolicies, if the stream fails with a transient
+// error. The retry is made within the same ctx, so within the same timeout.
+func streamWithRetry(ctx context.Context, model *genai.GenerativeModel, parts []genai.Part, w *bufio.Writer, newline bool, policy string, pp *partPrinter) (*streamSummary, error) {
+	rw := &retryWriter{w: w}
+	out := bufio.NewWriter(rw)
+	summary, err := streamResponse(ctx, model, parts, out, false, newline, "", pp)
+	if err == nil || policy == "off" || ctx.Err() != nil || !isTransientError(err) {
+		return summary, err
+	}
+
+	log.Printf("WARNING: the response stream failed (%v); retrying it (--stream-retry %s)", err, policy)
+	rw.retry()
+	// The retried response is printed from its start, which retryWriter
+	// matches against the output so far, separators included.
+	pp.reset()
+	if policy == "restart" {
+		return streamResponse(ctx, model, parts, out, false, newline, "", pp)
+	}
+
+	resp, err := model.GenerateContent(ctx, parts...)
+	if err != nil {
+		return &streamSummary{}, err
+	}
+	summary = &streamSummary{usage: resp.UsageMetadata}
+	if len(resp.Candidates) < 1 || resp.Candidates[0].Content == nil {
+		fmt.Fprintln(out, "<empty response from model>")
+		return summary, out.Flush()
+	}
+	c := resp.Candidates[0]
+	summary.safetyRatings = c.SafetyRatings
+	for _, part := range c.Content.Parts {
+		if err := pp.print(out, part); err != nil {
+			return summary, err
+		}
+	}
+	if newline {
+		fmt.Fprintln(out)
+	}
+	if err := out.Flush(); err != nil {
+		return summary, err
+	}
+	return summary, finishReasonError(c)
+}
+
+// retryWriter writes a streamed response to w, flushing w after each write,
+// and remembers what it wrote. After retry is called, the output of the
+// retried response is only written once it goes beyond what was written
+// before. If the retried response turns out to differ from the earlier
+// output, it's written in full on a new line, with a warning.
+type retryWriter struct {
+	w        *bufio.Writer
+	written  []byte
+	retrying bool
+	matched  int
+}
+
+// retry starts skipping the output of a retried response that matches the
+// earlier output.
+func (rw *retryWriter) retry() {
+	rw.retrying = true
+	rw.matched = 0
+}
+
+func (rw *retryWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for rw.retrying && len(p) > 0 {
+		if rw.matched == len(rw.written) {
+			rw.retrying = false
+			break
+		}
+		if p[0] != rw.written[rw.matched] {
+			log.Printf("WARNING: the retried response differs from the output so far; printing it in full")
+			rw.retrying = false
+			fmt.Fprintln(rw.w)
+			rw.w.Write(rw.written[:rw.matched])
+			break
+		}
+		rw.matched++
+		p = p[1:]
+	}
+	if !rw.retrying {
+		rw.written = append(rw.written, p...)
+		if _, err := rw.w.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return n, rw.w.Flush()
+}
@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRetryWriter(t *testing.T) {
+	var tests = []struct {
+		name   string
+		before []string
+		after  []string
+		want   string
+	}{
+		{"continues", []string{"The sky ", "is bl"}, []string{"The s", "ky is blue", "."}, "The sky is blue."},
+		{"caught up at a write", []string{"abc"}, []string{"abc", "def"}, "abcdef"},
+		{"diverges", []string{"The sky ", "is bl"}, []string{"The sky was", " grey."}, "The sky is bl\nThe sky was grey."},
+	}
+	for _, tt := range tests {
+		var sb strings.Builder
+		w := bufio.NewWriter(&sb)
+		rw := &retryWriter{w: w}
+		for _, s := range tt.before {
+			fmt.Fprint(rw, s)
+		}
+		rw.retry()
+		for _, s := range tt.after {
+			fmt.Fprint(rw, s)
+		}
+		if sb.String() != tt.want {
+			t.Errorf("%s: got output %q, want %q", tt.name, sb.String(), tt.want)
+		}
+	}
+}
+
+func TestStreamRetry(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	// Streamed responses are a JSON array of chunks, sent as they're ready.
+	// The fake streams always fail after their first chunk.
+	chunk := `{"candidates": [{"content": {"role": "model", "parts": [{"text": "The sky is "}]}}]}`
+	numStreams := 0
+	fakeBackendHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, ":streamGenerateContent"):
+			numStreams++
+			fmt.Fprint(w, "["+chunk)
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		case strings.HasSuffix(r.URL.Path, ":generateContent"):
+			fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "The sky is blue."}]}, "finishReason": 1}]}`)
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+
+	// With fallback, the rest of the response comes from a non-streamed
+	// request.
+	got := executeCommand(t, "prompt", "why is the sky blue?", "--stream-retry", "fallback")
+	if want := "The sky is blue.\n"; got != want {
+		t.Errorf("--stream-retry fallback: got output %q, want %q", got, want)
+	}
+
+	// With restart, the stream is retried once; the part of the retried stream
+	// that was already printed isn't printed again.
+	numStreams = 0
+	got, err := executeCommandErr("prompt", "why is the sky blue?", "--stream-retry", "restart")
+	if err == nil {
+		t.Errorf("--stream-retry restart: got no error for a failing stream")
+	}
+	if want := "The sky is "; got != want {
+		t.Errorf("--stream-retry restart: got output %q, want %q", got, want)
+	}
+	if numStreams != 2 {
+		t.Errorf("--stream-retry restart: got %d streams, want 2", numStreams)
+	}
+}
@@ -2,30 +2,53 @@ package commands
 
 import (
 	"bufio"
-	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"strconv"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"text/template"
 
 	"github.com/google/generative-ai-go/genai"
 	"github.com/spf13/cobra"
-	"google.golang.org/api/iterator"
 )
 
 var templates = make(map[string]string)
 
-const (
-	templateFilePath = "/.config/gemini-cli-templates"
-)
+// templatesFilePath returns the path of the templates file: the value of the
+// --templates-file flag if set, otherwise of the GEMINI_CLI_TEMPLATES env var,
+// and otherwise .config/gemini-cli-templates under the home directory (which
+// is created if needed).
+func templatesFilePath(cmd *cobra.Command) (string, error) {
+	if path := mustGetStringFlag(cmd, "templates-file"); path != "" {
+		return path, nil
+	}
+	if path := os.Getenv("GEMINI_CLI_TEMPLATES"); path != "" {
+		return path, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(homeDir, ".config")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gemini-cli-templates"), nil
+}
 
 var templateCmd = &cobra.Command{
 	Use:     "template",
 	Aliases: []string{"t"},
 	Short:   "Send a prompt with templates",
 	Long:    strings.TrimSpace(templateUsage),
-	Run:     runTemplateCmd,
+	PreRunE: preRunModel(map[string][]string{
+		"stream-retry": streamRetryPolicies,
+	}),
+	RunE: runTemplateCmd,
 }
 
 var templateUsage = `
@@ -36,11 +59,25 @@ for example, "translate %s to english, and give me detailed explanations".
 You can add a template with "-a key", and use it with "-u key".
 The text args will be inserted into the template.
 
+Templates can also have named placeholders, written as {{.name}} (this is Go's
+text/template syntax), for example "translate {{.text}} from {{.source}} to
+english". Their values are passed with --var, e.g. --var source=French; it can
+be repeated for several variables. Named and %s placeholders can be mixed.
+A template without named placeholders is taken literally when no --var is
+given, so braces in it (e.g. a Jinja snippet) are kept as they are; otherwise
+a literal "{{" is written as {{"{{"}}.
+
+As with "prompt", an argument of the form "@path" is replaced by the text of
+the file at path; the text is inserted into the template like other text args.
+
 Except for the template part of this command, 
 the other usages are the same as "prompt" command.
 
-You can also edit /.config/gemini-cli-templates in your home directory directly,
-just add a line with "key:value" format.
+Templates can be deleted with "-d key" and renamed with "--rename old,new".
+You can also edit .config/gemini-cli-templates in your home directory directly,
+just add a line with "key:value" format. A different templates file (e.g. one
+per project) can be selected with --templates-file, or with the
+GEMINI_CLI_TEMPLATES env var.
 
 `
 
@@ -49,19 +86,24 @@ func init() {
 
 	templateCmd.Flags().StringP("add", "a", "", "add a template with a key")
 	templateCmd.Flags().StringP("use", "u", "", "use a template")
-	templateCmd.Flags().Bool("stream", true, "stream the response from the model")
-	templateCmd.Flags().String("temp", "", "temperature setting for the model")
+	templateCmd.Flags().StringArray("var", nil, "value of a named template placeholder, as name=value; can be repeated")
+	templateCmd.Flags().String("templates-file", "", "path of the templates file; overrides the GEMINI_CLI_TEMPLATES env var and the default location")
+	templateCmd.Flags().Bool("strict", false, "fail instead of warning when the number of text args doesn't match the template's %s placeholders")
+	addGenerateFlags(templateCmd)
+	addModelFlags(templateCmd)
 	templateCmd.Flags().BoolP("list", "l", false, "list templates")
-	templateCmd.Flags().StringP("del", "d", "", "delete a template")
-	//read config to get templates
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatal(err)
-	}
+	templateCmd.Flags().StringP("delete", "d", "", "delete a template")
+	templateCmd.Flags().String("rename", "", "rename a template, given as <old key>,<new key>")
+	setFlagAliases(templateCmd, map[string]string{"del": "delete"})
+	templateCmd.RegisterFlagCompletionFunc("use", completeTemplateKeys)
+	templateCmd.RegisterFlagCompletionFunc("delete", completeTemplateKeys)
+}
 
-	file, err := os.OpenFile(homeDir+templateFilePath, os.O_CREATE|os.O_RDONLY, 0644)
+// loadTemplates reads the templates file at filePath into templates.
+func loadTemplates(filePath string) error {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDONLY, 0644)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer file.Close()
 	scanner := bufio.NewScanner(file)
@@ -74,52 +116,85 @@ func init() {
 			templates[key] = value
 		}
 	}
+	return scanner.Err()
 }
 
-func runTemplateCmd(cmd *cobra.Command, args []string) {
-	delFlag := mustGetStringFlag(cmd, "del")
-	if delFlag != "" {
-		if templates[delFlag] != "" {
-			delTemplate(delFlag)
-			return
+func runTemplateCmd(cmd *cobra.Command, args []string) error {
+	filePath, err := templatesFilePath(cmd)
+	if err != nil {
+		return err
+	}
+	if err := loadTemplates(filePath); err != nil {
+		return err
+	}
+
+	if delKey := mustGetStringFlag(cmd, "delete"); delKey != "" {
+		if _, ok := templates[delKey]; !ok {
+			return fmt.Errorf("no template with key '%s'", delKey)
 		}
-		return
+		return delTemplate(filePath, delKey)
+	}
+
+	if renamePair := mustGetStringFlag(cmd, "rename"); renamePair != "" {
+		oldKey, newKey, ok := strings.Cut(renamePair, ",")
+		oldKey = strings.TrimSpace(oldKey)
+		newKey = strings.TrimSpace(newKey)
+		if !ok || oldKey == "" || newKey == "" {
+			return fmt.Errorf("expect <old key>,<new key> for --rename, got %q", renamePair)
+		}
+		if _, ok := templates[oldKey]; !ok {
+			return fmt.Errorf("no template with key '%s'", oldKey)
+		}
+		if _, ok := templates[newKey]; ok {
+			return fmt.Errorf("a template with key '%s' already exists", newKey)
+		}
+		return renameTemplate(filePath, oldKey, newKey)
 	}
 
 	if mustGetBoolFlag(cmd, "list") {
 		for key, value := range templates {
-			fmt.Printf("%s\t:%s\n", key, value)
+			fmt.Fprintf(cmd.OutOrStdout(), "%s\t:%s\n", key, value)
 		}
-		return
+		return nil
 	}
 
 	addKey := mustGetStringFlag(cmd, "add")
 	if addKey != "" && len(args) == 1 {
-		addTemplate(addKey, args[0])
-		return
+		return addTemplate(filePath, addKey, args[0])
 	}
 
 	//if don't use template, run prompt mode
 	useKey := mustGetStringFlag(cmd, "use")
 	if useKey == "" {
-		cmd.Flags().String("system", "", "")
-		runPromptCmd(cmd, args)
+		return sendPrompt(cmd, args)
 	} else {
 		promptParts := []genai.Part{}
 		template := templates[useKey]
 		textPrompt := []string{}
 
 		for _, arg := range args {
-			if argLooksLikeURL(arg) {
-				part, err := getPartFromURL(arg)
+			if path, ok := promptFileArg(arg); ok {
+				text, err := readPromptFile(path)
+				if err != nil {
+					return err
+				}
+				textPrompt = append(textPrompt, text)
+			} else if argIsDataURL(arg) {
+				part, err := getPartFromDataURL(arg)
 				if err != nil {
-					log.Fatal(err)
+					return err
+				}
+				promptParts = append(promptParts, part)
+			} else if argLooksLikeURL(arg) {
+				part, err := getPartFromURL(arg, downloadLimitsFromFlags(cmd))
+				if err != nil {
+					return err
 				}
 				promptParts = append(promptParts, part)
 			} else if argLooksLikeFilename(arg) {
 				part, err := getPartFromFile(arg)
 				if err != nil {
-					log.Fatal(err)
+					return err
 				}
 				promptParts = append(promptParts, part)
 			} else {
@@ -127,167 +202,189 @@ func runTemplateCmd(cmd *cobra.Command, args []string) {
 			}
 		}
 
-		placeholdersCnt := strings.Count(template, "%s")
-		for _, text := range textPrompt {
-			if placeholdersCnt > 0 {
-				template = strings.Replace(template, "%s", text, 1)
-				placeholdersCnt--
-			} else {
-				break
-			}
+		vars, err := parseTemplateVars(mustGetStringArrayFlag(cmd, "var"))
+		if err != nil {
+			return err
 		}
-		promptParts = append(promptParts, genai.Text(template))
-
-		ctx := context.Background()
-		client, err := newGenaiClient(ctx, cmd)
+		prompt, unfilled, unused, err := expandTemplate(template, textPrompt, vars)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("template %s: %w", useKey, err)
+		}
+		var problems []string
+		if unfilled > 0 {
+			problems = append(problems, fmt.Sprintf("%d placeholder(s) left unfilled", unfilled))
+		}
+		if unused > 0 {
+			problems = append(problems, fmt.Sprintf("%d text argument(s) beyond the template's placeholders were ignored", unused))
 		}
-		defer client.Close()
+		if len(problems) > 0 {
+			msg := fmt.Sprintf("template %s: %s", useKey, strings.Join(problems, "; "))
+			if mustGetBoolFlag(cmd, "strict") {
+				return errors.New(msg)
+			}
+			log.Printf("WARNING: %s", msg)
+		}
+		promptParts = append(promptParts, genai.Text(prompt))
 
-		model := client.GenerativeModel(mustGetStringFlag(cmd, "model"))
+		return generateContent(cmd, promptParts)
+	}
+}
 
-		if tempValue := mustGetStringFlag(cmd, "temp"); tempValue != "" {
-			f, err := strconv.ParseFloat(tempValue, 32)
-			if err != nil {
-				log.Fatalf("problem parsing --temp value: %v", err)
-			}
-			model.SetTemperature(float32(f))
+// parseTemplateVars parses the values of the --var flag, each a name=value
+// pair, into a map.
+func parseTemplateVars(values []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, v := range values {
+		name, value, ok := strings.Cut(v, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("expect name=value for --var, got %q", v)
 		}
+		vars[name] = value
+	}
+	return vars, nil
+}
 
-		model.SafetySettings = []*genai.SafetySetting{
-			{
-				Category:  genai.HarmCategoryDangerousContent,
-				Threshold: genai.HarmBlockNone,
-			},
-			{
-				Category:  genai.HarmCategoryHarassment,
-				Threshold: genai.HarmBlockNone,
-			},
-			{
-				Category:  genai.HarmCategoryHateSpeech,
-				Threshold: genai.HarmBlockNone,
-			},
-			{
-				Category:  genai.HarmCategorySexuallyExplicit,
-				Threshold: genai.HarmBlockNone,
-			},
+// namedPlaceholderRegexp matches the start of a named {{.name}} placeholder,
+// which makes a template go through text/template.
+var namedPlaceholderRegexp = regexp.MustCompile(`\{\{-?\s*\.`)
+
+// expandTemplate fills in the placeholders of tmpl: named {{.name}}
+// placeholders are replaced with the values in vars, and then each %s is
+// replaced by the next of the texts, in order. Named placeholders are filled
+// in first so that the texts are never interpreted as template syntax.
+// Templates without named placeholders are only parsed as text/template if
+// there are vars, so older %s templates that have literal braces (e.g. a
+// Jinja snippet) are kept as they are. Besides the expanded template, it
+// returns the number of %s placeholders left unfilled and the number of texts
+// left unused.
+func expandTemplate(tmpl string, texts []string, vars map[string]string) (string, int, int, error) {
+	if namedPlaceholderRegexp.MatchString(tmpl) || len(vars) > 0 {
+		t, err := template.New("").Option("missingkey=error").Parse(tmpl)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		var sb strings.Builder
+		if err := t.Execute(&sb, vars); err != nil {
+			return "", 0, 0, err
 		}
+		tmpl = sb.String()
+	}
 
-		if stream := mustGetBoolFlag(cmd, "stream"); stream {
-			iter := model.GenerateContentStream(ctx, promptParts...)
-			for {
-				resp, err := iter.Next()
-				if err == iterator.Done {
-					break
-				}
-				if err != nil {
-					log.Fatal(err)
-				}
-				if len(resp.Candidates) < 1 {
-					fmt.Println("<empty response from model>")
-				} else {
-					c := resp.Candidates[0]
-					if c.Content != nil {
-						for _, part := range c.Content.Parts {
-							fmt.Print(part)
-						}
-					} else {
-						fmt.Println("<empty response from model>")
-					}
-				}
-			}
-			fmt.Println()
+	// Split around the placeholders rather than replacing them one by one, so
+	// that a %s inside a text isn't taken as a placeholder.
+	pieces := strings.Split(tmpl, "%s")
+	placeholdersCnt := len(pieces) - 1
+	var sb strings.Builder
+	sb.WriteString(pieces[0])
+	for i, piece := range pieces[1:] {
+		if i < len(texts) {
+			sb.WriteString(texts[i])
 		} else {
-			resp, err := model.GenerateContent(ctx, promptParts...)
-			if err != nil {
-				log.Fatal(err)
-			}
-			if len(resp.Candidates) < 1 {
-				fmt.Println("<empty response from model>")
-			} else {
-				c := resp.Candidates[0]
-				if c.Content != nil {
-					for _, part := range c.Content.Parts {
-						fmt.Println(part)
-					}
-				} else {
-					fmt.Println("<empty response from model>")
-				}
-			}
+			sb.WriteString("%s")
 		}
+		sb.WriteString(piece)
 	}
+	return sb.String(), max(placeholdersCnt-len(texts), 0), max(len(texts)-placeholdersCnt, 0), nil
 }
 
-func delTemplate(delFlag string) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatal(err)
-	}
+// delTemplate removes the template with the given key from the templates file
+// at filePath.
+func delTemplate(filePath string, delKey string) error {
+	return rewriteTemplates(filePath, func(key, line string) (string, bool) {
+		return line, key != delKey
+	})
+}
 
-	filePath := homeDir + templateFilePath
-	file, err := os.OpenFile(filePath, os.O_RDWR, 0644)
+// renameTemplate changes the key of the template oldKey to newKey in the
+// templates file at filePath, keeping its position.
+func renameTemplate(filePath string, oldKey, newKey string) error {
+	return rewriteTemplates(filePath, func(key, line string) (string, bool) {
+		if key != oldKey {
+			return line, true
+		}
+		_, value, _ := strings.Cut(line, ":")
+		return newKey + ":" + value, true
+	})
+}
+
+// rewriteTemplates rewrites the templates file at filePath, passing each template line
+// and its key to edit; edit returns the line to write in its place, or false
+// to drop it. Lines that aren't templates are kept as they are. The new
+// contents are written to a temporary file first, which then replaces the
+// templates file.
+func rewriteTemplates(filePath string, edit func(key, line string) (string, bool)) error {
+	file, err := os.Open(filePath)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer file.Close()
 
 	temporaryFilePath := filePath + ".tmp"
-	tempFile, err := os.OpenFile(temporaryFilePath, os.O_CREATE|os.O_RDWR, 0644)
+	tempFile, err := os.OpenFile(temporaryFilePath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer tempFile.Close()
+	// fail removes the temporary file, which has to be closed first on
+	// Windows, and returns err.
+	fail := func(err error) error {
+		tempFile.Close()
+		os.Remove(temporaryFilePath)
+		return err
+	}
 
 	scanner := bufio.NewScanner(file)
 	writer := bufio.NewWriter(tempFile)
-	defer writer.Flush()
 
 	for scanner.Scan() {
 		line := scanner.Text()
-		key, _, ok := strings.Cut(line, ":")
-		if ok {
-			key := strings.TrimSpace(key)
-			if key != delFlag {
-				_, err := fmt.Fprintln(writer, line)
-				if err != nil {
-					os.Remove(temporaryFilePath)
-					log.Fatal("problem happened while writing to file:", err)
-					return
-				}
+		if key, _, ok := strings.Cut(line, ":"); ok {
+			var keep bool
+			line, keep = edit(strings.TrimSpace(key), line)
+			if !keep {
+				continue
 			}
 		}
+		_, err := fmt.Fprintln(writer, line)
+		if err != nil {
+			return fail(fmt.Errorf("problem happened while writing to file: %w", err))
+		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		os.Remove(temporaryFilePath)
-		log.Fatal("problem happened while reading file:", err)
-		return
+		return fail(fmt.Errorf("problem happened while reading file: %w", err))
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fail(fmt.Errorf("problem happened while writing to file: %w", err))
 	}
 
+	// Both files are closed before the rename, which fails on Windows while
+	// either of them is open.
+	if err := tempFile.Close(); err != nil {
+		return fail(fmt.Errorf("problem happened while writing to file: %w", err))
+	}
+	file.Close()
 	if err := os.Rename(temporaryFilePath, filePath); err != nil {
 		os.Remove(temporaryFilePath)
-		log.Fatal("problem happened while renaming file:", err)
-		return
+		return fmt.Errorf("problem happened while renaming file: %w", err)
 	}
-
+	return nil
 }
 
-func addTemplate(key string, value string) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	file, err := os.OpenFile(homeDir+templateFilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+// addTemplate appends a template with the given key and value to the
+// templates file at filePath.
+func addTemplate(filePath string, key string, value string) error {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer file.Close()
 	writer := bufio.NewWriter(file)
-	defer writer.Flush()
 	_, err = writer.WriteString(fmt.Sprintf("%s:%s\n", key, value))
 	if err != nil {
-		log.Fatal("problem happened while writing to file:", err)
+		return fmt.Errorf("problem happened while writing to file: %w", err)
 	}
+	return writer.Flush()
 }
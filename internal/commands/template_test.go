@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/cobra"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	var tests = []struct {
+		tmpl         string
+		texts        []string
+		vars         map[string]string
+		want         string
+		wantUnfilled int
+		wantUnused   int
+	}{
+		{"translate %s to english", []string{"bonjour"}, nil, "translate bonjour to english", 0, 0},
+		{"%s and %s", []string{"a", "b", "c"}, nil, "a and b", 0, 1},
+		{"%s and %s and %s", []string{"a"}, nil, "a and %s and %s", 2, 0},
+		{"translate from {{.source}}", nil, map[string]string{"source": "French"}, "translate from French", 0, 0},
+		{"translate %s from {{.source}}", []string{"bonjour"}, map[string]string{"source": "French"}, "translate bonjour from French", 0, 0},
+		// Texts aren't interpreted as template syntax or placeholders.
+		{"say %s", []string{"{{.source}}"}, map[string]string{"source": "French"}, "say {{.source}}", 0, 0},
+		{"%s then %s", []string{"100%s", "b"}, nil, "100%s then b", 0, 0},
+		{"no placeholders", []string{"x"}, map[string]string{"y": "z"}, "no placeholders", 0, 1},
+		// Without named placeholders or vars, braces are literal text.
+		{"render {{ name }} and {% if x %} in %s", []string{"jinja"}, nil, "render {{ name }} and {% if x %} in jinja", 0, 0},
+		{"{{ .source }} and {{- .dest}}", nil, map[string]string{"source": "a", "dest": "b"}, "a andb", 0, 0},
+	}
+
+	for _, tt := range tests {
+		got, unfilled, unused, err := expandTemplate(tt.tmpl, tt.texts, tt.vars)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.tmpl, err)
+		}
+		if got != tt.want || unfilled != tt.wantUnfilled || unused != tt.wantUnused {
+			t.Errorf("%q: got (%q, %d, %d), want (%q, %d, %d)",
+				tt.tmpl, got, unfilled, unused, tt.want, tt.wantUnfilled, tt.wantUnused)
+		}
+	}
+
+	for _, bad := range []string{"from {{.source}}", "from {{.source", "{{ name }} with vars"} {
+		if _, _, _, err := expandTemplate(bad, nil, map[string]string{"other": "x"}); err == nil {
+			t.Errorf("%q: got no error, want error", bad)
+		}
+	}
+}
+
+func TestParseTemplateVars(t *testing.T) {
+	got, err := parseTemplateVars([]string{"source=French", "expr=a=b", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"source": "French", "expr": "a=b", "empty": ""}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("vars mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, bad := range []string{"source", "=French"} {
+		if _, err := parseTemplateVars([]string{bad}); err == nil {
+			t.Errorf("%q: got no error, want error", bad)
+		}
+	}
+}
+
+func TestTemplatesFilePath(t *testing.T) {
+	addFlags := func(cmd *cobra.Command) { cmd.Flags().String("templates-file", "", "") }
+
+	homeDir := t.TempDir()
+	// os.UserHomeDir reads USERPROFILE on Windows and home on Plan 9.
+	t.Setenv("HOME", homeDir)
+	t.Setenv("USERPROFILE", homeDir)
+	t.Setenv("home", homeDir)
+	t.Setenv("GEMINI_CLI_TEMPLATES", "")
+
+	got, err := templatesFilePath(parseFlagsCmd(t, addFlags))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(homeDir, ".config", "gemini-cli-templates")
+	if got != want {
+		t.Errorf("got path %q, want %q", got, want)
+	}
+
+	// The directory of the templates file is created if it doesn't exist.
+	if fi, err := os.Stat(filepath.Dir(got)); err != nil || !fi.IsDir() {
+		t.Errorf("expect directory %s to exist, got error %v", filepath.Dir(got), err)
+	}
+
+	// The env var overrides the default, and the flag overrides both.
+	envPath := filepath.Join(homeDir, "project", "templates")
+	t.Setenv("GEMINI_CLI_TEMPLATES", envPath)
+	if got, err := templatesFilePath(parseFlagsCmd(t, addFlags)); err != nil || got != envPath {
+		t.Errorf("got (%q, %v), want %q", got, err, envPath)
+	}
+	flagPath := filepath.Join(homeDir, "other-templates")
+	if got, err := templatesFilePath(parseFlagsCmd(t, addFlags, "--templates-file", flagPath)); err != nil || got != flagPath {
+		t.Errorf("got (%q, %v), want %q", got, err, flagPath)
+	}
+}
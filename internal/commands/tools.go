@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// functionDeclaration is the JSON form of a genai.FunctionDeclaration in a
+// --tools file. The parameters are given as a JSON schema, with the same
+// keywords as --response-schema.
+type functionDeclaration struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Parameters  *jsonSchema `json:"parameters"`
+}
+
+// loadTools reads the function declarations in the --tools file at path: a
+// JSON array of objects with a name, a description and parameters.
+func loadTools(path string) ([]*genai.Tool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tools: %w", err)
+	}
+	tools, err := parseTools(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return tools, nil
+}
+
+// parseTools parses function declarations from data; see loadTools.
+func parseTools(data []byte) ([]*genai.Tool, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var fds []functionDeclaration
+	if err := dec.Decode(&fds); err != nil {
+		return nil, fmt.Errorf("invalid tools: %w", err)
+	}
+
+	tool := &genai.Tool{}
+	for i, fd := range fds {
+		if fd.Name == "" {
+			return nil, fmt.Errorf("invalid tools: function %d has no name", i+1)
+		}
+		params, err := fd.Parameters.toGenai("")
+		if err != nil {
+			return nil, fmt.Errorf("function %s: %w", fd.Name, err)
+		}
+		tool.FunctionDeclarations = append(tool.FunctionDeclarations, &genai.FunctionDeclaration{
+			Name:        fd.Name,
+			Description: fd.Description,
+			Parameters:  params,
+		})
+	}
+	if len(tool.FunctionDeclarations) == 0 {
+		return nil, errors.New("invalid tools: no functions declared")
+	}
+	return []*genai.Tool{tool}, nil
+}
+
+// readToolResponses prints the function calls requested by the model to w,
+// and reads the response to each one from r: a line with a JSON object, or
+// any other text, which is sent as the "result" of the call.
+func readToolResponses(r *bufio.Reader, w io.Writer, calls []genai.FunctionCall) ([]genai.Part, error) {
+	var parts []genai.Part
+	for _, call := range calls {
+		args, err := json.Marshal(call.Args)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(w, "[tool call] %s %s\n", call.Name, args)
+		fmt.Fprintf(w, "%s response> ", call.Name)
+
+		line, err := r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, fmt.Errorf("error reading response for tool call %s: %w", call.Name, err)
+		}
+		line = strings.TrimSpace(line)
+
+		var response map[string]any
+		if err := json.Unmarshal([]byte(line), &response); err != nil {
+			response = map[string]any{"result": line}
+		}
+		parts = append(parts, genai.FunctionResponse{Name: call.Name, Response: response})
+	}
+	return parts, nil
+}
@@ -0,0 +1,79 @@
+package commands
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseTools(t *testing.T) {
+	tools, err := parseTools([]byte(`[
+		{"name": "get_weather", "description": "get the weather in a city",
+		 "parameters": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}},
+		{"name": "get_time"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*genai.Tool{{FunctionDeclarations: []*genai.FunctionDeclaration{
+		{
+			Name:        "get_weather",
+			Description: "get the weather in a city",
+			Parameters: &genai.Schema{
+				Type:       genai.TypeObject,
+				Properties: map[string]*genai.Schema{"city": {Type: genai.TypeString}},
+				Required:   []string{"city"},
+			},
+		},
+		{Name: "get_time"},
+	}}}
+	if diff := cmp.Diff(want, tools); diff != "" {
+		t.Errorf("tools mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, data := range []string{
+		`{"name": "not_an_array"}`,
+		`[]`,
+		`[{"description": "no name"}]`,
+		`[{"name": "f", "unknown": 1}]`,
+		`[{"name": "f", "parameters": {"type": "bogus"}}]`,
+	} {
+		if _, err := parseTools([]byte(data)); err == nil {
+			t.Errorf("%s: got no error, want error", data)
+		}
+	}
+}
+
+func TestReadToolResponses(t *testing.T) {
+	calls := []genai.FunctionCall{
+		{Name: "get_weather", Args: map[string]any{"city": "Paris"}},
+		{Name: "get_time"},
+	}
+	r := bufio.NewReader(strings.NewReader("{\"temp\": 20}\n12:30\n"))
+	var out strings.Builder
+	got, err := readToolResponses(r, &out, calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOut := "[tool call] get_weather {\"city\":\"Paris\"}\nget_weather response> [tool call] get_time null\nget_time response> "
+	if out.String() != wantOut {
+		t.Errorf("got output %q, want %q", out.String(), wantOut)
+	}
+	want := []genai.Part{
+		genai.FunctionResponse{Name: "get_weather", Response: map[string]any{"temp": float64(20)}},
+		genai.FunctionResponse{Name: "get_time", Response: map[string]any{"result": "12:30"}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("responses mismatch (-want +got):\n%s", diff)
+	}
+
+	// Running out of input before all calls got a response is an error.
+	r = bufio.NewReader(strings.NewReader("{\"temp\": 20}\n"))
+	if _, err := readToolResponses(r, io.Discard, calls); err == nil {
+		t.Errorf("got no error for missing response, want error")
+	}
+}
@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// logLevel is the level of messages logged with verboseLog. Only warnings are
+// logged by default; --verbose lowers it to include debug messages.
+var logLevel = func() *slog.LevelVar {
+	var lv slog.LevelVar
+	lv.Set(slog.LevelWarn)
+	return &lv
+}()
+
+// verboseLog logs details about the requests made by commands to stderr.
+var verboseLog = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+
+// logRequest logs (at debug level) the configuration of model and the number
+// of prompt parts about to be sent to it.
+func logRequest(model *genai.GenerativeModel, modelName string, numParts int) {
+	attrs := []any{slog.String("model", modelName), slog.Int("parts", numParts)}
+	if si := model.SystemInstruction; si != nil {
+		var sb strings.Builder
+		for _, part := range si.Parts {
+			if t, ok := part.(genai.Text); ok {
+				sb.WriteString(string(t))
+			}
+		}
+		attrs = append(attrs, slog.String("system", sb.String()))
+	}
+
+	var config []any
+	gc := model.GenerationConfig
+	if gc.Temperature != nil {
+		config = append(config, slog.Any("temperature", *gc.Temperature))
+	}
+	if gc.TopP != nil {
+		config = append(config, slog.Any("top_p", *gc.TopP))
+	}
+	if gc.TopK != nil {
+		config = append(config, slog.Any("top_k", *gc.TopK))
+	}
+	if gc.MaxOutputTokens != nil {
+		config = append(config, slog.Any("max_output_tokens", *gc.MaxOutputTokens))
+	}
+	if len(gc.StopSequences) > 0 {
+		config = append(config, slog.Any("stop_sequences", gc.StopSequences))
+	}
+	if gc.CandidateCount != nil {
+		config = append(config, slog.Any("candidate_count", *gc.CandidateCount))
+	}
+	if gc.ResponseMIMEType != "" {
+		config = append(config, slog.String("response_mime_type", gc.ResponseMIMEType))
+	}
+	if len(config) > 0 {
+		attrs = append(attrs, slog.Group("config", config...))
+	}
+
+	verboseLog.Debug("sending request", attrs...)
+}
+
+// logResponse logs (at debug level) how long the request started at start
+// took to get a response.
+func logResponse(start time.Time) {
+	verboseLog.Debug("received response", slog.Duration("latency", time.Since(start)))
+}
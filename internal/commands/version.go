@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/eliben/gemini-cli/internal/version"
+	"github.com/spf13/cobra"
+)
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the version and build information of gemini-cli",
+	Long:  strings.TrimSpace(versionUsage),
+	Args:  cobra.ExactArgs(0),
+	RunE:  runVersionCmd,
+}
+
+var versionUsage = `
+Print the version of gemini-cli, along with the git commit and date it was
+built from, and the Go version and platform it was built with. Please include
+this in bug reports.
+
+The commit and date are set when building with -ldflags; otherwise they're
+taken from the VCS information Go records in the binary, when it's built from
+a git checkout. Either may be unknown, e.g. for a binary installed with
+'go install' from the module proxy.
+
+With --json, the information is emitted as a JSON object.
+`
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+	versionCmd.Flags().Bool("json", false, "emit the version information as JSON")
+}
+
+func runVersionCmd(cmd *cobra.Command, args []string) error {
+	info := version.Info()
+	if mustGetBoolFlag(cmd, "json") {
+		return json.NewEncoder(cmd.OutOrStdout()).Encode(info)
+	}
+
+	commit := info.Commit
+	if commit == "" {
+		commit = "unknown"
+	} else if info.Modified {
+		commit += " (modified)"
+	}
+	date := info.Date
+	if date == "" {
+		date = "unknown"
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Version:\t%s\n", info.Version)
+	fmt.Fprintf(w, "Commit:\t%s\n", commit)
+	fmt.Fprintf(w, "Built:\t%s\n", date)
+	fmt.Fprintf(w, "Go:\t%s %s\n", info.GoVersion, info.Platform)
+	return w.Flush()
+}
@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+// vertexEndpoint is the value of --endpoint that selects Vertex AI, with the
+// project and location given by --project and --location.
+const vertexEndpoint = "vertex"
+
+// vertexScope is the OAuth scope requests to Vertex AI are authorized with.
+const vertexScope = "https://www.googleapis.com/auth/cloud-platform"
+
+var (
+	vertexProjectRegexp  = regexp.MustCompile(`^[a-z0-9][a-z0-9.:-]*$`)
+	vertexLocationRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+)
+
+// vertexHost returns the host of the Vertex AI API for location: the regional
+// one, or the global one for the "global" location.
+func vertexHost(location string) string {
+	if location == "global" {
+		return "aiplatform.googleapis.com"
+	}
+	return location + "-aiplatform.googleapis.com"
+}
+
+// newVertexClient creates a genai.Client that talks to Vertex AI in project
+// and location. The requests are authorized with the service account
+// credentials in credsFile if it's set, and with the application default
+// credentials (e.g. from 'gcloud auth application-default login') otherwise.
+func newVertexClient(ctx context.Context, project string, location string, credsFile string) (*genai.Client, error) {
+	if project == "" || location == "" {
+		return nil, errors.New("--endpoint vertex needs both --project and --location")
+	}
+	if !vertexProjectRegexp.MatchString(project) {
+		return nil, fmt.Errorf("invalid --project %q", project)
+	}
+	if !vertexLocationRegexp.MatchString(location) {
+		return nil, fmt.Errorf("invalid --location %q; expect a region like us-central1, or global", location)
+	}
+
+	authOpts := []option.ClientOption{option.WithScopes(vertexScope)}
+	if credsFile != "" {
+		b, err := os.ReadFile(credsFile)
+		if err != nil {
+			return nil, &authError{fmt.Errorf("error reading credentials file: %w", err)}
+		}
+		authOpts = append(authOpts, option.WithCredentialsJSON(b))
+	}
+	authClient, _, err := htransport.NewClient(ctx, authOpts...)
+	if err != nil {
+		return nil, &authError{fmt.Errorf("error finding credentials for Vertex AI: %w", err)}
+	}
+
+	c := &http.Client{Transport: &vertexRoundTripper{
+		project:  project,
+		location: location,
+		base:     authClient.Transport,
+	}}
+	// The auth options are passed on too, for the parts of genai.Client that
+	// don't use the given HTTP client.
+	opts := append(authOpts, option.WithEndpoint("https://"+vertexHost(location)), option.WithHTTPClient(c))
+	return genai.NewClient(ctx, opts...)
+}
+
+// vertexRoundTripper sends the requests of a genai.Client, which are meant
+// for the Gemini API, to the equivalent methods of the publisher models of
+// Vertex AI in a project and location.
+type vertexRoundTripper struct {
+	project  string
+	location string
+
+	// base sends the rewritten requests, with their authorization.
+	base http.RoundTripper
+}
+
+func (t *vertexRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	path, err := t.vertexPath(req.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+	newReq := req.Clone(req.Context())
+	newReq.URL.Path = path
+	newReq.URL.RawPath = ""
+	return t.base.RoundTrip(newReq)
+}
+
+// vertexPath maps the path of a Gemini API method on a model, like
+// "/v1beta/models/gemini-1.5-flash:generateContent", to the path of the same
+// method in Vertex AI. Other methods (e.g. listing models) aren't supported.
+func (t *vertexRoundTripper) vertexPath(path string) (string, error) {
+	_, rest, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	model, ok2 := strings.CutPrefix(rest, "models/")
+	if !ok || !ok2 || !strings.Contains(model, ":") || strings.Contains(model, "/") {
+		return "", fmt.Errorf("%s isn't supported with --endpoint vertex", path)
+	}
+	return fmt.Sprintf("/v1beta1/projects/%s/locations/%s/publishers/google/models/%s", t.project, t.location, model), nil
+}
@@ -108,8 +108,6 @@ func LoadTable(r io.Reader, format Format) (Format, Table, error) {
 	default:
 		panic("format should be known here")
 	}
-
-	return format, nil, nil
 }
 
 func loadFromDelimeterSeparated(r io.Reader, format Format) (Format, Table, error) {
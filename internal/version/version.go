@@ -1,3 +1,67 @@
 package version
 
-const Version = "v0.8.0"
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version is the version of gemini-cli, bumped for each release. Like Commit
+// and Date, it can be set when building, with e.g.
+//
+//	go build -ldflags "-X github.com/eliben/gemini-cli/internal/version.Commit=$(git rev-parse HEAD)"
+var Version = "v0.8.0"
+
+// Commit and Date are the git commit gemini-cli was built from and the date
+// of the build. When they aren't set with -ldflags, Info takes them from the
+// VCS information the go command embeds in the binary, if there's any.
+var (
+	Commit string
+	Date   string
+)
+
+// BuildInfo describes the build of the running binary.
+type BuildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	// Modified is set if the binary was built from a working tree with
+	// uncommitted changes.
+	Modified  bool   `json:"modified,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+// Info returns the BuildInfo of the running binary.
+func Info() BuildInfo {
+	info := BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	// The VCS time is the time of the commit rather than of the build, but
+	// it's the closest thing to a build date without -ldflags.
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = s.Value
+			}
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}